@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math/bits"
+	"time"
+)
+
+// histogram records durations in log-linear buckets the way HDR histograms
+// do: values below subBuckets are exact, and every power of two above that
+// is split into subBuckets/2 linear buckets, keeping error under 1%.
+const (
+	subBucketBits = 7
+	subBuckets    = 1 << subBucketBits
+	halfBuckets   = subBuckets / 2
+	numBuckets    = subBuckets + (64-subBucketBits)*halfBuckets
+)
+
+type histogram struct {
+	counts [numBuckets]uint64
+	total  uint64
+	max    uint64
+}
+
+func bucketFor(v uint64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+
+	shift := bits.Len64(v) - subBucketBits
+	top := v >> shift
+
+	return subBuckets + (shift-1)*halfBuckets + int(top-halfBuckets)
+}
+
+// valueFor returns the highest value that lands in a bucket.
+func valueFor(bucket int) uint64 {
+	if bucket < subBuckets {
+		return uint64(bucket)
+	}
+
+	shift := (bucket-subBuckets)/halfBuckets + 1
+	top := uint64((bucket-subBuckets)%halfBuckets + halfBuckets)
+
+	return (top+1)<<shift - 1
+}
+
+func (h *histogram) record(d time.Duration) {
+	v := uint64(0)
+	if d > 0 {
+		v = uint64(d)
+	}
+
+	h.counts[bucketFor(v)]++
+	h.total++
+
+	if v > h.max {
+		h.max = v
+	}
+}
+
+func (h *histogram) merge(other *histogram) {
+	for i, count := range other.counts {
+		h.counts[i] += count
+	}
+
+	h.total += other.total
+
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+func (h *histogram) quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+
+	want := uint64(q * float64(h.total))
+	if want == 0 {
+		want = 1
+	}
+
+	seen := uint64(0)
+
+	for i, count := range h.counts {
+		seen += count
+		if seen >= want {
+			v := valueFor(i)
+			if v > h.max {
+				v = h.max
+			}
+			return time.Duration(v)
+		}
+	}
+
+	return time.Duration(h.max)
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/travisjeffery/go-dynaport"
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"github.com/w-h-a/grpc-server/pkg/agent"
+	"github.com/w-h-a/grpc-server/pkg/client"
+	"github.com/w-h-a/grpc-server/pkg/compression"
+	"golang.org/x/time/rate"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/status"
+)
+
+// timestampWidth is the width of the send time every benchmark value starts
+// with, which lets consumers measure end-to-end latency.
+const timestampWidth = 20
+
+type cfg struct {
+	addr       string
+	token      string
+	inProcess  bool
+	producers  int
+	consumers  int
+	recordSize int
+	batchSize  int
+	inFlight   int
+	rate       float64
+
+	compression     contracts.Compression
+	grpcCompression string
+	duration        time.Duration
+	json            bool
+}
+
+type result struct {
+	Operations uint64            `json:"operations"`
+	Records    uint64            `json:"records"`
+	Bytes      uint64            `json:"bytes"`
+	Errors     map[string]uint64 `json:"errors"`
+	Seconds    float64           `json:"seconds"`
+	RecordsPS  float64           `json:"records_per_second"`
+	BytesPS    float64           `json:"bytes_per_second"`
+	P50Micros  float64           `json:"p50_us"`
+	P99Micros  float64           `json:"p99_us"`
+	P999Micros float64           `json:"p999_us"`
+	MaxMicros  float64           `json:"max_us"`
+
+	latency histogram
+}
+
+type report struct {
+	Config  map[string]string `json:"config"`
+	Produce *result           `json:"produce,omitempty"`
+	Consume *result           `json:"consume,omitempty"`
+}
+
+func main() {
+	c := cfg{}
+
+	flag.StringVar(&c.addr, "addr", "127.0.0.1:8400", "service address")
+	flag.StringVar(&c.token, "token", "", "bearer token to authenticate with")
+	flag.BoolVar(&c.inProcess, "in-process", false, "start an agent in this process instead of using -addr")
+	flag.IntVar(&c.producers, "producers", 1, "number of concurrent producers")
+	flag.IntVar(&c.consumers, "consumers", 1, "number of concurrent stream consumers")
+	flag.IntVar(&c.recordSize, "record-size", 100, "size of each record value in bytes")
+	flag.IntVar(&c.batchSize, "batch-size", 1, "records per produce request; batches use ProduceBatch")
+	flag.IntVar(&c.inFlight, "in-flight", 0, "produce over ProduceStream with up to this many unacknowledged records; disabled when 0")
+	compressionName := flag.String("compression", "", "codec the server stores records with (none, gzip, snappy, zstd or lz4), the server's default when empty")
+	flag.StringVar(&c.grpcCompression, "grpc-compression", "", "codec to compress gRPC messages with, such as gzip")
+	flag.Float64Var(&c.rate, "rate", 0, "target records per second across all producers, unlimited when 0")
+	flag.DurationVar(&c.duration, "duration", 10*time.Second, "how long to produce for")
+	flag.BoolVar(&c.json, "json", false, "print the report as JSON")
+	flag.Parse()
+
+	if len(*compressionName) > 0 {
+		var err error
+		c.compression, err = compression.Parse(*compressionName)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if c.recordSize < timestampWidth {
+		log.Fatalf("record-size must be at least %d", timestampWidth)
+	}
+
+	if c.inProcess {
+		a, err := startAgent()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer a.Shutdown()
+
+		c.addr, err = a.Config.RPCAddr()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	endpoints, err := client.NewClient(client.Config{
+		Addr:            c.addr,
+		Token:           c.token,
+		Compression:     c.compression,
+		GRPCCompression: c.grpcCompression,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer endpoints.Close()
+
+	r, err := run(c, endpoints)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = r.print(os.Stdout, c.json)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func startAgent() (*agent.Agent, error) {
+	ports := dynaport.Get(1)
+
+	return agent.NewAgent(agent.Config{
+		RPCHost: "127.0.0.1",
+		RPCPort: ports[0],
+		Logging: agent.LoggingConfig{Level: "warn"},
+	})
+}
+
+func run(c cfg, endpoints *client.Client) (*report, error) {
+	ctx := context.Background()
+
+	offsets, err := endpoints.Offsets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	limiter := rate.NewLimiter(rate.Inf, 0)
+	if c.rate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(c.rate), c.batchSize)
+	}
+
+	produceCtx, stopProducing := context.WithTimeout(ctx, c.duration)
+	defer stopProducing()
+
+	consumeCtx, stopConsuming := context.WithCancel(ctx)
+	defer stopConsuming()
+
+	producers := make([]*result, c.producers)
+	consumers := make([]*result, c.consumers)
+
+	var producing sync.WaitGroup
+	var consuming sync.WaitGroup
+
+	start := time.Now()
+
+	for i := range consumers {
+		consumers[i] = newResult()
+		consuming.Add(1)
+		go func(r *result) {
+			defer consuming.Done()
+			consume(consumeCtx, endpoints, offsets.NextIndex, r)
+		}(consumers[i])
+	}
+
+	for i := range producers {
+		producers[i] = newResult()
+		producing.Add(1)
+		go func(r *result) {
+			defer producing.Done()
+			if c.inFlight > 0 {
+				produceStream(ctx, produceCtx, c, endpoints, limiter, r)
+			} else {
+				produce(produceCtx, c, endpoints, limiter, r)
+			}
+		}(producers[i])
+	}
+
+	producing.Wait()
+	produceElapsed := time.Since(start)
+
+	// give consumers a moment to catch up with what was produced
+	produced := merge(producers, produceElapsed)
+	want := produced.Records
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.consumers > 0 && time.Now().Before(deadline) && minRecords(consumers) < want {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stopConsuming()
+	consuming.Wait()
+
+	r := &report{Config: c.describe(), Produce: produced}
+	if c.consumers > 0 {
+		r.Consume = merge(consumers, time.Since(start))
+	}
+
+	return r, nil
+}
+
+func produce(ctx context.Context, c cfg, endpoints *client.Client, limiter *rate.Limiter, r *result) {
+	for {
+		err := limiter.WaitN(ctx, c.batchSize)
+		if err != nil {
+			return
+		}
+
+		begin := time.Now()
+
+		if c.batchSize == 1 {
+			_, err = endpoints.Produce(ctx, newValue(c.recordSize))
+		} else {
+			values := make([]string, c.batchSize)
+			for i := range values {
+				values[i] = newValue(c.recordSize)
+			}
+			_, err = endpoints.ProduceBatch(ctx, values...)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			r.Errors[status.Code(err).String()]++
+			continue
+		}
+
+		r.latency.record(time.Since(begin))
+		r.Operations++
+		r.Records += uint64(c.batchSize)
+		r.Bytes += uint64(c.batchSize * c.recordSize)
+	}
+}
+
+// produceStream sends records over one ProduceStream without waiting for
+// acknowledgements, keeping up to c.inFlight of them outstanding, until
+// produceCtx is done. The stream itself is opened with ctx, so outstanding
+// acknowledgements are still counted after that. The client has no
+// streaming producer, so this uses the stream on its connection.
+func produceStream(ctx, produceCtx context.Context, c cfg, endpoints *client.Client, limiter *rate.Limiter, r *result) {
+	stream, err := contracts.NewEndpointsClient(endpoints.Conn()).ProduceStream(ctx)
+	if err != nil {
+		r.Errors[status.Code(err).String()]++
+		return
+	}
+
+	sent := make(chan time.Time, c.inFlight)
+
+	go func() {
+		defer close(sent)
+		defer stream.CloseSend()
+
+		for id := uint64(1); ; id++ {
+			err := limiter.Wait(produceCtx)
+			if err != nil {
+				return
+			}
+
+			select {
+			case sent <- time.Now():
+			case <-produceCtx.Done():
+				return
+			}
+
+			err = stream.Send(&contracts.ProduceRequest{Record: &contracts.Record{Value: newValue(c.recordSize)}, RequestId: id, Compression: c.compression})
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for begin := range sent {
+		res, err := stream.Recv()
+		if err != nil {
+			r.Errors[status.Code(err).String()]++
+			return
+		}
+
+		if res.Error != nil {
+			r.Errors[status.Code(res.Error.Err()).String()]++
+			continue
+		}
+
+		r.latency.record(time.Since(begin))
+		r.Operations++
+		r.Records++
+		r.Bytes += uint64(c.recordSize)
+	}
+}
+
+func consume(ctx context.Context, endpoints *client.Client, from uint64, r *result) {
+	consumer := endpoints.NewConsumer(client.ConsumerConfig{From: from})
+
+	err := consumer.Consume(ctx, func(record *contracts.Record) error {
+		if sent, ok := sentAt(record.Value); ok {
+			r.latency.record(time.Since(sent))
+		}
+
+		r.Operations++
+		atomic.AddUint64(&r.Records, 1)
+		r.Bytes += uint64(len(record.Value))
+
+		return nil
+	})
+	if err != nil && ctx.Err() == nil {
+		r.Errors[status.Code(err).String()]++
+	}
+}
+
+func newValue(size int) string {
+	value := fmt.Sprintf("%0*d", timestampWidth, time.Now().UnixNano())
+	return value + strings.Repeat("x", size-timestampWidth)
+}
+
+func sentAt(value string) (time.Time, bool) {
+	if len(value) < timestampWidth {
+		return time.Time{}, false
+	}
+
+	nanos, err := strconv.ParseInt(value[:timestampWidth], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, nanos), true
+}
+
+func newResult() *result {
+	return &result{Errors: map[string]uint64{}}
+}
+
+func minRecords(results []*result) uint64 {
+	var min uint64
+	for i, r := range results {
+		if records := atomic.LoadUint64(&r.Records); i == 0 || records < min {
+			min = records
+		}
+	}
+	return min
+}
+
+func merge(results []*result, elapsed time.Duration) *result {
+	merged := newResult()
+
+	for _, r := range results {
+		merged.Operations += r.Operations
+		merged.Records += r.Records
+		merged.Bytes += r.Bytes
+		merged.latency.merge(&r.latency)
+
+		for code, count := range r.Errors {
+			merged.Errors[code] += count
+		}
+	}
+
+	merged.Seconds = elapsed.Seconds()
+	merged.RecordsPS = float64(merged.Records) / merged.Seconds
+	merged.BytesPS = float64(merged.Bytes) / merged.Seconds
+	merged.P50Micros = micros(merged.latency.quantile(0.5))
+	merged.P99Micros = micros(merged.latency.quantile(0.99))
+	merged.P999Micros = micros(merged.latency.quantile(0.999))
+	merged.MaxMicros = micros(time.Duration(merged.latency.max))
+
+	return merged
+}
+
+func micros(d time.Duration) float64 {
+	return float64(d) / float64(time.Microsecond)
+}
+
+func (c cfg) describe() map[string]string {
+	return map[string]string{
+		"addr":             c.addr,
+		"in_process":       strconv.FormatBool(c.inProcess),
+		"producers":        strconv.Itoa(c.producers),
+		"consumers":        strconv.Itoa(c.consumers),
+		"record_size":      strconv.Itoa(c.recordSize),
+		"batch_size":       strconv.Itoa(c.batchSize),
+		"in_flight":        strconv.Itoa(c.inFlight),
+		"compression":      c.compression.String(),
+		"grpc_compression": c.grpcCompression,
+		"rate":             strconv.FormatFloat(c.rate, 'f', -1, 64),
+		"duration":         c.duration.String(),
+	}
+}
+
+func (r *report) print(w io.Writer, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	}
+
+	for _, section := range []struct {
+		name   string
+		result *result
+	}{{"produce", r.Produce}, {"consume", r.Consume}} {
+		if section.result == nil {
+			continue
+		}
+
+		res := section.result
+
+		_, err := fmt.Fprintf(w,
+			"%s: %d records in %.1fs (%.0f records/s, %.2f MB/s), %d errors %v\n"+
+				"  latency p50=%.0fus p99=%.0fus p999=%.0fus max=%.0fus\n",
+			section.name, res.Records, res.Seconds, res.RecordsPS, res.BytesPS/1e6, errorCount(res), res.Errors,
+			res.P50Micros, res.P99Micros, res.P999Micros, res.MaxMicros,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func errorCount(r *result) uint64 {
+	var count uint64
+	for _, c := range r.Errors {
+		count += c
+	}
+	return count
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var errNotServing = errors.New("server is not serving")
+
+func (c *cli) adminCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "admin",
+		Short: "Administer a server through its HTTP port.",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "log-level [component] [level]",
+		Short: "Get or set the logging level of a component (agent, log or server), or of everything else.",
+		Args:  cobra.MaximumNArgs(2),
+		RunE:  c.logLevel,
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "rotate-keys",
+		Short: "Reload the server's key file, and wrap stored data keys with its last key.",
+		Args:  cobra.NoArgs,
+		RunE:  c.rotateKeys,
+	})
+
+	return cmd
+}
+
+func (c *cli) logLevel(cmd *cobra.Command, args []string) error {
+	url := "/admin/logging"
+	if len(args) > 0 {
+		url += "/" + args[0]
+	}
+
+	if len(args) == 2 {
+		return c.admin(cmd, http.MethodPut, url, strings.NewReader(fmt.Sprintf(`{"level":%q}`, args[1])))
+	}
+
+	return c.admin(cmd, http.MethodGet, url, nil)
+}
+
+func (c *cli) rotateKeys(cmd *cobra.Command, args []string) error {
+	return c.admin(cmd, http.MethodPost, "/admin/keys/rotate", nil)
+}
+
+// admin sends a request to an admin endpoint, and prints the response.
+func (c *cli) admin(cmd *cobra.Command, method, path string, body io.Reader) error {
+	req, err := http.NewRequestWithContext(cmd.Context(), method, c.httpURL(path), body)
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if len(c.cfg.context.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.context.Token)
+	}
+
+	httpClient := &http.Client{Timeout: c.cfg.context.Timeout}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(resBody)))
+	}
+
+	_, err = fmt.Fprintln(cmd.OutOrStdout(), strings.TrimSpace(string(resBody)))
+	return err
+}
+
+func (c *cli) httpURL(path string) string {
+	scheme := "http"
+	if c.cfg.context.TLS {
+		scheme = "https"
+	}
+
+	return fmt.Sprintf("%s://%s%s", scheme, c.cfg.context.HTTPAddr, path)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultTimeout = 10 * time.Second
+
+type config struct {
+	CurrentContext string                    `yaml:"current-context"`
+	Contexts       map[string]clusterContext `yaml:"contexts"`
+}
+
+type clusterContext struct {
+	Addr          string        `yaml:"addr,omitempty"`
+	HTTPAddr      string        `yaml:"http-addr,omitempty"`
+	Token         string        `yaml:"token,omitempty"`
+	TLS           bool          `yaml:"tls,omitempty"`
+	TLSCAFile     string        `yaml:"tls-ca-file,omitempty"`
+	TLSCertFile   string        `yaml:"tls-cert-file,omitempty"`
+	TLSKeyFile    string        `yaml:"tls-key-file,omitempty"`
+	TLSServerName string        `yaml:"tls-server-name,omitempty"`
+	Timeout       time.Duration `yaml:"timeout,omitempty"`
+	// Retries is a pointer so a context can turn retries off with 0.
+	Retries *int `yaml:"retries,omitempty"`
+
+	Compression     string `yaml:"compression,omitempty"`
+	GRPCCompression string `yaml:"grpc-compression,omitempty"`
+}
+
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".logctl.yaml"
+	}
+
+	return filepath.Join(dir, "logctl", "config.yaml")
+}
+
+func loadConfig(path string) (*config, error) {
+	config := &config{Contexts: map[string]clusterContext{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = yaml.Unmarshal(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if config.Contexts == nil {
+		config.Contexts = map[string]clusterContext{}
+	}
+
+	return config, nil
+}
+
+func (c *config) save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// override fills in every setting the context leaves empty from the flag
+// defaults, and replaces settings with flags that were explicitly set.
+func (c *clusterContext) override(flags *pflag.FlagSet) error {
+	var err error
+
+	strings := map[string]*string{
+		"addr":             &c.Addr,
+		"http-addr":        &c.HTTPAddr,
+		"token":            &c.Token,
+		"tls-ca-file":      &c.TLSCAFile,
+		"tls-cert-file":    &c.TLSCertFile,
+		"tls-key-file":     &c.TLSKeyFile,
+		"tls-server-name":  &c.TLSServerName,
+		"compression":      &c.Compression,
+		"grpc-compression": &c.GRPCCompression,
+	}
+
+	for name, value := range strings {
+		if flags.Changed(name) || len(*value) == 0 {
+			*value, err = flags.GetString(name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if flags.Changed("tls") {
+		c.TLS, err = flags.GetBool("tls")
+		if err != nil {
+			return err
+		}
+	}
+
+	if flags.Changed("timeout") || c.Timeout == 0 {
+		c.Timeout, err = flags.GetDuration("timeout")
+		if err != nil {
+			return err
+		}
+	}
+
+	if flags.Changed("retries") || c.Retries == nil {
+		retries, err := flags.GetInt("retries")
+		if err != nil {
+			return err
+		}
+		c.Retries = &retries
+	}
+
+	return nil
+}
+
+func errUnknownContext(name string) error {
+	return fmt.Errorf("unknown context: %s", name)
+}
+
+func errUnknownOutput(output string) error {
+	return fmt.Errorf("unknown output format: %s", output)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/w-h-a/grpc-server/pkg/client"
+	"github.com/w-h-a/grpc-server/pkg/compression"
+	"google.golang.org/grpc"
+)
+
+func (c *cli) clientConfig() (client.Config, error) {
+	context := c.cfg.context
+
+	config := client.Config{
+		Addr:            context.Addr,
+		Token:           context.Token,
+		Retries:         *context.Retries,
+		Timeout:         context.Timeout,
+		GRPCCompression: context.GRPCCompression,
+	}
+
+	if len(context.Compression) > 0 {
+		codec, err := compression.Parse(context.Compression)
+		if err != nil {
+			return client.Config{}, err
+		}
+		config.Compression = codec
+	}
+
+	if context.TLS || len(context.TLSCAFile) > 0 || len(context.TLSCertFile) > 0 {
+		tlsConfig, err := context.tlsConfig()
+		if err != nil {
+			return client.Config{}, err
+		}
+		config.TLS = tlsConfig
+	}
+
+	return config, nil
+}
+
+func (c *cli) dial() (*grpc.ClientConn, error) {
+	config, err := c.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Dial(config)
+}
+
+func (c *cli) client() (*client.Client, error) {
+	config, err := c.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return client.NewClient(config)
+}
+
+func (c *cli) requestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), c.cfg.context.Timeout)
+}
+
+func (c clusterContext) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: c.TLSServerName}
+
+	if len(c.TLSCAFile) > 0 {
+		ca, err := os.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", c.TLSCAFile)
+		}
+	}
+
+	if len(c.TLSCertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"github.com/w-h-a/grpc-server/pkg/client"
+)
+
+func (c *cli) consumeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "consume <index>",
+		Short: "Read records from the log, starting at an index.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  c.consume,
+	}
+
+	cmd.Flags().IntP("count", "n", 1, "Number of consecutive records to read.")
+
+	return cmd
+}
+
+func (c *cli) consume(cmd *cobra.Command, args []string) error {
+	index, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("index must be a non-negative integer: %q", args[0])
+	}
+
+	count, _ := cmd.Flags().GetInt("count")
+
+	endpoints, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer endpoints.Close()
+
+	for i := 0; i < count; i++ {
+		record, err := endpoints.Consume(cmd.Context(), index+uint64(i))
+		if err != nil {
+			return err
+		}
+
+		err = c.printRecord(cmd, record)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *cli) tailCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tail",
+		Short: "Follow the log, printing records as they are appended.",
+		Args:  cobra.NoArgs,
+		RunE:  c.tail,
+	}
+
+	cmd.Flags().String("from", "latest", "Index to start from, or latest.")
+
+	cmd.Flags().Bool("read-committed", false, "Only print records of committed transactions.")
+
+	cmd.Flags().String("prefix", "", "Only print records whose value starts with this prefix.")
+
+	cmd.Flags().String("regex", "", "Only print records whose value matches this regular expression.")
+
+	cmd.Flags().String("cel", "", "Only print records matching this CEL expression over value and index.")
+
+	cmd.Flags().String("consumer", "", "Consumer name whose dead-lettered records are skipped.")
+
+	return cmd
+}
+
+func (c *cli) tail(cmd *cobra.Command, args []string) error {
+	from, _ := cmd.Flags().GetString("from")
+	readCommitted, _ := cmd.Flags().GetBool("read-committed")
+	consumerName, _ := cmd.Flags().GetString("consumer")
+
+	endpoints, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer endpoints.Close()
+
+	var index uint64
+
+	if from == "latest" {
+		offsets, err := endpoints.Offsets(cmd.Context())
+		if err != nil {
+			return err
+		}
+		index = offsets.NextIndex
+	} else {
+		index, err = strconv.ParseUint(from, 10, 64)
+		if err != nil {
+			return fmt.Errorf("from must be a non-negative integer or latest: %q", from)
+		}
+	}
+
+	filter, err := tailFilter(cmd)
+	if err != nil {
+		return err
+	}
+
+	// the consumer reconnects and resumes where it left off until interrupted
+	consumer := endpoints.NewConsumer(client.ConsumerConfig{
+		Name:          consumerName,
+		From:          index,
+		ReadCommitted: readCommitted,
+		Filter:        filter,
+	})
+
+	return consumer.Consume(cmd.Context(), func(record *contracts.Record) error {
+		return c.printRecord(cmd, record)
+	})
+}
+
+func tailFilter(cmd *cobra.Command) (*contracts.Filter, error) {
+	var filters []*contracts.Filter
+
+	if cmd.Flags().Changed("prefix") {
+		prefix, _ := cmd.Flags().GetString("prefix")
+		filters = append(filters, &contracts.Filter{Expression: &contracts.Filter_ValuePrefix{ValuePrefix: prefix}})
+	}
+
+	if regex, _ := cmd.Flags().GetString("regex"); len(regex) > 0 {
+		filters = append(filters, &contracts.Filter{Expression: &contracts.Filter_ValueRegex{ValueRegex: regex}})
+	}
+
+	if expression, _ := cmd.Flags().GetString("cel"); len(expression) > 0 {
+		filters = append(filters, &contracts.Filter{Expression: &contracts.Filter_Cel{Cel: expression}})
+	}
+
+	switch len(filters) {
+	case 0:
+		return nil, nil
+	case 1:
+		return filters[0], nil
+	default:
+		return nil, fmt.Errorf("only one of --prefix, --regex and --cel can be used")
+	}
+}
+
+func (c *cli) printRecord(cmd *cobra.Command, record *contracts.Record) error {
+	res := &contracts.ConsumeResponse{Record: record}
+
+	return c.print(cmd.OutOrStdout(), res, fmt.Sprintf("%d\t%s", record.Index, record.Value), record.Value)
+}
+
+func (c *cli) offsetsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "offsets",
+		Short: "Print the first and next index of the log.",
+		Args:  cobra.NoArgs,
+		RunE:  c.offsets,
+	}
+}
+
+func (c *cli) offsets(cmd *cobra.Command, args []string) error {
+	endpoints, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer endpoints.Close()
+
+	res, err := endpoints.Offsets(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	return c.print(
+		cmd.OutOrStdout(),
+		res,
+		fmt.Sprintf("first index: %d\nnext index: %d", res.FirstIndex, res.NextIndex),
+		fmt.Sprintf("%d %d", res.FirstIndex, res.NextIndex),
+	)
+}
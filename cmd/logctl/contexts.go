@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+func (c *cli) configCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage cluster contexts in the logctl config file.",
+	}
+
+	setContext := &cobra.Command{
+		Use:   "set-context <name>",
+		Short: "Save the connection flags given on the command line as a context.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  c.setContext,
+	}
+
+	cmd.AddCommand(
+		setContext,
+		&cobra.Command{
+			Use:   "use-context <name>",
+			Short: "Make a context the default.",
+			Args:  cobra.ExactArgs(1),
+			RunE:  c.useContext,
+		},
+		&cobra.Command{
+			Use:   "get-contexts",
+			Short: "List contexts, marking the current one.",
+			Args:  cobra.NoArgs,
+			RunE:  c.getContexts,
+		},
+		&cobra.Command{
+			Use:   "delete-context <name>",
+			Short: "Remove a context.",
+			Args:  cobra.ExactArgs(1),
+			RunE:  c.deleteContext,
+		},
+	)
+
+	return cmd
+}
+
+func (c *cli) setContext(cmd *cobra.Command, args []string) error {
+	config, err := loadConfig(c.cfg.configFile)
+	if err != nil {
+		return err
+	}
+
+	context := config.Contexts[args[0]]
+
+	err = context.override(cmd.Flags())
+	if err != nil {
+		return err
+	}
+
+	config.Contexts[args[0]] = context
+
+	if len(config.CurrentContext) == 0 {
+		config.CurrentContext = args[0]
+	}
+
+	return config.save(c.cfg.configFile)
+}
+
+func (c *cli) useContext(cmd *cobra.Command, args []string) error {
+	config, err := loadConfig(c.cfg.configFile)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := config.Contexts[args[0]]; !ok {
+		return errUnknownContext(args[0])
+	}
+
+	config.CurrentContext = args[0]
+
+	return config.save(c.cfg.configFile)
+}
+
+func (c *cli) getContexts(cmd *cobra.Command, args []string) error {
+	config, err := loadConfig(c.cfg.configFile)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(config.Contexts))
+	for name := range config.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		marker := " "
+		if name == config.CurrentContext {
+			marker = "*"
+		}
+
+		_, err = fmt.Fprintf(cmd.OutOrStdout(), "%s %s\t%s\n", marker, name, config.Contexts[name].Addr)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *cli) deleteContext(cmd *cobra.Command, args []string) error {
+	config, err := loadConfig(c.cfg.configFile)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := config.Contexts[args[0]]; !ok {
+		return errUnknownContext(args[0])
+	}
+
+	delete(config.Contexts, args[0])
+
+	if config.CurrentContext == args[0] {
+		config.CurrentContext = ""
+	}
+
+	return config.save(c.cfg.configFile)
+}
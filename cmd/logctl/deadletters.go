@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+)
+
+func (c *cli) deadLettersCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dead-letters",
+		Short: "Inspect and re-drive records consumers gave up on.",
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List dead letters, oldest first.",
+		Args:  cobra.NoArgs,
+		RunE:  c.listDeadLetters,
+	}
+	list.Flags().String("consumer", "", "Only list this consumer's dead letters.")
+	list.Flags().Uint64("after", 0, "Only list dead letters after this ID.")
+	list.Flags().Uint32("limit", 100, "Maximum number of dead letters to list.")
+
+	report := &cobra.Command{
+		Use:   "report [consumer] [index] [reason]",
+		Short: "Report that consumer failed on the record at index, dead-lettering it after enough failures.",
+		Args:  cobra.RangeArgs(2, 3),
+		RunE:  c.reportFailure,
+	}
+
+	cmd.AddCommand(list, report, &cobra.Command{
+		Use:   "get [id]",
+		Short: "Print a dead letter and its record.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  c.getDeadLetter,
+	}, &cobra.Command{
+		Use:   "redrive [id...]",
+		Short: "Append dead-lettered records to the log again.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  c.redriveDeadLetters,
+	})
+
+	return cmd
+}
+
+func (c *cli) listDeadLetters(cmd *cobra.Command, args []string) error {
+	consumer, _ := cmd.Flags().GetString("consumer")
+	after, _ := cmd.Flags().GetUint64("after")
+	limit, _ := cmd.Flags().GetUint32("limit")
+
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	res, err := contracts.NewEndpointsClient(conn).ListDeadLetters(ctx, &contracts.ListDeadLettersRequest{Consumer: consumer, AfterId: after, Limit: limit})
+	if err != nil {
+		return err
+	}
+
+	text := make([]string, 0, len(res.DeadLetters))
+	raw := make([]string, 0, len(res.DeadLetters))
+
+	for _, deadLetter := range res.DeadLetters {
+		text = append(text, deadLetterSummary(deadLetter))
+		raw = append(raw, fmt.Sprint(deadLetter.Id))
+	}
+
+	return c.print(cmd.OutOrStdout(), res, strings.Join(text, "\n"), strings.Join(raw, "\n"))
+}
+
+func (c *cli) getDeadLetter(cmd *cobra.Command, args []string) error {
+	id, err := parseDeadLetterID(args[0])
+	if err != nil {
+		return err
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	res, err := contracts.NewEndpointsClient(conn).GetDeadLetter(ctx, &contracts.GetDeadLetterRequest{Id: id})
+	if err != nil {
+		return err
+	}
+
+	deadLetter := res.DeadLetter
+
+	return c.print(
+		cmd.OutOrStdout(),
+		deadLetter,
+		fmt.Sprintf("%s\nreason: %s\n\n%s", deadLetterSummary(deadLetter), deadLetter.Reason, deadLetter.Record.GetValue()),
+		deadLetter.Record.GetValue(),
+	)
+}
+
+func (c *cli) redriveDeadLetters(cmd *cobra.Command, args []string) error {
+	ids := make([]uint64, len(args))
+
+	for i, arg := range args {
+		id, err := parseDeadLetterID(arg)
+		if err != nil {
+			return err
+		}
+		ids[i] = id
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	endpoints := contracts.NewEndpointsClient(conn)
+
+	for _, id := range ids {
+		ctx, cancel := c.requestContext()
+		res, err := endpoints.RedriveDeadLetter(ctx, &contracts.RedriveDeadLetterRequest{Id: id})
+		cancel()
+		if err != nil {
+			return err
+		}
+
+		index := res.DeadLetter.GetRedrivenIndex()
+
+		err = c.print(cmd.OutOrStdout(), res, fmt.Sprintf("%d: index %d", id, index), fmt.Sprint(index))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *cli) reportFailure(cmd *cobra.Command, args []string) error {
+	index, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("index must be a non-negative integer: %q", args[1])
+	}
+
+	req := &contracts.ReportFailureRequest{Consumer: args[0], Index: index}
+	if len(args) == 3 {
+		req.Reason = args[2]
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	res, err := contracts.NewEndpointsClient(conn).ReportFailure(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("failures: %d", res.Failures)
+	if res.DeadLetter != nil {
+		text = deadLetterSummary(res.DeadLetter)
+	}
+
+	return c.print(cmd.OutOrStdout(), res, text, fmt.Sprint(res.Failures))
+}
+
+func deadLetterSummary(deadLetter *contracts.DeadLetter) string {
+	summary := fmt.Sprintf("%d\tconsumer %s\tindex %d\tfailures %d\t%s", deadLetter.Id, deadLetter.Consumer, deadLetter.Index, deadLetter.Failures, deadLetter.DeadLetteredAt.AsTime().Format("2006-01-02T15:04:05Z"))
+
+	if deadLetter.RedrivenIndex != nil {
+		summary += fmt.Sprintf("\tredriven to %d", *deadLetter.RedrivenIndex)
+	}
+
+	return summary
+}
+
+func parseDeadLetterID(arg string) (uint64, error) {
+	id, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("id must be a non-negative integer: %q", arg)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+	healthsrv "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func (c *cli) healthCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "health",
+		Short: "Check whether the server is serving.",
+		Args:  cobra.NoArgs,
+		RunE:  c.health,
+	}
+
+	cmd.Flags().String("service", "record.v1.Endpoints", "Service to check. Empty checks the server as a whole.")
+
+	return cmd
+}
+
+func (c *cli) health(cmd *cobra.Command, args []string) error {
+	service, _ := cmd.Flags().GetString("service")
+
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	res, err := healthsrv.NewHealthClient(conn).Check(ctx, &healthsrv.HealthCheckRequest{Service: service})
+	if err != nil {
+		return err
+	}
+
+	err = c.print(cmd.OutOrStdout(), res, res.Status.String(), res.Status.String())
+	if err != nil {
+		return err
+	}
+
+	if res.Status != healthsrv.HealthCheckResponse_SERVING {
+		return errNotServing
+	}
+
+	return nil
+}
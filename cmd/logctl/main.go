@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+type cli struct {
+	cfg cfg
+}
+
+type cfg struct {
+	configFile  string
+	contextName string
+	context     clusterContext
+	output      string
+}
+
+func main() {
+	cli := &cli{}
+
+	cmd := &cobra.Command{
+		Use:               "logctl",
+		Short:             "Produce to, consume from, and administer grpc-server clusters.",
+		PersistentPreRunE: cli.setupConfig,
+		SilenceUsage:      true,
+		SilenceErrors:     true,
+	}
+
+	setupFlags(cmd)
+
+	cmd.AddCommand(
+		cli.produceCmd(),
+		cli.consumeCmd(),
+		cli.tailCmd(),
+		cli.offsetsCmd(),
+		cli.schemaCmd(),
+		cli.deadLettersCmd(),
+		cli.scheduleCmd(),
+		cli.healthCmd(),
+		cli.adminCmd(),
+		cli.configCmd(),
+	)
+
+	err := cmd.Execute()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func setupFlags(cmd *cobra.Command) {
+	flags := cmd.PersistentFlags()
+
+	flags.String("config", defaultConfigFile(), "Path to the logctl config file.")
+
+	flags.String("context", "", "Cluster context to use. Defaults to the config's current context.")
+
+	flags.String("addr", "127.0.0.1:8400", "Address of the server's RPC port.")
+
+	flags.String("http-addr", "127.0.0.1:8401", "Address of the server's HTTP port, used by admin commands.")
+
+	flags.String("token", "", "Bearer token to authenticate with.")
+
+	flags.Bool("tls", false, "Connect with TLS.")
+
+	flags.String("tls-ca-file", "", "CA certificate to verify the server with.")
+
+	flags.String("tls-cert-file", "", "Client certificate for mutual TLS.")
+
+	flags.String("tls-key-file", "", "Client key for mutual TLS.")
+
+	flags.String("tls-server-name", "", "Server name to verify the server's certificate against.")
+
+	flags.Duration("timeout", defaultTimeout, "Timeout for each request. Streams are not limited.")
+
+	flags.Int("retries", 3, "Times to retry requests that fail with UNAVAILABLE.")
+
+	flags.String("compression", "", "Codec the server stores produced records with: none, gzip, snappy, zstd or lz4. Defaults to the server's.")
+
+	flags.String("grpc-compression", "", "Codec to compress gRPC messages with, such as gzip. Disabled when empty.")
+
+	flags.StringP("output", "o", "text", "Output format: text, json or raw.")
+}
+
+// setupConfig resolves connection settings: flags given on the command line
+// win over the selected context, which wins over flag defaults.
+func (c *cli) setupConfig(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+
+	c.cfg.configFile, _ = flags.GetString("config")
+	c.cfg.contextName, _ = flags.GetString("context")
+	c.cfg.output, _ = flags.GetString("output")
+
+	switch c.cfg.output {
+	case "text", "json", "raw":
+	default:
+		return errUnknownOutput(c.cfg.output)
+	}
+
+	config, err := loadConfig(c.cfg.configFile)
+	if err != nil {
+		return err
+	}
+
+	if len(c.cfg.contextName) == 0 {
+		c.cfg.contextName = config.CurrentContext
+	}
+
+	if len(c.cfg.contextName) > 0 {
+		context, ok := config.Contexts[c.cfg.contextName]
+		if !ok && flags.Changed("context") {
+			return errUnknownContext(c.cfg.contextName)
+		}
+		c.cfg.context = context
+	}
+
+	return c.cfg.context.override(flags)
+}
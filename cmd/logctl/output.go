@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+var marshaler = protojson.MarshalOptions{EmitUnpopulated: true}
+
+// print writes m as a line of JSON for the json output format, and the given
+// text or raw line otherwise.
+func (c *cli) print(w io.Writer, m proto.Message, text string, raw string) error {
+	switch c.cfg.output {
+	case "json":
+		data, err := marshaler.Marshal(m)
+		if err != nil {
+			return err
+		}
+
+		var line bytes.Buffer
+
+		err = json.Compact(&line, data)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, "%s\n", line.Bytes())
+		return err
+	case "raw":
+		_, err := fmt.Fprintln(w, raw)
+		return err
+	default:
+		_, err := fmt.Fprintln(w, text)
+		return err
+	}
+}
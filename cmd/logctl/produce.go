@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/spf13/cobra"
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"github.com/w-h-a/grpc-server/pkg/client"
+)
+
+func (c *cli) produceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "produce [value...]",
+		Short: "Append values to the log, one record per argument or per line of input.",
+		Long: "Append values to the log. Values come from the arguments, or else line by line\n" +
+			"from --file, or else line by line from stdin.",
+		RunE: c.produce,
+	}
+
+	cmd.Flags().StringP("file", "f", "", "File to read values from, one per line. Use - for stdin.")
+	cmd.Flags().Uint32("schema-id", 0, "ID of the registered schema the values conform to.")
+
+	return cmd
+}
+
+func (c *cli) produce(cmd *cobra.Command, args []string) error {
+	file, _ := cmd.Flags().GetString("file")
+	schemaID, _ := cmd.Flags().GetUint32("schema-id")
+
+	values := make(chan string)
+	readErr := make(chan error, 1)
+
+	go func() {
+		defer close(values)
+		readErr <- readValues(args, file, cmd.InOrStdin(), values)
+	}()
+
+	endpoints, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer endpoints.Close()
+
+	retries := *c.cfg.context.Retries
+	if retries == 0 {
+		retries = -1
+	}
+
+	// idempotent, so retried batches are never appended twice
+	producer := endpoints.NewProducer(client.ProducerConfig{
+		Idempotent: true,
+		MaxRetries: retries,
+		SchemaID:   schemaID,
+	})
+
+	// callbacks run in produce order on the producer's goroutine
+	var mu sync.Mutex
+	var produceErr error
+
+	failed := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return produceErr
+	}
+
+	callback := func(index uint64, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if produceErr != nil {
+			return
+		}
+
+		if err == nil {
+			res := &contracts.ProduceResponse{Index: index}
+			err = c.print(cmd.OutOrStdout(), res, fmt.Sprintf("index: %d", index), fmt.Sprint(index))
+		}
+
+		produceErr = err
+	}
+
+	for value := range values {
+		if err := failed(); err != nil {
+			producer.Close()
+			return err
+		}
+
+		err = producer.Produce(cmd.Context(), value, callback)
+		if err != nil {
+			producer.Close()
+			return err
+		}
+	}
+
+	producer.Close()
+
+	if err := failed(); err != nil {
+		return err
+	}
+
+	return <-readErr
+}
+
+func readValues(args []string, file string, stdin io.Reader, values chan<- string) error {
+	if len(args) > 0 {
+		for _, arg := range args {
+			values <- arg
+		}
+		return nil
+	}
+
+	input := stdin
+
+	if len(file) > 0 && file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	for scanner.Scan() {
+		values <- scanner.Text()
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+)
+
+func (c *cli) scheduleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schedule",
+		Short: "Have the server append values later.",
+	}
+
+	add := &cobra.Command{
+		Use:   "add [value]",
+		Short: "Append value once --in has passed, or at --at, and print the schedule id.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  c.schedule,
+	}
+	add.Flags().Duration("in", 0, "How long from now to append the value.")
+	add.Flags().String("at", "", "RFC 3339 time to append the value at.")
+
+	cmd.AddCommand(add, &cobra.Command{
+		Use:   "cancel [id]",
+		Short: "Drop a scheduled value before it's appended.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  c.cancelSchedule,
+	})
+
+	return cmd
+}
+
+func (c *cli) schedule(cmd *cobra.Command, args []string) error {
+	in, _ := cmd.Flags().GetDuration("in")
+	at, _ := cmd.Flags().GetString("at")
+
+	var deliverAt time.Time
+
+	switch {
+	case len(at) > 0 && in > 0:
+		return fmt.Errorf("only one of --in and --at can be used")
+	case len(at) > 0:
+		var err error
+
+		deliverAt, err = time.Parse(time.RFC3339, at)
+		if err != nil {
+			return fmt.Errorf("at must be an RFC 3339 time: %q", at)
+		}
+	case in > 0:
+		deliverAt = time.Now().Add(in)
+	default:
+		return fmt.Errorf("one of --in and --at is required")
+	}
+
+	endpoints, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer endpoints.Close()
+
+	id, err := endpoints.Schedule(cmd.Context(), args[0], deliverAt)
+	if err != nil {
+		return err
+	}
+
+	res := &contracts.ProduceResponse{ScheduleId: id}
+
+	return c.print(cmd.OutOrStdout(), res, fmt.Sprintf("schedule id: %d", id), fmt.Sprint(id))
+}
+
+func (c *cli) cancelSchedule(cmd *cobra.Command, args []string) error {
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("id must be a non-negative integer: %q", args[0])
+	}
+
+	endpoints, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer endpoints.Close()
+
+	return endpoints.CancelSchedule(cmd.Context(), id)
+}
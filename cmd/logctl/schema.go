@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"github.com/w-h-a/grpc-server/pkg/schema"
+)
+
+func (c *cli) schemaCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schema",
+		Short: "Register and look up the schemas records are checked against.",
+	}
+
+	register := &cobra.Command{
+		Use:   "register [subject] [file]",
+		Short: "Register the definition in file, or - for stdin, as the next version of subject.",
+		Args:  cobra.ExactArgs(2),
+		RunE:  c.registerSchema,
+	}
+	register.Flags().String("type", "json", "Schema type: protobuf, json or avro.")
+	register.Flags().String("compatibility", "", "Compatibility to check this and later versions of subject at: none, backward, forward or full. Defaults to the subject's, or the server's.")
+
+	get := &cobra.Command{
+		Use:   "get [subject] [version]",
+		Short: "Print a version of subject, the latest by default, or the schema with --id.",
+		Args:  cobra.RangeArgs(0, 2),
+		RunE:  c.getSchema,
+	}
+	get.Flags().Uint32("id", 0, "ID of the schema to print.")
+
+	cmd.AddCommand(register, get, &cobra.Command{
+		Use:   "list [subject]",
+		Short: "List the versions of subject.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  c.listSchemas,
+	})
+
+	return cmd
+}
+
+func (c *cli) registerSchema(cmd *cobra.Command, args []string) error {
+	typeName, _ := cmd.Flags().GetString("type")
+	compatibilityName, _ := cmd.Flags().GetString("compatibility")
+
+	typ, ok := contracts.SchemaType_value["SCHEMA_TYPE_"+strings.ToUpper(typeName)]
+	if !ok || typ == int32(contracts.SchemaType_SCHEMA_TYPE_UNSPECIFIED) {
+		return fmt.Errorf("unknown schema type %q", typeName)
+	}
+
+	req := &contracts.RegisterSchemaRequest{Subject: args[0], Type: contracts.SchemaType(typ)}
+
+	if len(compatibilityName) > 0 {
+		var err error
+
+		req.Compatibility, err = schema.ParseCompatibility(compatibilityName)
+		if err != nil {
+			return err
+		}
+	}
+
+	var definition []byte
+	var err error
+
+	if args[1] == "-" {
+		definition, err = io.ReadAll(cmd.InOrStdin())
+	} else {
+		definition, err = os.ReadFile(args[1])
+	}
+	if err != nil {
+		return err
+	}
+
+	req.Definition = string(definition)
+
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	res, err := contracts.NewEndpointsClient(conn).RegisterSchema(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	return c.printSchema(cmd, res.Schema)
+}
+
+func (c *cli) getSchema(cmd *cobra.Command, args []string) error {
+	id, _ := cmd.Flags().GetUint32("id")
+
+	req := &contracts.GetSchemaRequest{Id: id}
+
+	switch {
+	case id == 0 && len(args) == 0:
+		return fmt.Errorf("a subject or --id is required")
+	case len(args) > 0:
+		req.Subject = args[0]
+	}
+
+	if len(args) == 2 {
+		version, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		req.Version = uint32(version)
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	res, err := contracts.NewEndpointsClient(conn).GetSchema(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	return c.printSchema(cmd, res.Schema)
+}
+
+func (c *cli) listSchemas(cmd *cobra.Command, args []string) error {
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	res, err := contracts.NewEndpointsClient(conn).ListSchemaVersions(ctx, &contracts.ListSchemaVersionsRequest{Subject: args[0]})
+	if err != nil {
+		return err
+	}
+
+	text := []string{fmt.Sprintf("compatibility: %s", strings.ToLower(strings.TrimPrefix(res.Compatibility.String(), "COMPATIBILITY_")))}
+	raw := make([]string, 0, len(res.Schemas))
+
+	for _, s := range res.Schemas {
+		text = append(text, fmt.Sprintf("version %d: id %d, %s", s.Version, s.Id, schemaTypeName(s.Type)))
+		raw = append(raw, fmt.Sprintf("%d %d", s.Version, s.Id))
+	}
+
+	return c.print(cmd.OutOrStdout(), res, strings.Join(text, "\n"), strings.Join(raw, "\n"))
+}
+
+func (c *cli) printSchema(cmd *cobra.Command, s *contracts.Schema) error {
+	return c.print(
+		cmd.OutOrStdout(),
+		s,
+		fmt.Sprintf("id: %d\nsubject: %s\nversion: %d\ntype: %s\n\n%s", s.Id, s.Subject, s.Version, schemaTypeName(s.Type), s.Definition),
+		s.Definition,
+	)
+}
+
+func schemaTypeName(typ contracts.SchemaType) string {
+	return strings.ToLower(strings.TrimPrefix(typ.String(), "SCHEMA_TYPE_"))
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -44,6 +45,62 @@ func setupFlags(cmd *cobra.Command) error {
 
 	cmd.Flags().Int("rpc-port", 8400, "Port for RPC client connections.")
 
+	cmd.Flags().Int("http-port", 0, "Port for HTTP admin connections. Disabled when 0.")
+
+	cmd.Flags().String("data-dir", os.TempDir(), "Directory for data stored by the server.")
+
+	cmd.Flags().Duration("health-check-interval", 10*time.Second, "How often health checks run. Disabled when negative.")
+
+	cmd.Flags().Uint64("min-free-disk-bytes", 0, "Report NOT_SERVING when the data directory has less free space. Disabled when 0.")
+
+	cmd.Flags().Duration("shutdown-timeout", 30*time.Second, "How long to wait for open RPCs to finish on shutdown.")
+
+	cmd.Flags().String("rate-limits-file", "", "Path to a file with per-identity rate limits.")
+
+	cmd.Flags().String("tokens-file", "", "Path to a file of \"<token> <principal>\" lines. Authentication is disabled when empty.")
+
+	cmd.Flags().Int("max-record-size", 1024*1024, "Maximum size of a record value in bytes. Unlimited when 0.")
+
+	cmd.Flags().Int("max-batch-size", 4*1024*1024, "Maximum size of a batch of record values in bytes. Unlimited when 0.")
+
+	cmd.Flags().Bool("allow-empty-values", false, "Accept records with empty values.")
+
+	cmd.Flags().Bool("require-schemas", false, "Reject records without a schema id.")
+
+	cmd.Flags().String("schema-compatibility", "backward", "Compatibility new schema versions are checked at, unless their subject has its own: none, backward, forward or full.")
+
+	cmd.Flags().Duration("transaction-timeout", time.Minute, "How long a transaction may go without calls before it is aborted.")
+
+	cmd.Flags().Int("max-consume-streams", 0, "Maximum number of open consume streams. Unlimited when 0.")
+
+	cmd.Flags().Int("max-failures", 3, "Failures a consumer may report for a record before it is dead-lettered for that consumer.")
+
+	cmd.Flags().Uint64("max-memory-bytes", 0, "Memory records may take before the oldest are spilled to the data directory. Unlimited when 0.")
+
+	cmd.Flags().Int("spill-cache-chunks", 8, "Number of spilled chunks of records kept in memory once read.")
+
+	cmd.Flags().Uint64("max-records", 0, "Number of the newest records kept; older ones are dropped a chunk at a time. Unlimited when 0.")
+
+	cmd.Flags().String("compression", "none", "Codec records are stored with when producers don't choose one: none, gzip, snappy, zstd or lz4.")
+
+	cmd.Flags().String("key-file", "", "Path to a file of \"<id> <base64 key>\" lines; the last key encrypts the data keys of stored records. Records are stored in plaintext when empty.")
+
+	cmd.Flags().String("log-level", "debug", "Default logging level.")
+
+	cmd.Flags().StringToString("log-component-levels", map[string]string{}, "Logging level per component (agent, log, server).")
+
+	cmd.Flags().String("log-format", "console", "Logging format (console or json).")
+
+	cmd.Flags().String("log-file", "", "File to write logs to. Logs go to stderr when empty.")
+
+	cmd.Flags().Int("log-max-size-mb", 100, "Size at which the log file is rotated.")
+
+	cmd.Flags().Int("log-max-backups", 3, "Number of rotated log files to keep.")
+
+	cmd.Flags().Int("log-max-age-days", 28, "Number of days to keep rotated log files.")
+
+	cmd.Flags().Bool("log-sampling", false, "Sample repeated log entries.")
+
 	return viper.BindPFlags(cmd.Flags())
 }
 
@@ -52,6 +109,57 @@ func (c *cli) setupConfig(cmd *cobra.Command, args []string) error {
 
 	c.cfg.agent.RPCPort = viper.GetInt("rpc-port")
 
+	c.cfg.agent.HTTPPort = viper.GetInt("http-port")
+
+	c.cfg.agent.DataDir = viper.GetString("data-dir")
+
+	c.cfg.agent.HealthCheckInterval = viper.GetDuration("health-check-interval")
+
+	c.cfg.agent.MinFreeDiskBytes = viper.GetUint64("min-free-disk-bytes")
+
+	c.cfg.agent.ShutdownTimeout = viper.GetDuration("shutdown-timeout")
+
+	c.cfg.agent.RateLimitsFile = viper.GetString("rate-limits-file")
+
+	c.cfg.agent.TokensFile = viper.GetString("tokens-file")
+
+	c.cfg.agent.MaxRecordSize = viper.GetInt("max-record-size")
+
+	c.cfg.agent.MaxBatchSize = viper.GetInt("max-batch-size")
+
+	c.cfg.agent.AllowEmptyValues = viper.GetBool("allow-empty-values")
+
+	c.cfg.agent.RequireSchemas = viper.GetBool("require-schemas")
+
+	c.cfg.agent.SchemaCompatibility = viper.GetString("schema-compatibility")
+
+	c.cfg.agent.TransactionTimeout = viper.GetDuration("transaction-timeout")
+
+	c.cfg.agent.MaxConsumeStreams = viper.GetInt("max-consume-streams")
+
+	c.cfg.agent.MaxFailures = viper.GetInt("max-failures")
+
+	c.cfg.agent.MaxMemoryBytes = viper.GetUint64("max-memory-bytes")
+
+	c.cfg.agent.SpillCacheChunks = viper.GetInt("spill-cache-chunks")
+
+	c.cfg.agent.MaxRecords = viper.GetUint64("max-records")
+
+	c.cfg.agent.Compression = viper.GetString("compression")
+
+	c.cfg.agent.KeyFile = viper.GetString("key-file")
+
+	c.cfg.agent.Logging = agent.LoggingConfig{
+		Level:           viper.GetString("log-level"),
+		ComponentLevels: viper.GetStringMapString("log-component-levels"),
+		Format:          viper.GetString("log-format"),
+		File:            viper.GetString("log-file"),
+		MaxSizeMB:       viper.GetInt("log-max-size-mb"),
+		MaxBackups:      viper.GetInt("log-max-backups"),
+		MaxAgeDays:      viper.GetInt("log-max-age-days"),
+		Sampling:        viper.GetBool("log-sampling"),
+	}
+
 	return nil
 }
 
@@ -0,0 +1,32 @@
+package record_v1
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// ErrAlreadyDelivered is returned for cancelling a scheduled record that
+// was already appended, at Index.
+type ErrAlreadyDelivered struct {
+	ScheduleID uint64
+	Index      uint64
+}
+
+func (e ErrAlreadyDelivered) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrAlreadyDelivered) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.FailedPrecondition,
+		ReasonAlreadyDelivered,
+		map[string]string{
+			"schedule_id": formatIndex(e.ScheduleID),
+			"index":       formatIndex(e.Index),
+		},
+		fmt.Sprintf("scheduled record is already delivered: %d at index %d", e.ScheduleID, e.Index),
+		fmt.Sprintf("Scheduled record %d was already appended at index %d", e.ScheduleID, e.Index),
+	)
+}
@@ -0,0 +1,26 @@
+package record_v1
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+type ErrDecryptionFailed struct {
+	Index uint64
+}
+
+func (e ErrDecryptionFailed) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrDecryptionFailed) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.DataLoss,
+		ReasonDecryptionFailed,
+		map[string]string{"index": formatIndex(e.Index)},
+		fmt.Sprintf("record could not be decrypted: %d", e.Index),
+		fmt.Sprintf("The record at index %d failed authentication, or its key could not be unwrapped", e.Index),
+	)
+}
@@ -0,0 +1,26 @@
+package record_v1
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+type ErrHiddenRecord struct {
+	Index uint64
+}
+
+func (e ErrHiddenRecord) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrHiddenRecord) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.NotFound,
+		ReasonHiddenRecord,
+		map[string]string{"index": formatIndex(e.Index)},
+		fmt.Sprintf("record is not visible to read_committed readers: %d", e.Index),
+		fmt.Sprintf("The record at index %d is a transaction marker or belongs to an aborted transaction", e.Index),
+	)
+}
@@ -0,0 +1,34 @@
+package record_v1
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+type ErrIncompatibleSchema struct {
+	Subject       string
+	Version       uint32
+	Compatibility Compatibility
+	Description   string
+}
+
+func (e ErrIncompatibleSchema) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrIncompatibleSchema) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.FailedPrecondition,
+		ReasonIncompatibleSchema,
+		map[string]string{
+			"subject":       e.Subject,
+			"version":       formatIndex(uint64(e.Version)),
+			"compatibility": e.Compatibility.String(),
+			"description":   e.Description,
+		},
+		fmt.Sprintf("schema is incompatible with %s version %d: %s", e.Subject, e.Version, e.Description),
+		fmt.Sprintf("The schema fails the %s check against version %d of %s: %s", e.Compatibility, e.Version, e.Subject, e.Description),
+	)
+}
@@ -0,0 +1,31 @@
+package record_v1
+
+import (
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+type ErrInvalidFilter struct {
+	Field       string
+	Description string
+}
+
+func (e ErrInvalidFilter) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrInvalidFilter) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.InvalidArgument,
+		ReasonInvalidFilter,
+		map[string]string{"field": e.Field},
+		fmt.Sprintf("invalid filter: %s", e.Description),
+		fmt.Sprintf("The filter was rejected: %s", e.Description),
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: e.Field, Description: e.Description},
+		}},
+	)
+}
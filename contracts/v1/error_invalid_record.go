@@ -0,0 +1,33 @@
+package record_v1
+
+import (
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+type ErrInvalidRecord struct {
+	Violations []*errdetails.BadRequest_FieldViolation
+}
+
+func (e ErrInvalidRecord) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrInvalidRecord) GRPCStatus() *status.Status {
+	description := "record is invalid"
+	if len(e.Violations) > 0 {
+		description = e.Violations[0].Description
+	}
+
+	return newStatus(
+		codes.InvalidArgument,
+		ReasonInvalidRecord,
+		nil,
+		fmt.Sprintf("invalid record: %s", description),
+		fmt.Sprintf("The record was rejected: %s", description),
+		&errdetails.BadRequest{FieldViolations: e.Violations},
+	)
+}
@@ -0,0 +1,31 @@
+package record_v1
+
+import (
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+type ErrInvalidReport struct {
+	Field       string
+	Description string
+}
+
+func (e ErrInvalidReport) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrInvalidReport) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.InvalidArgument,
+		ReasonInvalidReport,
+		map[string]string{"field": e.Field},
+		fmt.Sprintf("invalid failure report: %s", e.Description),
+		fmt.Sprintf("The failure report was rejected: %s", e.Description),
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: e.Field, Description: e.Description},
+		}},
+	)
+}
@@ -0,0 +1,26 @@
+package record_v1
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+type ErrNotCommitted struct {
+	Index uint64
+}
+
+func (e ErrNotCommitted) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrNotCommitted) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.FailedPrecondition,
+		ReasonNotCommitted,
+		map[string]string{"index": formatIndex(e.Index)},
+		fmt.Sprintf("record is not committed yet: %d", e.Index),
+		fmt.Sprintf("The record at index %d, or one before it, belongs to a transaction that is still open", e.Index),
+	)
+}
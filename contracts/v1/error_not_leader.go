@@ -0,0 +1,26 @@
+package record_v1
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+type ErrNotLeader struct {
+	Leader string
+}
+
+func (e ErrNotLeader) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrNotLeader) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.FailedPrecondition,
+		ReasonNotLeader,
+		map[string]string{"leader": e.Leader},
+		fmt.Sprintf("server is not the leader: %s", e.Leader),
+		fmt.Sprintf("This server cannot accept writes; send them to the leader at %q", e.Leader),
+	)
+}
@@ -0,0 +1,32 @@
+package record_v1
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+type ErrOutOfOrderSequence struct {
+	ProducerID       uint64
+	Sequence         uint64
+	ExpectedSequence uint64
+}
+
+func (e ErrOutOfOrderSequence) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrOutOfOrderSequence) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.FailedPrecondition,
+		ReasonOutOfOrderSequence,
+		map[string]string{
+			"producer_id":       formatIndex(e.ProducerID),
+			"sequence":          formatIndex(e.Sequence),
+			"expected_sequence": formatIndex(e.ExpectedSequence),
+		},
+		fmt.Sprintf("sequence is out of order: %d", e.Sequence),
+		fmt.Sprintf("Producer %d sent sequence %d, but the server expected sequence %d", e.ProducerID, e.Sequence, e.ExpectedSequence),
+	)
+}
@@ -3,7 +3,7 @@ package record_v1
 import (
 	"fmt"
 
-	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
 	status "google.golang.org/grpc/status"
 )
 
@@ -16,19 +16,11 @@ func (e ErrIndexOutOfRange) Error() string {
 }
 
 func (e ErrIndexOutOfRange) GRPCStatus() *status.Status {
-	status := status.New(404, fmt.Sprintf("index is out of range: %d", e.Index))
-	
-	msg := fmt.Sprintf("The requested index is outside of the log's range: %d", e.Index)
-
-	details := &errdetails.LocalizedMessage{
-		Locale: "en-US",
-		Message: msg,
-	}
-
-	detailedStatus, err := status.WithDetails(details)
-	if err != nil {
-		return status
-	}
-
-	return detailedStatus
-}
\ No newline at end of file
+	return newStatus(
+		codes.OutOfRange,
+		ReasonIndexOutOfRange,
+		map[string]string{"index": formatIndex(e.Index)},
+		fmt.Sprintf("index is out of range: %d", e.Index),
+		fmt.Sprintf("The requested index is outside of the log's range: %d", e.Index),
+	)
+}
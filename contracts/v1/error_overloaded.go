@@ -0,0 +1,30 @@
+package record_v1
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+type ErrOverloaded struct {
+	RetryAfter time.Duration
+}
+
+func (e ErrOverloaded) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrOverloaded) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.ResourceExhausted,
+		ReasonOverloaded,
+		nil,
+		fmt.Sprintf("server is overloaded, retry after %s", e.RetryAfter),
+		fmt.Sprintf("The server is overloaded; retry after %s", e.RetryAfter),
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(e.RetryAfter)},
+	)
+}
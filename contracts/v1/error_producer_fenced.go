@@ -0,0 +1,28 @@
+package record_v1
+
+import (
+	"fmt"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+type ErrProducerFenced struct {
+	ProducerID uint64
+	Epoch      uint32
+}
+
+func (e ErrProducerFenced) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrProducerFenced) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.FailedPrecondition,
+		ReasonProducerFenced,
+		map[string]string{"producer_id": formatIndex(e.ProducerID), "epoch": strconv.FormatUint(uint64(e.Epoch), 10)},
+		fmt.Sprintf("producer has been fenced: %d", e.ProducerID),
+		fmt.Sprintf("Producer %d is no longer on epoch %d; a newer instance of it has been initialized", e.ProducerID, e.Epoch),
+	)
+}
@@ -0,0 +1,26 @@
+package record_v1
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+type ErrShuttingDown struct {
+	NextIndex uint64
+}
+
+func (e ErrShuttingDown) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrShuttingDown) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.Unavailable,
+		ReasonShuttingDown,
+		map[string]string{"next_index": formatIndex(e.NextIndex)},
+		fmt.Sprintf("server is shutting down, resume from index %d", e.NextIndex),
+		fmt.Sprintf("The server is shutting down; reconnect and resume from index %d", e.NextIndex),
+	)
+}
@@ -0,0 +1,218 @@
+package record_v1
+
+import (
+	"strconv"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
+)
+
+const ErrorDomain = "record.v1"
+
+const (
+	ReasonIndexOutOfRange = "INDEX_OUT_OF_RANGE"
+	ReasonTruncated       = "TRUNCATED"
+	ReasonNotLeader       = "NOT_LEADER"
+	ReasonInvalidRecord   = "INVALID_RECORD"
+	ReasonUnauthorized    = "UNAUTHORIZED"
+	ReasonOverloaded      = "OVERLOADED"
+	ReasonShuttingDown    = "SHUTTING_DOWN"
+
+	ReasonProducerFenced     = "PRODUCER_FENCED"
+	ReasonUnknownProducer    = "UNKNOWN_PRODUCER"
+	ReasonOutOfOrderSequence = "OUT_OF_ORDER_SEQUENCE"
+
+	ReasonUnexpectedIndex = "UNEXPECTED_INDEX"
+	ReasonTxnNotOpen      = "TXN_NOT_OPEN"
+	ReasonNotCommitted    = "NOT_COMMITTED"
+	ReasonHiddenRecord    = "HIDDEN_RECORD"
+	ReasonInvalidFilter   = "INVALID_FILTER"
+
+	ReasonDecryptionFailed = "DECRYPTION_FAILED"
+
+	ReasonInvalidSchema      = "INVALID_SCHEMA"
+	ReasonIncompatibleSchema = "INCOMPATIBLE_SCHEMA"
+	ReasonUnknownSchema      = "UNKNOWN_SCHEMA"
+
+	ReasonInvalidReport     = "INVALID_REPORT"
+	ReasonUnknownDeadLetter = "UNKNOWN_DEAD_LETTER"
+
+	ReasonUnknownSchedule  = "UNKNOWN_SCHEDULE"
+	ReasonAlreadyDelivered = "ALREADY_DELIVERED"
+)
+
+func newStatus(code codes.Code, reason string, metadata map[string]string, msg string, localized string, details ...protoiface.MessageV1) *status.Status {
+	status := status.New(code, msg)
+
+	details = append([]protoiface.MessageV1{
+		&errdetails.ErrorInfo{
+			Reason:   reason,
+			Domain:   ErrorDomain,
+			Metadata: metadata,
+		},
+		&errdetails.LocalizedMessage{
+			Locale:  "en-US",
+			Message: localized,
+		},
+	}, details...)
+
+	detailedStatus, err := status.WithDetails(details...)
+	if err != nil {
+		return status
+	}
+
+	return detailedStatus
+}
+
+// FromError converts an error received from the Endpoints service back into
+// one of the typed errors of this package. Errors it does not recognize are
+// returned unchanged.
+func FromError(err error) error {
+	status, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	var info *errdetails.ErrorInfo
+	var retry *errdetails.RetryInfo
+	var badRequest *errdetails.BadRequest
+
+	for _, detail := range status.Details() {
+		switch detail := detail.(type) {
+		case *errdetails.ErrorInfo:
+			info = detail
+		case *errdetails.RetryInfo:
+			retry = detail
+		case *errdetails.BadRequest:
+			badRequest = detail
+		}
+	}
+
+	if info == nil || info.Domain != ErrorDomain {
+		return err
+	}
+
+	switch info.Reason {
+	case ReasonIndexOutOfRange:
+		return ErrIndexOutOfRange{Index: parseIndex(info.Metadata["index"])}
+	case ReasonTruncated:
+		return ErrTruncated{
+			Index:      parseIndex(info.Metadata["index"]),
+			FirstIndex: parseIndex(info.Metadata["first_index"]),
+		}
+	case ReasonNotLeader:
+		return ErrNotLeader{Leader: info.Metadata["leader"]}
+	case ReasonInvalidRecord:
+		e := ErrInvalidRecord{}
+		if badRequest != nil {
+			e.Violations = badRequest.FieldViolations
+		}
+		return e
+	case ReasonUnauthorized:
+		return ErrUnauthorized{Principal: info.Metadata["principal"], Action: info.Metadata["action"]}
+	case ReasonOverloaded:
+		e := ErrOverloaded{}
+		if retry != nil {
+			e.RetryAfter = retry.RetryDelay.AsDuration()
+		}
+		return e
+	case ReasonShuttingDown:
+		return ErrShuttingDown{NextIndex: parseIndex(info.Metadata["next_index"])}
+	case ReasonProducerFenced:
+		return ErrProducerFenced{
+			ProducerID: parseIndex(info.Metadata["producer_id"]),
+			Epoch:      uint32(parseIndex(info.Metadata["epoch"])),
+		}
+	case ReasonUnknownProducer:
+		return ErrUnknownProducer{ProducerID: parseIndex(info.Metadata["producer_id"])}
+	case ReasonOutOfOrderSequence:
+		return ErrOutOfOrderSequence{
+			ProducerID:       parseIndex(info.Metadata["producer_id"]),
+			Sequence:         parseIndex(info.Metadata["sequence"]),
+			ExpectedSequence: parseIndex(info.Metadata["expected_sequence"]),
+		}
+	case ReasonUnexpectedIndex:
+		return ErrUnexpectedIndex{
+			ExpectedIndex: parseIndex(info.Metadata["expected_index"]),
+			NextIndex:     parseIndex(info.Metadata["next_index"]),
+		}
+	case ReasonTxnNotOpen:
+		return ErrTxnNotOpen{TxnID: parseIndex(info.Metadata["txn_id"])}
+	case ReasonNotCommitted:
+		return ErrNotCommitted{Index: parseIndex(info.Metadata["index"])}
+	case ReasonHiddenRecord:
+		return ErrHiddenRecord{Index: parseIndex(info.Metadata["index"])}
+	case ReasonInvalidFilter:
+		e := ErrInvalidFilter{Field: info.Metadata["field"]}
+		if badRequest != nil && len(badRequest.FieldViolations) > 0 {
+			e.Description = badRequest.FieldViolations[0].Description
+		}
+		return e
+	case ReasonDecryptionFailed:
+		return ErrDecryptionFailed{Index: parseIndex(info.Metadata["index"])}
+	case ReasonInvalidSchema:
+		e := ErrInvalidSchema{Field: info.Metadata["field"]}
+		if badRequest != nil && len(badRequest.FieldViolations) > 0 {
+			e.Description = badRequest.FieldViolations[0].Description
+		}
+		return e
+	case ReasonIncompatibleSchema:
+		return ErrIncompatibleSchema{
+			Subject:       info.Metadata["subject"],
+			Version:       uint32(parseIndex(info.Metadata["version"])),
+			Compatibility: Compatibility(Compatibility_value[info.Metadata["compatibility"]]),
+			Description:   info.Metadata["description"],
+		}
+	case ReasonUnknownSchema:
+		return ErrUnknownSchema{
+			ID:      uint32(parseIndex(info.Metadata["id"])),
+			Subject: info.Metadata["subject"],
+			Version: uint32(parseIndex(info.Metadata["version"])),
+		}
+	case ReasonInvalidReport:
+		e := ErrInvalidReport{Field: info.Metadata["field"]}
+		if badRequest != nil && len(badRequest.FieldViolations) > 0 {
+			e.Description = badRequest.FieldViolations[0].Description
+		}
+		return e
+	case ReasonUnknownDeadLetter:
+		return ErrUnknownDeadLetter{ID: parseIndex(info.Metadata["id"])}
+	case ReasonUnknownSchedule:
+		return ErrUnknownSchedule{ScheduleID: parseIndex(info.Metadata["schedule_id"])}
+	case ReasonAlreadyDelivered:
+		return ErrAlreadyDelivered{
+			ScheduleID: parseIndex(info.Metadata["schedule_id"]),
+			Index:      parseIndex(info.Metadata["index"]),
+		}
+	default:
+		return err
+	}
+}
+
+// NewStatus converts err to a Status, to report it in a response.
+func NewStatus(err error) *Status {
+	s := status.Convert(err).Proto()
+	return &Status{Code: s.Code, Message: s.Message, Details: s.Details}
+}
+
+// Err converts s back into an error, typed as FromError does. A nil Status
+// is no error.
+func (s *Status) Err() error {
+	if s == nil {
+		return nil
+	}
+
+	return FromError(status.ErrorProto(&spb.Status{Code: s.Code, Message: s.Message, Details: s.Details}))
+}
+
+func formatIndex(index uint64) string {
+	return strconv.FormatUint(index, 10)
+}
+
+func parseIndex(index string) uint64 {
+	parsed, _ := strconv.ParseUint(index, 10, 64)
+	return parsed
+}
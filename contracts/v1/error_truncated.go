@@ -0,0 +1,27 @@
+package record_v1
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+type ErrTruncated struct {
+	Index      uint64
+	FirstIndex uint64
+}
+
+func (e ErrTruncated) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrTruncated) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.OutOfRange,
+		ReasonTruncated,
+		map[string]string{"index": formatIndex(e.Index), "first_index": formatIndex(e.FirstIndex)},
+		fmt.Sprintf("index has been truncated: %d", e.Index),
+		fmt.Sprintf("The requested index %d is no longer retained; the oldest record is at index %d", e.Index, e.FirstIndex),
+	)
+}
@@ -0,0 +1,26 @@
+package record_v1
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+type ErrTxnNotOpen struct {
+	TxnID uint64
+}
+
+func (e ErrTxnNotOpen) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrTxnNotOpen) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.FailedPrecondition,
+		ReasonTxnNotOpen,
+		map[string]string{"txn_id": formatIndex(e.TxnID)},
+		fmt.Sprintf("transaction is not open: %d", e.TxnID),
+		fmt.Sprintf("Transaction %d was committed, aborted or timed out, or was never begun", e.TxnID),
+	)
+}
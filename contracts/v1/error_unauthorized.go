@@ -0,0 +1,27 @@
+package record_v1
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+type ErrUnauthorized struct {
+	Principal string
+	Action    string
+}
+
+func (e ErrUnauthorized) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrUnauthorized) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.PermissionDenied,
+		ReasonUnauthorized,
+		map[string]string{"principal": e.Principal, "action": e.Action},
+		fmt.Sprintf("%s is not permitted to %s", e.Principal, e.Action),
+		fmt.Sprintf("The principal %q is not permitted to %s", e.Principal, e.Action),
+	)
+}
@@ -0,0 +1,27 @@
+package record_v1
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+type ErrUnexpectedIndex struct {
+	ExpectedIndex uint64
+	NextIndex     uint64
+}
+
+func (e ErrUnexpectedIndex) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrUnexpectedIndex) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.FailedPrecondition,
+		ReasonUnexpectedIndex,
+		map[string]string{"expected_index": formatIndex(e.ExpectedIndex), "next_index": formatIndex(e.NextIndex)},
+		fmt.Sprintf("next index is not the expected index: %d", e.ExpectedIndex),
+		fmt.Sprintf("The records were expected at index %d, but the log's next index is %d", e.ExpectedIndex, e.NextIndex),
+	)
+}
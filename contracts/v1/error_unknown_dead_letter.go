@@ -0,0 +1,26 @@
+package record_v1
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+type ErrUnknownDeadLetter struct {
+	ID uint64
+}
+
+func (e ErrUnknownDeadLetter) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrUnknownDeadLetter) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.NotFound,
+		ReasonUnknownDeadLetter,
+		map[string]string{"id": formatIndex(e.ID)},
+		fmt.Sprintf("dead letter is unknown: %d", e.ID),
+		fmt.Sprintf("Dead letter %d is not in the dead-letter log", e.ID),
+	)
+}
@@ -0,0 +1,26 @@
+package record_v1
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+type ErrUnknownProducer struct {
+	ProducerID uint64
+}
+
+func (e ErrUnknownProducer) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrUnknownProducer) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.FailedPrecondition,
+		ReasonUnknownProducer,
+		map[string]string{"producer_id": formatIndex(e.ProducerID)},
+		fmt.Sprintf("producer is unknown: %d", e.ProducerID),
+		fmt.Sprintf("Producer %d was not allocated by this server; call InitProducer again", e.ProducerID),
+	)
+}
@@ -0,0 +1,26 @@
+package record_v1
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+type ErrUnknownSchedule struct {
+	ScheduleID uint64
+}
+
+func (e ErrUnknownSchedule) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrUnknownSchedule) GRPCStatus() *status.Status {
+	return newStatus(
+		codes.NotFound,
+		ReasonUnknownSchedule,
+		map[string]string{"schedule_id": formatIndex(e.ScheduleID)},
+		fmt.Sprintf("schedule is unknown: %d", e.ScheduleID),
+		fmt.Sprintf("Scheduled record %d was never scheduled, or was delivered or cancelled before the server restarted", e.ScheduleID),
+	)
+}
@@ -0,0 +1,39 @@
+package record_v1
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// ErrUnknownSchema is returned for a schema looked up by ID, or else by
+// subject and version, that isn't registered.
+type ErrUnknownSchema struct {
+	ID      uint32
+	Subject string
+	Version uint32
+}
+
+func (e ErrUnknownSchema) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e ErrUnknownSchema) GRPCStatus() *status.Status {
+	name := fmt.Sprintf("%d", e.ID)
+	if e.ID == 0 {
+		name = fmt.Sprintf("%s version %d", e.Subject, e.Version)
+	}
+
+	return newStatus(
+		codes.NotFound,
+		ReasonUnknownSchema,
+		map[string]string{
+			"id":      formatIndex(uint64(e.ID)),
+			"subject": e.Subject,
+			"version": formatIndex(uint64(e.Version)),
+		},
+		fmt.Sprintf("schema is unknown: %s", name),
+		fmt.Sprintf("Schema %s is not registered", name),
+	)
+}
@@ -25,7 +25,23 @@ type EndpointsClient interface {
 	Produce(ctx context.Context, in *ProduceRequest, opts ...grpc.CallOption) (*ProduceResponse, error)
 	Consume(ctx context.Context, in *ConsumeRequest, opts ...grpc.CallOption) (*ConsumeResponse, error)
 	ConsumeStream(ctx context.Context, in *ConsumeRequest, opts ...grpc.CallOption) (Endpoints_ConsumeStreamClient, error)
+	Subscribe(ctx context.Context, opts ...grpc.CallOption) (Endpoints_SubscribeClient, error)
 	ProduceStream(ctx context.Context, opts ...grpc.CallOption) (Endpoints_ProduceStreamClient, error)
+	ProduceBatch(ctx context.Context, in *ProduceBatchRequest, opts ...grpc.CallOption) (*ProduceBatchResponse, error)
+	Offsets(ctx context.Context, in *OffsetsRequest, opts ...grpc.CallOption) (*OffsetsResponse, error)
+	InitProducer(ctx context.Context, in *InitProducerRequest, opts ...grpc.CallOption) (*InitProducerResponse, error)
+	BeginTxn(ctx context.Context, in *BeginTxnRequest, opts ...grpc.CallOption) (*BeginTxnResponse, error)
+	AddRecords(ctx context.Context, in *AddRecordsRequest, opts ...grpc.CallOption) (*AddRecordsResponse, error)
+	CommitTxn(ctx context.Context, in *CommitTxnRequest, opts ...grpc.CallOption) (*CommitTxnResponse, error)
+	AbortTxn(ctx context.Context, in *AbortTxnRequest, opts ...grpc.CallOption) (*AbortTxnResponse, error)
+	RegisterSchema(ctx context.Context, in *RegisterSchemaRequest, opts ...grpc.CallOption) (*RegisterSchemaResponse, error)
+	GetSchema(ctx context.Context, in *GetSchemaRequest, opts ...grpc.CallOption) (*GetSchemaResponse, error)
+	ListSchemaVersions(ctx context.Context, in *ListSchemaVersionsRequest, opts ...grpc.CallOption) (*ListSchemaVersionsResponse, error)
+	ReportFailure(ctx context.Context, in *ReportFailureRequest, opts ...grpc.CallOption) (*ReportFailureResponse, error)
+	ListDeadLetters(ctx context.Context, in *ListDeadLettersRequest, opts ...grpc.CallOption) (*ListDeadLettersResponse, error)
+	GetDeadLetter(ctx context.Context, in *GetDeadLetterRequest, opts ...grpc.CallOption) (*GetDeadLetterResponse, error)
+	RedriveDeadLetter(ctx context.Context, in *RedriveDeadLetterRequest, opts ...grpc.CallOption) (*RedriveDeadLetterResponse, error)
+	CancelSchedule(ctx context.Context, in *CancelScheduleRequest, opts ...grpc.CallOption) (*CancelScheduleResponse, error)
 }
 
 type endpointsClient struct {
@@ -86,8 +102,39 @@ func (x *endpointsConsumeStreamClient) Recv() (*ConsumeResponse, error) {
 	return m, nil
 }
 
+func (c *endpointsClient) Subscribe(ctx context.Context, opts ...grpc.CallOption) (Endpoints_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Endpoints_ServiceDesc.Streams[1], "/record.v1.Endpoints/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &endpointsSubscribeClient{stream}
+	return x, nil
+}
+
+type Endpoints_SubscribeClient interface {
+	Send(*SubscribeRequest) error
+	Recv() (*ConsumeResponse, error)
+	grpc.ClientStream
+}
+
+type endpointsSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *endpointsSubscribeClient) Send(m *SubscribeRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *endpointsSubscribeClient) Recv() (*ConsumeResponse, error) {
+	m := new(ConsumeResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *endpointsClient) ProduceStream(ctx context.Context, opts ...grpc.CallOption) (Endpoints_ProduceStreamClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Endpoints_ServiceDesc.Streams[1], "/record.v1.Endpoints/ProduceStream", opts...)
+	stream, err := c.cc.NewStream(ctx, &Endpoints_ServiceDesc.Streams[2], "/record.v1.Endpoints/ProduceStream", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -117,6 +164,141 @@ func (x *endpointsProduceStreamClient) Recv() (*ProduceResponse, error) {
 	return m, nil
 }
 
+func (c *endpointsClient) ProduceBatch(ctx context.Context, in *ProduceBatchRequest, opts ...grpc.CallOption) (*ProduceBatchResponse, error) {
+	out := new(ProduceBatchResponse)
+	err := c.cc.Invoke(ctx, "/record.v1.Endpoints/ProduceBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *endpointsClient) Offsets(ctx context.Context, in *OffsetsRequest, opts ...grpc.CallOption) (*OffsetsResponse, error) {
+	out := new(OffsetsResponse)
+	err := c.cc.Invoke(ctx, "/record.v1.Endpoints/Offsets", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *endpointsClient) InitProducer(ctx context.Context, in *InitProducerRequest, opts ...grpc.CallOption) (*InitProducerResponse, error) {
+	out := new(InitProducerResponse)
+	err := c.cc.Invoke(ctx, "/record.v1.Endpoints/InitProducer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *endpointsClient) BeginTxn(ctx context.Context, in *BeginTxnRequest, opts ...grpc.CallOption) (*BeginTxnResponse, error) {
+	out := new(BeginTxnResponse)
+	err := c.cc.Invoke(ctx, "/record.v1.Endpoints/BeginTxn", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *endpointsClient) AddRecords(ctx context.Context, in *AddRecordsRequest, opts ...grpc.CallOption) (*AddRecordsResponse, error) {
+	out := new(AddRecordsResponse)
+	err := c.cc.Invoke(ctx, "/record.v1.Endpoints/AddRecords", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *endpointsClient) CommitTxn(ctx context.Context, in *CommitTxnRequest, opts ...grpc.CallOption) (*CommitTxnResponse, error) {
+	out := new(CommitTxnResponse)
+	err := c.cc.Invoke(ctx, "/record.v1.Endpoints/CommitTxn", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *endpointsClient) AbortTxn(ctx context.Context, in *AbortTxnRequest, opts ...grpc.CallOption) (*AbortTxnResponse, error) {
+	out := new(AbortTxnResponse)
+	err := c.cc.Invoke(ctx, "/record.v1.Endpoints/AbortTxn", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *endpointsClient) RegisterSchema(ctx context.Context, in *RegisterSchemaRequest, opts ...grpc.CallOption) (*RegisterSchemaResponse, error) {
+	out := new(RegisterSchemaResponse)
+	err := c.cc.Invoke(ctx, "/record.v1.Endpoints/RegisterSchema", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *endpointsClient) GetSchema(ctx context.Context, in *GetSchemaRequest, opts ...grpc.CallOption) (*GetSchemaResponse, error) {
+	out := new(GetSchemaResponse)
+	err := c.cc.Invoke(ctx, "/record.v1.Endpoints/GetSchema", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *endpointsClient) ListSchemaVersions(ctx context.Context, in *ListSchemaVersionsRequest, opts ...grpc.CallOption) (*ListSchemaVersionsResponse, error) {
+	out := new(ListSchemaVersionsResponse)
+	err := c.cc.Invoke(ctx, "/record.v1.Endpoints/ListSchemaVersions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *endpointsClient) ReportFailure(ctx context.Context, in *ReportFailureRequest, opts ...grpc.CallOption) (*ReportFailureResponse, error) {
+	out := new(ReportFailureResponse)
+	err := c.cc.Invoke(ctx, "/record.v1.Endpoints/ReportFailure", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *endpointsClient) ListDeadLetters(ctx context.Context, in *ListDeadLettersRequest, opts ...grpc.CallOption) (*ListDeadLettersResponse, error) {
+	out := new(ListDeadLettersResponse)
+	err := c.cc.Invoke(ctx, "/record.v1.Endpoints/ListDeadLetters", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *endpointsClient) GetDeadLetter(ctx context.Context, in *GetDeadLetterRequest, opts ...grpc.CallOption) (*GetDeadLetterResponse, error) {
+	out := new(GetDeadLetterResponse)
+	err := c.cc.Invoke(ctx, "/record.v1.Endpoints/GetDeadLetter", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *endpointsClient) RedriveDeadLetter(ctx context.Context, in *RedriveDeadLetterRequest, opts ...grpc.CallOption) (*RedriveDeadLetterResponse, error) {
+	out := new(RedriveDeadLetterResponse)
+	err := c.cc.Invoke(ctx, "/record.v1.Endpoints/RedriveDeadLetter", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *endpointsClient) CancelSchedule(ctx context.Context, in *CancelScheduleRequest, opts ...grpc.CallOption) (*CancelScheduleResponse, error) {
+	out := new(CancelScheduleResponse)
+	err := c.cc.Invoke(ctx, "/record.v1.Endpoints/CancelSchedule", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // EndpointsServer is the server API for Endpoints service.
 // All implementations must embed UnimplementedEndpointsServer
 // for forward compatibility
@@ -124,7 +306,23 @@ type EndpointsServer interface {
 	Produce(context.Context, *ProduceRequest) (*ProduceResponse, error)
 	Consume(context.Context, *ConsumeRequest) (*ConsumeResponse, error)
 	ConsumeStream(*ConsumeRequest, Endpoints_ConsumeStreamServer) error
+	Subscribe(Endpoints_SubscribeServer) error
 	ProduceStream(Endpoints_ProduceStreamServer) error
+	ProduceBatch(context.Context, *ProduceBatchRequest) (*ProduceBatchResponse, error)
+	Offsets(context.Context, *OffsetsRequest) (*OffsetsResponse, error)
+	InitProducer(context.Context, *InitProducerRequest) (*InitProducerResponse, error)
+	BeginTxn(context.Context, *BeginTxnRequest) (*BeginTxnResponse, error)
+	AddRecords(context.Context, *AddRecordsRequest) (*AddRecordsResponse, error)
+	CommitTxn(context.Context, *CommitTxnRequest) (*CommitTxnResponse, error)
+	AbortTxn(context.Context, *AbortTxnRequest) (*AbortTxnResponse, error)
+	RegisterSchema(context.Context, *RegisterSchemaRequest) (*RegisterSchemaResponse, error)
+	GetSchema(context.Context, *GetSchemaRequest) (*GetSchemaResponse, error)
+	ListSchemaVersions(context.Context, *ListSchemaVersionsRequest) (*ListSchemaVersionsResponse, error)
+	ReportFailure(context.Context, *ReportFailureRequest) (*ReportFailureResponse, error)
+	ListDeadLetters(context.Context, *ListDeadLettersRequest) (*ListDeadLettersResponse, error)
+	GetDeadLetter(context.Context, *GetDeadLetterRequest) (*GetDeadLetterResponse, error)
+	RedriveDeadLetter(context.Context, *RedriveDeadLetterRequest) (*RedriveDeadLetterResponse, error)
+	CancelSchedule(context.Context, *CancelScheduleRequest) (*CancelScheduleResponse, error)
 	mustEmbedUnimplementedEndpointsServer()
 }
 
@@ -141,9 +339,57 @@ func (UnimplementedEndpointsServer) Consume(context.Context, *ConsumeRequest) (*
 func (UnimplementedEndpointsServer) ConsumeStream(*ConsumeRequest, Endpoints_ConsumeStreamServer) error {
 	return status.Errorf(codes.Unimplemented, "method ConsumeStream not implemented")
 }
+func (UnimplementedEndpointsServer) Subscribe(Endpoints_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
 func (UnimplementedEndpointsServer) ProduceStream(Endpoints_ProduceStreamServer) error {
 	return status.Errorf(codes.Unimplemented, "method ProduceStream not implemented")
 }
+func (UnimplementedEndpointsServer) ProduceBatch(context.Context, *ProduceBatchRequest) (*ProduceBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ProduceBatch not implemented")
+}
+func (UnimplementedEndpointsServer) Offsets(context.Context, *OffsetsRequest) (*OffsetsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Offsets not implemented")
+}
+func (UnimplementedEndpointsServer) InitProducer(context.Context, *InitProducerRequest) (*InitProducerResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InitProducer not implemented")
+}
+func (UnimplementedEndpointsServer) BeginTxn(context.Context, *BeginTxnRequest) (*BeginTxnResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BeginTxn not implemented")
+}
+func (UnimplementedEndpointsServer) AddRecords(context.Context, *AddRecordsRequest) (*AddRecordsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddRecords not implemented")
+}
+func (UnimplementedEndpointsServer) CommitTxn(context.Context, *CommitTxnRequest) (*CommitTxnResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CommitTxn not implemented")
+}
+func (UnimplementedEndpointsServer) AbortTxn(context.Context, *AbortTxnRequest) (*AbortTxnResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AbortTxn not implemented")
+}
+func (UnimplementedEndpointsServer) RegisterSchema(context.Context, *RegisterSchemaRequest) (*RegisterSchemaResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterSchema not implemented")
+}
+func (UnimplementedEndpointsServer) GetSchema(context.Context, *GetSchemaRequest) (*GetSchemaResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSchema not implemented")
+}
+func (UnimplementedEndpointsServer) ListSchemaVersions(context.Context, *ListSchemaVersionsRequest) (*ListSchemaVersionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSchemaVersions not implemented")
+}
+func (UnimplementedEndpointsServer) ReportFailure(context.Context, *ReportFailureRequest) (*ReportFailureResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportFailure not implemented")
+}
+func (UnimplementedEndpointsServer) ListDeadLetters(context.Context, *ListDeadLettersRequest) (*ListDeadLettersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDeadLetters not implemented")
+}
+func (UnimplementedEndpointsServer) GetDeadLetter(context.Context, *GetDeadLetterRequest) (*GetDeadLetterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDeadLetter not implemented")
+}
+func (UnimplementedEndpointsServer) RedriveDeadLetter(context.Context, *RedriveDeadLetterRequest) (*RedriveDeadLetterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RedriveDeadLetter not implemented")
+}
+func (UnimplementedEndpointsServer) CancelSchedule(context.Context, *CancelScheduleRequest) (*CancelScheduleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelSchedule not implemented")
+}
 func (UnimplementedEndpointsServer) mustEmbedUnimplementedEndpointsServer() {}
 
 // UnsafeEndpointsServer may be embedded to opt out of forward compatibility for this service.
@@ -214,6 +460,32 @@ func (x *endpointsConsumeStreamServer) Send(m *ConsumeResponse) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _Endpoints_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EndpointsServer).Subscribe(&endpointsSubscribeServer{stream})
+}
+
+type Endpoints_SubscribeServer interface {
+	Send(*ConsumeResponse) error
+	Recv() (*SubscribeRequest, error)
+	grpc.ServerStream
+}
+
+type endpointsSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *endpointsSubscribeServer) Send(m *ConsumeResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *endpointsSubscribeServer) Recv() (*SubscribeRequest, error) {
+	m := new(SubscribeRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func _Endpoints_ProduceStream_Handler(srv interface{}, stream grpc.ServerStream) error {
 	return srv.(EndpointsServer).ProduceStream(&endpointsProduceStreamServer{stream})
 }
@@ -240,6 +512,276 @@ func (x *endpointsProduceStreamServer) Recv() (*ProduceRequest, error) {
 	return m, nil
 }
 
+func _Endpoints_ProduceBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProduceBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EndpointsServer).ProduceBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/record.v1.Endpoints/ProduceBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EndpointsServer).ProduceBatch(ctx, req.(*ProduceBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Endpoints_Offsets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(OffsetsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EndpointsServer).Offsets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/record.v1.Endpoints/Offsets",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EndpointsServer).Offsets(ctx, req.(*OffsetsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Endpoints_InitProducer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InitProducerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EndpointsServer).InitProducer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/record.v1.Endpoints/InitProducer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EndpointsServer).InitProducer(ctx, req.(*InitProducerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Endpoints_BeginTxn_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BeginTxnRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EndpointsServer).BeginTxn(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/record.v1.Endpoints/BeginTxn",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EndpointsServer).BeginTxn(ctx, req.(*BeginTxnRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Endpoints_AddRecords_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddRecordsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EndpointsServer).AddRecords(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/record.v1.Endpoints/AddRecords",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EndpointsServer).AddRecords(ctx, req.(*AddRecordsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Endpoints_CommitTxn_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CommitTxnRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EndpointsServer).CommitTxn(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/record.v1.Endpoints/CommitTxn",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EndpointsServer).CommitTxn(ctx, req.(*CommitTxnRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Endpoints_AbortTxn_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AbortTxnRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EndpointsServer).AbortTxn(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/record.v1.Endpoints/AbortTxn",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EndpointsServer).AbortTxn(ctx, req.(*AbortTxnRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Endpoints_RegisterSchema_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterSchemaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EndpointsServer).RegisterSchema(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/record.v1.Endpoints/RegisterSchema",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EndpointsServer).RegisterSchema(ctx, req.(*RegisterSchemaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Endpoints_GetSchema_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSchemaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EndpointsServer).GetSchema(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/record.v1.Endpoints/GetSchema",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EndpointsServer).GetSchema(ctx, req.(*GetSchemaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Endpoints_ListSchemaVersions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSchemaVersionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EndpointsServer).ListSchemaVersions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/record.v1.Endpoints/ListSchemaVersions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EndpointsServer).ListSchemaVersions(ctx, req.(*ListSchemaVersionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Endpoints_ReportFailure_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportFailureRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EndpointsServer).ReportFailure(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/record.v1.Endpoints/ReportFailure",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EndpointsServer).ReportFailure(ctx, req.(*ReportFailureRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Endpoints_ListDeadLetters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDeadLettersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EndpointsServer).ListDeadLetters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/record.v1.Endpoints/ListDeadLetters",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EndpointsServer).ListDeadLetters(ctx, req.(*ListDeadLettersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Endpoints_GetDeadLetter_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDeadLetterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EndpointsServer).GetDeadLetter(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/record.v1.Endpoints/GetDeadLetter",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EndpointsServer).GetDeadLetter(ctx, req.(*GetDeadLetterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Endpoints_RedriveDeadLetter_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RedriveDeadLetterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EndpointsServer).RedriveDeadLetter(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/record.v1.Endpoints/RedriveDeadLetter",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EndpointsServer).RedriveDeadLetter(ctx, req.(*RedriveDeadLetterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Endpoints_CancelSchedule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelScheduleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EndpointsServer).CancelSchedule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/record.v1.Endpoints/CancelSchedule",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EndpointsServer).CancelSchedule(ctx, req.(*CancelScheduleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Endpoints_ServiceDesc is the grpc.ServiceDesc for Endpoints service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -255,6 +797,66 @@ var Endpoints_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Consume",
 			Handler:    _Endpoints_Consume_Handler,
 		},
+		{
+			MethodName: "ProduceBatch",
+			Handler:    _Endpoints_ProduceBatch_Handler,
+		},
+		{
+			MethodName: "Offsets",
+			Handler:    _Endpoints_Offsets_Handler,
+		},
+		{
+			MethodName: "InitProducer",
+			Handler:    _Endpoints_InitProducer_Handler,
+		},
+		{
+			MethodName: "BeginTxn",
+			Handler:    _Endpoints_BeginTxn_Handler,
+		},
+		{
+			MethodName: "AddRecords",
+			Handler:    _Endpoints_AddRecords_Handler,
+		},
+		{
+			MethodName: "CommitTxn",
+			Handler:    _Endpoints_CommitTxn_Handler,
+		},
+		{
+			MethodName: "AbortTxn",
+			Handler:    _Endpoints_AbortTxn_Handler,
+		},
+		{
+			MethodName: "RegisterSchema",
+			Handler:    _Endpoints_RegisterSchema_Handler,
+		},
+		{
+			MethodName: "GetSchema",
+			Handler:    _Endpoints_GetSchema_Handler,
+		},
+		{
+			MethodName: "ListSchemaVersions",
+			Handler:    _Endpoints_ListSchemaVersions_Handler,
+		},
+		{
+			MethodName: "ReportFailure",
+			Handler:    _Endpoints_ReportFailure_Handler,
+		},
+		{
+			MethodName: "ListDeadLetters",
+			Handler:    _Endpoints_ListDeadLetters_Handler,
+		},
+		{
+			MethodName: "GetDeadLetter",
+			Handler:    _Endpoints_GetDeadLetter_Handler,
+		},
+		{
+			MethodName: "RedriveDeadLetter",
+			Handler:    _Endpoints_RedriveDeadLetter_Handler,
+		},
+		{
+			MethodName: "CancelSchedule",
+			Handler:    _Endpoints_CancelSchedule_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -262,6 +864,12 @@ var Endpoints_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _Endpoints_ConsumeStream_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "Subscribe",
+			Handler:       _Endpoints_Subscribe_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 		{
 			StreamName:    "ProduceStream",
 			Handler:       _Endpoints_ProduceStream_Handler,
@@ -0,0 +1,29 @@
+package agent
+
+import (
+	"net/http"
+	"strings"
+)
+
+// authorize lets requests through to an admin handler only with a bearer
+// token from the tokens file, when there is one. The gateway's routes are
+// checked by the gRPC server instead.
+func (a *Agent) authorize(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(a.tokens) == 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		value := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(value, "Bearer ")
+
+		if _, ok := a.tokens[token]; !ok || token == value {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "a valid bearer token is required", http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -2,13 +2,60 @@ package agent
 
 import (
 	"fmt"
+	"time"
 )
 
 type Config struct {
-	RPCHost string
-	RPCPort int
+	RPCHost  string
+	RPCPort  int
+	HTTPPort int
+
+	DataDir             string
+	HealthCheckInterval time.Duration
+	MinFreeDiskBytes    uint64
+	ShutdownTimeout     time.Duration
+
+	RateLimitsFile string
+	TokensFile     string
+
+	MaxRecordSize    int
+	MaxBatchSize     int
+	AllowEmptyValues bool
+	RequireSchemas   bool
+
+	SchemaCompatibility string
+
+	TransactionTimeout time.Duration
+
+	MaxConsumeStreams int
+	MaxFailures       int
+
+	MaxMemoryBytes   uint64
+	SpillCacheChunks int
+	MaxRecords       uint64
+
+	Compression string
+
+	KeyFile string
+
+	Logging LoggingConfig
+}
+
+type LoggingConfig struct {
+	Level           string
+	ComponentLevels map[string]string
+	Format          string
+	File            string
+	MaxSizeMB       int
+	MaxBackups      int
+	MaxAgeDays      int
+	Sampling        bool
 }
 
 func (c Config) RPCAddr() (string, error) {
 	return fmt.Sprintf("%s:%d", c.RPCHost, c.RPCPort), nil
 }
+
+func (c Config) HTTPAddr() (string, error) {
+	return fmt.Sprintf("%s:%d", c.RPCHost, c.HTTPPort), nil
+}
@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// rotateKeys reloads the key file, and then wraps the data keys of the log,
+// of dead letters and of scheduled records with its last key, so keys before
+// it can be removed from the file.
+func (a *Agent) rotateKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := a.keys.Reload()
+	if err == nil {
+		err = a.log.Rewrap()
+	}
+	if err == nil {
+		err = a.deadLetters.Rewrap()
+	}
+	if err == nil {
+		err = a.scheduler.Rewrap()
+	}
+	if err != nil {
+		a.logger.Error("rotating keys", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	a.logger.Info("rotated keys", zap.String("key_id", a.keys.Current()))
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"keyId": a.keys.Current()})
+}
@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+var components = []string{"agent", "log", "server"}
+
+type loggerLevels struct {
+	root       zap.AtomicLevel
+	components map[string]zap.AtomicLevel
+}
+
+func newLoggerLevels(cfg LoggingConfig) (*loggerLevels, error) {
+	root, err := parseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	levels := &loggerLevels{
+		root:       root,
+		components: map[string]zap.AtomicLevel{},
+	}
+
+	for _, component := range components {
+		level, ok := cfg.ComponentLevels[component]
+		if !ok {
+			level = cfg.Level
+		}
+
+		levels.components[component], err = parseLevel(level)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for component := range cfg.ComponentLevels {
+		if _, ok := levels.components[component]; !ok {
+			return nil, fmt.Errorf("unknown logging component: %s", component)
+		}
+	}
+
+	return levels, nil
+}
+
+func (l *loggerLevels) levelFor(name string) zap.AtomicLevel {
+	component, _, _ := strings.Cut(name, ".")
+
+	if level, ok := l.components[component]; ok {
+		return level
+	}
+
+	return l.root
+}
+
+func (l *loggerLevels) enabled(level zapcore.Level) bool {
+	if l.root.Enabled(level) {
+		return true
+	}
+
+	for _, componentLevel := range l.components {
+		if componentLevel.Enabled(level) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l *loggerLevels) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("/admin/logging", l.root)
+
+	for component, level := range l.components {
+		mux.Handle("/admin/logging/"+component, level)
+	}
+
+	return mux
+}
+
+// componentCore filters entries by the level of the component that named
+// the logger, so zap.L().Named("server") can be tuned independently.
+type componentCore struct {
+	zapcore.Core
+	levels *loggerLevels
+}
+
+func (c *componentCore) Enabled(level zapcore.Level) bool {
+	return c.levels.enabled(level)
+}
+
+func (c *componentCore) With(fields []zapcore.Field) zapcore.Core {
+	return &componentCore{Core: c.Core.With(fields), levels: c.levels}
+}
+
+func (c *componentCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.levels.levelFor(entry.LoggerName).Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+
+	return checked
+}
+
+func newLogger(cfg LoggingConfig, levels *loggerLevels) (*zap.Logger, error) {
+	var encoder zapcore.Encoder
+
+	switch cfg.Format {
+	case "", "console":
+		encoder = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	case "json":
+		encoder = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	default:
+		return nil, fmt.Errorf("unknown logging format: %s", cfg.Format)
+	}
+
+	var sink zapcore.WriteSyncer
+
+	if len(cfg.File) > 0 {
+		sink = zapcore.AddSync(&lumberjack.Logger{
+			Filename:   cfg.File,
+			MaxSize:    cfg.MaxSizeMB,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAgeDays,
+		})
+	} else {
+		sink = zapcore.Lock(os.Stderr)
+	}
+
+	var core zapcore.Core = &componentCore{
+		Core:   zapcore.NewCore(encoder, sink, zap.DebugLevel),
+		levels: levels,
+	}
+
+	if cfg.Sampling {
+		core = zapcore.NewSamplerWithOptions(core, time.Second, 100, 100)
+	}
+
+	return zap.New(core, zap.AddCaller(), zap.AddStacktrace(zap.ErrorLevel)), nil
+}
+
+func parseLevel(level string) (zap.AtomicLevel, error) {
+	if len(level) == 0 {
+		return zap.NewAtomicLevelAt(zap.DebugLevel), nil
+	}
+
+	return zap.ParseAtomicLevel(level)
+}
@@ -1,25 +1,51 @@
 package agent
 
 import (
-	"fmt"
+	"crypto/rand"
+	"encoding/hex"
 	"net"
+	"net/http"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"github.com/w-h-a/grpc-server/pkg/compression"
+	"github.com/w-h-a/grpc-server/pkg/gateway"
 	"github.com/w-h-a/grpc-server/pkg/log"
+	"github.com/w-h-a/grpc-server/pkg/schema"
 	"github.com/w-h-a/grpc-server/pkg/server"
 	"go.opencensus.io/examples/exporter"
+	"go.opencensus.io/stats/view"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	defaultShutdownTimeout     = 30 * time.Second
+	defaultHealthCheckInterval = 10 * time.Second
 )
 
 type Agent struct {
 	Config Config
 
+	logger            *zap.Logger
+	loggerLevels      *loggerLevels
+	health            *server.Health
 	log               *log.Log
+	keys              *log.KeyFile
+	tokens            map[string]string
+	producers         *server.Producers
+	schemas           *server.Schemas
+	deadLetters       *server.DeadLetters
+	scheduler         *server.Scheduler
 	telemetryExporter *exporter.LogExporter
 	server            *grpc.Server
+	httpServer        *http.Server
+	gatewayConn       *grpc.ClientConn
+	gatewayKey        string
 
 	shutdown     bool
 	shutdownLock sync.Mutex
@@ -32,9 +58,12 @@ func NewAgent(config Config) (*Agent, error) {
 
 	setup := []func() error{
 		a.setupLogger,
+		a.setupHealth,
 		a.setupLog,
 		a.setupTelemetryExporter,
 		a.setupServer,
+		a.setupScheduler,
+		a.setupHTTPServer,
 	}
 
 	for _, fn := range setup {
@@ -44,23 +73,117 @@ func NewAgent(config Config) (*Agent, error) {
 		}
 	}
 
+	a.health.SetReady(true)
+
 	return a, nil
 }
 
 func (a *Agent) setupLogger() error {
-	logger, err := zap.NewDevelopment()
+	var err error
+
+	a.loggerLevels, err = newLoggerLevels(a.Config.Logging)
+	if err != nil {
+		return err
+	}
+
+	logger, err := newLogger(a.Config.Logging, a.loggerLevels)
 	if err != nil {
 		return err
 	}
 
 	zap.ReplaceGlobals(logger)
 
+	a.logger = logger.Named("agent")
+
+	return nil
+}
+
+func (a *Agent) setupHealth() error {
+	dataDir := a.Config.DataDir
+	if len(dataDir) == 0 {
+		dataDir = os.TempDir()
+	}
+
+	checks := []server.HealthCheck{
+		&server.WritableDirCheck{Dir: dataDir},
+	}
+
+	if a.Config.MinFreeDiskBytes > 0 {
+		checks = append(checks, &server.DiskSpaceCheck{Dir: dataDir, MinFreeBytes: a.Config.MinFreeDiskBytes})
+	}
+
+	// checks run every 10s by default, and not at all once negative
+	interval := a.Config.HealthCheckInterval
+	if interval == 0 {
+		interval = defaultHealthCheckInterval
+	}
+
+	a.health = server.NewHealth(interval, checks...)
+
 	return nil
 }
 
 func (a *Agent) setupLog() error {
-	var err error
-	a.log, err = log.NewLog()
+	err := view.Register(log.Views...)
+	if err != nil {
+		return err
+	}
+
+	logConfig := log.Config{
+		MaxMemoryBytes: a.Config.MaxMemoryBytes,
+		CacheChunks:    a.Config.SpillCacheChunks,
+		MaxRecords:     a.Config.MaxRecords,
+	}
+
+	if len(a.Config.DataDir) > 0 {
+		logConfig.Dir = filepath.Join(a.Config.DataDir, "log")
+	}
+
+	if len(a.Config.KeyFile) > 0 {
+		a.keys, err = log.LoadKeyFile(a.Config.KeyFile)
+		if err != nil {
+			return err
+		}
+		logConfig.Keys = a.keys
+	}
+
+	a.log, err = log.OpenLog(logConfig)
+	if err != nil {
+		return err
+	}
+
+	compatibility := contracts.Compatibility_COMPATIBILITY_BACKWARD
+	if len(a.Config.SchemaCompatibility) > 0 {
+		compatibility, err = schema.ParseCompatibility(a.Config.SchemaCompatibility)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(a.Config.DataDir) == 0 {
+		a.producers = server.NewProducers()
+		a.schemas = server.NewSchemas(compatibility)
+		a.deadLetters = server.NewDeadLetters(a.Config.MaxFailures)
+		a.scheduler = server.NewScheduler()
+		return nil
+	}
+
+	a.producers, err = server.LoadProducers(filepath.Join(a.Config.DataDir, "producers.json"), a.log.NextIndex())
+	if err != nil {
+		return err
+	}
+
+	a.schemas, err = server.LoadSchemas(filepath.Join(a.Config.DataDir, "schemas.json"), compatibility)
+	if err != nil {
+		return err
+	}
+
+	a.deadLetters, err = server.LoadDeadLetters(filepath.Join(a.Config.DataDir, "dead_letters.json"), a.Config.MaxFailures, a.log.NextIndex(), logConfig.Keys)
+	if err != nil {
+		return err
+	}
+
+	a.scheduler, err = server.LoadScheduler(filepath.Join(a.Config.DataDir, "schedules.json"), logConfig.Keys)
 	return err
 }
 
@@ -72,14 +195,16 @@ func (a *Agent) setupTelemetryExporter() error {
 		return err
 	}
 
-	fmt.Printf("metrics log file: %s", metricsLogFile.Name())
-
 	tracesLogFile, err := os.CreateTemp("", "traces-*.log")
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("traces log file: %s", tracesLogFile.Name())
+	a.logger.Info(
+		"exporting telemetry",
+		zap.String("metrics", metricsLogFile.Name()),
+		zap.String("traces", tracesLogFile.Name()),
+	)
 
 	a.telemetryExporter, err = exporter.NewLogExporter(exporter.Options{
 		MetricsLogFile:    metricsLogFile.Name(),
@@ -97,9 +222,53 @@ func (a *Agent) setupServer() error {
 	var err error
 
 	serverConfig := &server.Config{
-		CommitLog: a.log,
+		CommitLog:        a.log,
+		Health:           a.health,
+		Producers:        a.producers,
+		Schemas:          a.schemas,
+		DeadLetters:      a.deadLetters,
+		Scheduler:        a.scheduler,
+		MaxRecordSize:    a.Config.MaxRecordSize,
+		MaxBatchSize:     a.Config.MaxBatchSize,
+		AllowEmptyValues: a.Config.AllowEmptyValues,
+		RequireSchemas:   a.Config.RequireSchemas,
+
+		TransactionTimeout: a.Config.TransactionTimeout,
+		MaxConsumeStreams:  a.Config.MaxConsumeStreams,
+	}
+
+	if len(a.Config.Compression) > 0 {
+		serverConfig.Compression, err = compression.Parse(a.Config.Compression)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(a.Config.TokensFile) > 0 {
+		a.tokens, err = server.LoadTokens(a.Config.TokensFile)
+		if err != nil {
+			return err
+		}
+		serverConfig.Tokens = a.tokens
+	}
+
+	if len(a.Config.RateLimitsFile) > 0 {
+		serverConfig.RateLimits, err = server.LoadRateLimits(a.Config.RateLimitsFile)
+		if err != nil {
+			return err
+		}
 	}
 
+	// the gateway's connection proves with this key that the client
+	// addresses it forwards can be trusted
+	key := make([]byte, 16)
+	_, err = rand.Read(key)
+	if err != nil {
+		return err
+	}
+	a.gatewayKey = hex.EncodeToString(key)
+	serverConfig.GatewayKey = a.gatewayKey
+
 	a.server, err = server.NewGRPCServer(serverConfig)
 	if err != nil {
 		return err
@@ -122,9 +291,98 @@ func (a *Agent) setupServer() error {
 		}
 	}()
 
+	a.logger.Info("serving rpc", zap.String("addr", rpcAddr))
+
 	return err
 }
 
+// setupScheduler starts delivering scheduled records, including those
+// restored from the journal, once the server is up.
+func (a *Agent) setupScheduler() error {
+	a.scheduler.Start(a.log.Append)
+	return nil
+}
+
+func (a *Agent) setupHTTPServer() error {
+	if a.Config.HTTPPort == 0 {
+		return nil
+	}
+
+	httpAddr, err := a.Config.HTTPAddr()
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", httpAddr)
+	if err != nil {
+		return err
+	}
+
+	rpcAddr, err := a.Config.RPCAddr()
+	if err != nil {
+		return err
+	}
+
+	a.gatewayConn, err = grpc.Dial(
+		rpcAddr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(gateway.Credentials(a.gatewayKey)),
+	)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/admin/logging", a.authorize(a.loggerLevels.handler()))
+	mux.Handle("/admin/logging/", a.authorize(a.loggerLevels.handler()))
+	if a.keys != nil {
+		mux.Handle("/admin/keys/rotate", a.authorize(http.HandlerFunc(a.rotateKeys)))
+	}
+	mux.Handle("/v1/", gateway.NewHandler(contracts.NewEndpointsClient(a.gatewayConn)))
+
+	a.httpServer = &http.Server{Handler: mux}
+
+	go func() {
+		err := a.httpServer.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			_ = a.Shutdown()
+		}
+	}()
+
+	a.logger.Info("serving http", zap.String("addr", httpAddr))
+
+	return nil
+}
+
+// stopServer waits for in-flight RPCs to finish, and forcibly closes whatever
+// is still open once the shutdown timeout elapses.
+func (a *Agent) stopServer() error {
+	stopped := make(chan struct{})
+
+	go func() {
+		a.server.GracefulStop()
+		close(stopped)
+	}()
+
+	timeout := a.Config.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-stopped:
+	case <-timer.C:
+		a.logger.Warn("shutdown timeout elapsed, closing open rpcs", zap.Duration("timeout", timeout))
+		a.server.Stop()
+		<-stopped
+	}
+
+	return nil
+}
+
 func (a *Agent) Shutdown() error {
 	a.shutdownLock.Lock()
 	defer a.shutdownLock.Unlock()
@@ -135,9 +393,43 @@ func (a *Agent) Shutdown() error {
 
 	a.shutdown = true
 
+	a.logger.Info("shutting down")
+
 	shutdowns := []func() error{
 		func() error {
-			a.server.GracefulStop()
+			a.health.Drain()
+			return nil
+		},
+		func() error {
+			if a.httpServer != nil {
+				return a.httpServer.Close()
+			}
+			return nil
+		},
+		func() error {
+			if a.gatewayConn != nil {
+				return a.gatewayConn.Close()
+			}
+			return nil
+		},
+		a.stopServer,
+		func() error {
+			return a.producers.Close()
+		},
+		func() error {
+			return a.schemas.Close()
+		},
+		func() error {
+			return a.deadLetters.Close()
+		},
+		func() error {
+			return a.scheduler.Close()
+		},
+		func() error {
+			return a.log.Close()
+		},
+		func() error {
+			a.health.Close()
 			return nil
 		},
 		func() error {
@@ -157,5 +449,7 @@ func (a *Agent) Shutdown() error {
 		}
 	}
 
+	_ = a.logger.Sync()
+
 	return nil
 }
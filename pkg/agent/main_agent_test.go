@@ -2,39 +2,55 @@ package agent
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/travisjeffery/go-dynaport"
 	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	healthsrv "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
 )
 
 func TestAgent(t *testing.T) {
-	tests := make(map[string]func(t *testing.T, client contracts.EndpointsClient))
+	tests := make(map[string]func(t *testing.T, agent *Agent, client contracts.EndpointsClient))
 
 	tests["consume beyond range"] = testConsumeBeyondRange
 	tests["produce and consume requests"] = testProduceConsume
+	tests["health"] = testHealth
 
 	for situation, fn := range tests {
 		t.Run(situation, func(t *testing.T) {
-			client, teardown := setupTest(t)
+			agent, client, teardown := setupTest(t, nil)
 			defer teardown()
 
-			fn(t, client)
+			fn(t, agent, client)
 		})
 	}
 }
 
-func setupTest(t *testing.T) (client contracts.EndpointsClient, teardown func()) {
+func setupTest(t *testing.T, fn func(*Config)) (agent *Agent, client contracts.EndpointsClient, teardown func()) {
 	// setup server agent
 	ports := dynaport.Get(1)
 	cfg := Config{
 		RPCHost: "127.0.0.1",
 		RPCPort: ports[0],
 	}
+	if fn != nil {
+		fn(&cfg)
+	}
 
 	agent, err := NewAgent(cfg)
 	require.NoError(t, err)
@@ -43,7 +59,7 @@ func setupTest(t *testing.T) (client contracts.EndpointsClient, teardown func())
 	clientConn, client := createNewClient(t, agent)
 
 	// return
-	return client, func() {
+	return agent, client, func() {
 		clientConn.Close()
 		err := agent.Shutdown()
 		require.NoError(t, err)
@@ -62,7 +78,7 @@ func createNewClient(t *testing.T, agent *Agent) (*grpc.ClientConn, contracts.En
 	return conn, client
 }
 
-func testConsumeBeyondRange(t *testing.T, client contracts.EndpointsClient) {
+func testConsumeBeyondRange(t *testing.T, agent *Agent, client contracts.EndpointsClient) {
 	ctx := context.Background()
 
 	consumeResponse, err := client.Consume(ctx, &contracts.ConsumeRequest{Index: uint64(1)})
@@ -74,7 +90,7 @@ func testConsumeBeyondRange(t *testing.T, client contracts.EndpointsClient) {
 	require.Equal(t, want, got)
 }
 
-func testProduceConsume(t *testing.T, client contracts.EndpointsClient) {
+func testProduceConsume(t *testing.T, agent *Agent, client contracts.EndpointsClient) {
 	ctx := context.Background()
 	want := &contracts.Record{Value: "foo"}
 	produceResponse, err := client.Produce(ctx, &contracts.ProduceRequest{Record: want})
@@ -86,3 +102,182 @@ func testProduceConsume(t *testing.T, client contracts.EndpointsClient) {
 	require.Equal(t, want.Value, consumeResponse.Record.Value)
 	require.Equal(t, want.Index, consumeResponse.Record.Index)
 }
+
+func testHealth(t *testing.T, agent *Agent, client contracts.EndpointsClient) {
+	clientConn, _ := createNewClient(t, agent)
+	defer clientConn.Close()
+
+	healthClient := healthsrv.NewHealthClient(clientConn)
+
+	res, err := healthClient.Check(context.Background(), &healthsrv.HealthCheckRequest{Service: "record.v1.Endpoints"})
+	require.NoError(t, err)
+	require.Equal(t, healthsrv.HealthCheckResponse_SERVING, res.Status)
+
+	agent.health.Drain()
+
+	res, err = healthClient.Check(context.Background(), &healthsrv.HealthCheckRequest{Service: "record.v1.Endpoints"})
+	require.NoError(t, err)
+	require.Equal(t, healthsrv.HealthCheckResponse_NOT_SERVING, res.Status)
+}
+
+func TestShutdown(t *testing.T) {
+	agent, client, teardown := setupTest(t, func(cfg *Config) {
+		cfg.ShutdownTimeout = 200 * time.Millisecond
+	})
+	defer teardown()
+
+	ctx := context.Background()
+
+	_, err := client.Produce(ctx, &contracts.ProduceRequest{Record: &contracts.Record{Value: "foo"}})
+	require.NoError(t, err)
+
+	consumeStream, err := client.ConsumeStream(ctx, &contracts.ConsumeRequest{Index: 0})
+	require.NoError(t, err)
+
+	_, err = consumeStream.Recv()
+	require.NoError(t, err)
+
+	// an idle produce stream never returns on its own, so only the timeout
+	// can end it
+	produceStream, err := client.ProduceStream(ctx)
+	require.NoError(t, err)
+	defer produceStream.CloseSend()
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- agent.Shutdown()
+	}()
+
+	_, err = consumeStream.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, contracts.ErrShuttingDown{NextIndex: 1}, contracts.FromError(err))
+
+	select {
+	case err := <-shutdown:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not complete")
+	}
+}
+
+func TestLoggerLevels(t *testing.T) {
+	levels, err := newLoggerLevels(LoggingConfig{
+		Level:           "info",
+		ComponentLevels: map[string]string{"server": "warn"},
+	})
+	require.NoError(t, err)
+
+	observed, logs := observer.New(zap.DebugLevel)
+	logger := zap.New(&componentCore{Core: observed, levels: levels})
+
+	logger.Named("server").Info("dropped")
+	logger.Named("log").Info("kept")
+	require.Equal(t, 1, logs.Len())
+
+	req := httptest.NewRequest(http.MethodPut, "/admin/logging/server", strings.NewReader(`{"level":"debug"}`))
+	rec := httptest.NewRecorder()
+	levels.handler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	logger.Named("server").With(zap.String("peer.address", "127.0.0.1")).Debug("kept")
+	require.Equal(t, 2, logs.Len())
+
+	_, err = newLoggerLevels(LoggingConfig{ComponentLevels: map[string]string{"unknown": "info"}})
+	require.Error(t, err)
+}
+
+func TestAdminAuthentication(t *testing.T) {
+	tokensFile := filepath.Join(t.TempDir(), "tokens")
+	require.NoError(t, os.WriteFile(tokensFile, []byte("secret ops\n"), 0o600))
+
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(keyFile, []byte(newKeyLine(t, "k1")), 0o600))
+
+	agent, _, teardown := setupTest(t, func(cfg *Config) {
+		cfg.HTTPPort = dynaport.Get(1)[0]
+		cfg.TokensFile = tokensFile
+		cfg.KeyFile = keyFile
+	})
+	defer teardown()
+
+	httpAddr, err := agent.Config.HTTPAddr()
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		method        string
+		path          string
+		authorization string
+		code          int
+	}{
+		"logging without a token":  {method: http.MethodGet, path: "/admin/logging", code: http.StatusUnauthorized},
+		"logging with a bad token": {method: http.MethodGet, path: "/admin/logging", authorization: "Bearer wrong", code: http.StatusUnauthorized},
+		"logging without bearer":   {method: http.MethodGet, path: "/admin/logging", authorization: "secret", code: http.StatusUnauthorized},
+		"logging with a token":     {method: http.MethodGet, path: "/admin/logging", authorization: "Bearer secret", code: http.StatusOK},
+		"rotate without a token":   {method: http.MethodPost, path: "/admin/keys/rotate", code: http.StatusUnauthorized},
+		"rotate with a token":      {method: http.MethodPost, path: "/admin/keys/rotate", authorization: "Bearer secret", code: http.StatusOK},
+	}
+
+	for situation, test := range tests {
+		t.Run(situation, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, "http://"+httpAddr+test.path, nil)
+			require.NoError(t, err)
+			if len(test.authorization) > 0 {
+				req.Header.Set("Authorization", test.authorization)
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			res.Body.Close()
+			require.Equal(t, test.code, res.StatusCode)
+		})
+	}
+}
+
+func TestRotateKeys(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	k1, k2 := newKeyLine(t, "k1"), newKeyLine(t, "k2")
+	require.NoError(t, os.WriteFile(keyFile, []byte(k1), 0o600))
+
+	agent, client, teardown := setupTest(t, func(cfg *Config) {
+		cfg.DataDir = t.TempDir()
+		cfg.MaxMemoryBytes = 1
+		cfg.KeyFile = keyFile
+	})
+	defer teardown()
+
+	records := make([]*contracts.Record, 10000)
+	for i := range records {
+		records[i] = &contracts.Record{Value: "hello world"}
+	}
+	_, err := client.ProduceBatch(context.Background(), &contracts.ProduceBatchRequest{Records: records})
+	require.NoError(t, err)
+	_, err = client.Produce(context.Background(), &contracts.ProduceRequest{Record: &contracts.Record{Value: "hello world"}})
+	require.NoError(t, err)
+
+	// the first key can be dropped once the data keys are wrapped with the second
+	require.NoError(t, os.WriteFile(keyFile, []byte(k1+k2), 0o600))
+
+	rec := httptest.NewRecorder()
+	agent.rotateKeys(rec, httptest.NewRequest(http.MethodGet, "/admin/keys/rotate", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	agent.rotateKeys(rec, httptest.NewRequest(http.MethodPost, "/admin/keys/rotate", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"keyId":"k2"}`, rec.Body.String())
+
+	require.NoError(t, os.WriteFile(keyFile, []byte(k2), 0o600))
+	require.NoError(t, agent.keys.Reload())
+
+	res, err := client.Consume(context.Background(), &contracts.ConsumeRequest{Index: 0})
+	require.NoError(t, err)
+	require.Equal(t, "hello world", res.Record.Value)
+}
+
+func newKeyLine(t *testing.T, id string) string {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	return id + " " + base64.StdEncoding.EncodeToString(key) + "\n"
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"crypto/tls"
+	"time"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+)
+
+type Config struct {
+	Addr    string
+	Token   string
+	TLS     *tls.Config
+	Retries int
+	Timeout time.Duration
+
+	// Compression is the codec the server stores the client's records with,
+	// and GRPCCompression the name of the codec for gRPC messages, as in
+	// "gzip".
+	Compression     contracts.Compression
+	GRPCCompression string
+}
+
+type ProducerConfig struct {
+	// Idempotent producers number their records so the server can drop
+	// duplicates, which makes every failed batch safe to retry. Producers
+	// with the same Name fence each other off, so only the newest one of
+	// them can produce.
+	Idempotent bool
+	Name       string
+
+	Compression contracts.Compression
+	// SchemaID, when set, is the schema the producer's records conform to.
+	SchemaID uint32
+
+	BatchSize  int
+	BatchBytes int
+	Linger     time.Duration
+	BufferSize int
+	// MaxRetries is how many times a failed batch is retried, 3 by default;
+	// a negative MaxRetries turns retries off.
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+type ConsumerConfig struct {
+	// Name, when set, is the consumer name failures are reported under, and
+	// the consumer skips records dead-lettered for it.
+	Name           string
+	From           uint64
+	SkipTruncated  bool
+	ReadCommitted  bool
+	Filter         *contracts.Filter
+	Credits        uint64
+	CreditBytes    uint64
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+func (c *ProducerConfig) setDefaults() {
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+
+	if c.BatchBytes <= 0 {
+		c.BatchBytes = 1024 * 1024
+	}
+
+	if c.Linger <= 0 {
+		c.Linger = 5 * time.Millisecond
+	}
+
+	if c.BufferSize <= 0 {
+		c.BufferSize = 10 * c.BatchSize
+	}
+
+	if c.MaxRetries == 0 {
+		c.MaxRetries = 3
+	}
+
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = 100 * time.Millisecond
+	}
+
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 10 * time.Second
+	}
+}
+
+func (c *ConsumerConfig) setDefaults() {
+	if c.Credits == 0 {
+		c.Credits = 100
+	}
+
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = 100 * time.Millisecond
+	}
+
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 10 * time.Second
+	}
+}
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"github.com/w-h-a/grpc-server/pkg/compression"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Consumer follows the log through Subscribe, reconnecting with exponential
+// backoff and resuming after the last record it delivered. It grants the
+// server Credits records (and CreditBytes bytes, if set) ahead of the ones
+// it has handled, so a slow handler pauses the server instead of filling
+// buffers.
+type Consumer struct {
+	endpoints contracts.EndpointsClient
+	config    ConsumerConfig
+	next      uint64
+}
+
+func newConsumer(endpoints contracts.EndpointsClient, config ConsumerConfig) *Consumer {
+	config.setDefaults()
+
+	return &Consumer{
+		endpoints: endpoints,
+		config:    config,
+		next:      config.From,
+	}
+}
+
+// Position is the index of the next record the consumer will deliver.
+func (c *Consumer) Position() uint64 {
+	return atomic.LoadUint64(&c.next)
+}
+
+// Consume calls handler with every record from the consumer's position
+// until ctx is done, handler returns an error, or the server rejects the
+// subscription. A record is only considered delivered once handler returns
+// nil for it.
+func (c *Consumer) Consume(ctx context.Context, handler func(*contracts.Record) error) error {
+	backoff := c.config.InitialBackoff
+
+	for {
+		delivered, err := c.subscribe(ctx, handler)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if delivered {
+			backoff = c.config.InitialBackoff
+		}
+
+		switch err := err.(type) {
+		case handlerError:
+			return err.err
+		case contracts.ErrShuttingDown:
+			// the server is going away; reconnect, likely to another one
+		case contracts.ErrTruncated:
+			if !c.config.SkipTruncated {
+				return err
+			}
+			atomic.StoreUint64(&c.next, err.FirstIndex)
+			continue
+		default:
+			if !reconnectable(err) {
+				return err
+			}
+		}
+
+		err = sleep(ctx, retryDelay(err, backoff))
+		if err != nil {
+			return err
+		}
+
+		backoff = nextBackoff(backoff, c.config.MaxBackoff)
+	}
+}
+
+type handlerError struct {
+	err error
+}
+
+func (e handlerError) Error() string {
+	return e.err.Error()
+}
+
+func (c *Consumer) subscribe(ctx context.Context, handler func(*contracts.Record) error) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.endpoints.Subscribe(ctx)
+	if err != nil {
+		return false, contracts.FromError(err)
+	}
+
+	err = stream.Send(&contracts.SubscribeRequest{
+		Consume: &contracts.ConsumeRequest{
+			Index:             c.Position(),
+			ReadCommitted:     c.config.ReadCommitted,
+			Filter:            c.config.Filter,
+			AcceptCompression: compression.Codecs,
+			Consumer:          c.config.Name,
+		},
+		CreditRecords: c.config.Credits,
+		CreditBytes:   c.config.CreditBytes,
+	})
+	if err != nil {
+		_, err = stream.Recv()
+		return false, contracts.FromError(err)
+	}
+
+	delivered := false
+
+	// credits used since the last grant, given back once half are used
+	var usedRecords, usedBytes uint64
+
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			return delivered, contracts.FromError(err)
+		}
+
+		// filtered streams report progress past records they skipped
+		if res.Record == nil {
+			if res.ProgressIndex != nil && *res.ProgressIndex > c.Position() {
+				atomic.StoreUint64(&c.next, *res.ProgressIndex)
+			}
+			continue
+		}
+
+		// a reconnect can never go backwards, but guard against redelivery
+		if res.Record.Index < c.Position() {
+			continue
+		}
+
+		// the server charged byte credit for the record as it was sent,
+		// before it's decompressed
+		size := uint64(len(res.Record.Value) + len(res.Record.CompressedValue))
+
+		// compressed records are sent as they're stored, and decompressed here
+		err = compression.DecompressRecord(res.Record)
+		if err != nil {
+			return delivered, status.Errorf(codes.DataLoss, "record %d: %v", res.Record.Index, err)
+		}
+
+		err = handler(res.Record)
+		if err != nil {
+			return delivered, handlerError{err}
+		}
+
+		delivered = true
+		atomic.StoreUint64(&c.next, res.Record.Index+1)
+
+		usedRecords++
+		usedBytes += size
+
+		if usedRecords >= (c.config.Credits+1)/2 || (c.config.CreditBytes > 0 && usedBytes >= (c.config.CreditBytes+1)/2) {
+			err = stream.Send(&contracts.SubscribeRequest{CreditRecords: usedRecords, CreditBytes: c.credited(usedBytes)})
+			if err != nil {
+				_, err = stream.Recv()
+				return delivered, contracts.FromError(err)
+			}
+			usedRecords, usedBytes = 0, 0
+		}
+	}
+}
+
+func (c *Consumer) credited(usedBytes uint64) uint64 {
+	if c.config.CreditBytes == 0 {
+		return 0
+	}
+
+	return usedBytes
+}
+
+func reconnectable(err error) bool {
+	if err == io.EOF {
+		return true
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.Internal, codes.Unknown, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,334 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"github.com/w-h-a/grpc-server/pkg/compression"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// retryServiceConfig retries calls that failed with UNAVAILABLE, using
+// gRPC's built-in retry support. gRPC caps attempts at 5.
+const retryServiceConfig = `{
+	"methodConfig": [{
+		"name": [{"service": "record.v1.Endpoints"}, {"service": "grpc.health.v1.Health"}],
+		"retryPolicy": {
+			"maxAttempts": %d,
+			"initialBackoff": "0.1s",
+			"maxBackoff": "2s",
+			"backoffMultiplier": 2,
+			"retryableStatusCodes": ["UNAVAILABLE"]
+		}
+	}]
+}`
+
+// Client wraps the Endpoints service, converting errors from the server
+// into the typed errors of the contracts package.
+type Client struct {
+	conn        *grpc.ClientConn
+	endpoints   contracts.EndpointsClient
+	timeout     time.Duration
+	compression contracts.Compression
+}
+
+func Dial(config Config, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if config.TLS != nil {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(config.TLS)))
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+
+	if len(config.Token) > 0 {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials(config.Token)))
+	}
+
+	if config.Retries > 0 {
+		opts = append(opts, grpc.WithDefaultServiceConfig(fmt.Sprintf(retryServiceConfig, config.Retries+1)))
+	}
+
+	if len(config.GRPCCompression) > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.UseCompressor(config.GRPCCompression)))
+	}
+
+	return grpc.Dial(config.Addr, opts...)
+}
+
+func NewClient(config Config, opts ...grpc.DialOption) (*Client, error) {
+	conn, err := Dial(config, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	return &Client{
+		conn:        conn,
+		endpoints:   contracts.NewEndpointsClient(conn),
+		timeout:     timeout,
+		compression: config.Compression,
+	}, nil
+}
+
+func (c *Client) Conn() *grpc.ClientConn {
+	return c.conn
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) Produce(ctx context.Context, value string) (uint64, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+
+	res, err := c.endpoints.Produce(ctx, &contracts.ProduceRequest{Record: &contracts.Record{Value: value}, Compression: c.compression})
+	if err != nil {
+		return 0, contracts.FromError(err)
+	}
+
+	return res.Index, nil
+}
+
+func (c *Client) ProduceBatch(ctx context.Context, values ...string) ([]uint64, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+
+	req, err := newBatch(newRecords(values), c.compression)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.endpoints.ProduceBatch(ctx, req)
+	if err != nil {
+		return nil, contracts.FromError(err)
+	}
+
+	return res.Indexes, nil
+}
+
+// ProduceAt appends values only if the log's next index is expectedIndex,
+// and otherwise fails with contracts.ErrUnexpectedIndex.
+func (c *Client) ProduceAt(ctx context.Context, expectedIndex uint64, values ...string) ([]uint64, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+
+	req, err := newBatch(newRecords(values), c.compression)
+	if err != nil {
+		return nil, err
+	}
+	req.ExpectedIndex = &expectedIndex
+
+	res, err := c.endpoints.ProduceBatch(ctx, req)
+	if err != nil {
+		return nil, contracts.FromError(err)
+	}
+
+	return res.Indexes, nil
+}
+
+// Schedule has the server append value at deliverAt, and returns the id to
+// cancel it with until then.
+func (c *Client) Schedule(ctx context.Context, value string, deliverAt time.Time) (uint64, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+
+	res, err := c.endpoints.Produce(ctx, &contracts.ProduceRequest{
+		Record:      &contracts.Record{Value: value},
+		Compression: c.compression,
+		DeliverAt:   timestamppb.New(deliverAt),
+	})
+	if err != nil {
+		return 0, contracts.FromError(err)
+	}
+
+	return res.ScheduleId, nil
+}
+
+// CancelSchedule drops a scheduled value, unless it was already appended,
+// in which case it fails with contracts.ErrAlreadyDelivered.
+func (c *Client) CancelSchedule(ctx context.Context, scheduleID uint64) error {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+
+	_, err := c.endpoints.CancelSchedule(ctx, &contracts.CancelScheduleRequest{ScheduleId: scheduleID})
+	if err != nil {
+		return contracts.FromError(err)
+	}
+
+	return nil
+}
+
+func (c *Client) Consume(ctx context.Context, index uint64) (*contracts.Record, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+
+	res, err := c.endpoints.Consume(ctx, &contracts.ConsumeRequest{Index: index, AcceptCompression: compression.Codecs})
+	if err != nil {
+		return nil, contracts.FromError(err)
+	}
+
+	err = compression.DecompressRecord(res.Record)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Record, nil
+}
+
+func (c *Client) Offsets(ctx context.Context) (*contracts.OffsetsResponse, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+
+	res, err := c.endpoints.Offsets(ctx, &contracts.OffsetsRequest{})
+	if err != nil {
+		return nil, contracts.FromError(err)
+	}
+
+	return res, nil
+}
+
+// ReportFailure reports that consumer failed to handle the record at index.
+// The response says whether the record is now dead-lettered, in which case
+// consumers with that name skip it.
+func (c *Client) ReportFailure(ctx context.Context, consumer string, index uint64, reason string) (*contracts.ReportFailureResponse, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+
+	res, err := c.endpoints.ReportFailure(ctx, &contracts.ReportFailureRequest{Consumer: consumer, Index: index, Reason: reason})
+	if err != nil {
+		return nil, contracts.FromError(err)
+	}
+
+	return res, nil
+}
+
+func (c *Client) NewProducer(config ProducerConfig) *Producer {
+	if config.Compression == contracts.Compression_COMPRESSION_UNSPECIFIED {
+		config.Compression = c.compression
+	}
+
+	return newProducer(c.endpoints, c.timeout, config)
+}
+
+func (c *Client) NewConsumer(config ConsumerConfig) *Consumer {
+	return newConsumer(c.endpoints, config)
+}
+
+func newRecords(values []string) []*contracts.Record {
+	records := make([]*contracts.Record, len(values))
+	for i, value := range values {
+		records[i] = &contracts.Record{Value: value}
+	}
+
+	return records
+}
+
+// newBatch sends records compressed with codec as one unit, which shrinks
+// runs of similar records far more than compressing each value. Without a
+// codec, the server's default compresses them once they arrive.
+func newBatch(records []*contracts.Record, codec contracts.Compression) (*contracts.ProduceBatchRequest, error) {
+	req := &contracts.ProduceBatchRequest{Compression: codec}
+
+	if codec == contracts.Compression_COMPRESSION_UNSPECIFIED || codec == contracts.Compression_COMPRESSION_NONE {
+		req.Records = records
+		return req, nil
+	}
+
+	data, err := proto.Marshal(&contracts.RecordBatch{Records: records})
+	if err != nil {
+		return nil, err
+	}
+
+	req.CompressedRecords, err = compression.Compress(codec, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func (c *Client) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+// retryable reports whether a request failed without the server doing any
+// work, or failed in a way worth trying again.
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+		return true
+	default:
+		return false
+	}
+}
+
+// ambiguous reports whether a request may have been applied by the server
+// even though it failed, which only idempotent requests can safely retry.
+func ambiguous(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Unknown, codes.Internal:
+		return true
+	default:
+		return false
+	}
+}
+
+func retryDelay(err error, backoff time.Duration) time.Duration {
+	if overloaded, ok := err.(contracts.ErrOverloaded); ok && overloaded.RetryAfter > backoff {
+		return overloaded.RetryAfter
+	}
+
+	return backoff
+}
+
+func nextBackoff(backoff, max time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > max {
+		return max
+	}
+
+	return backoff
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity is false so tokens also work against plaintext
+// development servers.
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
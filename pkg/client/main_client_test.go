@@ -0,0 +1,532 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"github.com/w-h-a/grpc-server/pkg/compression"
+	"github.com/w-h-a/grpc-server/pkg/log"
+	"github.com/w-h-a/grpc-server/pkg/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClient(t *testing.T) {
+	tests := make(map[string]func(t *testing.T, client *Client, restart func()))
+
+	tests["produce and consume"] = testProduceConsume
+	tests["producer batches in order"] = testProducer
+	tests["producer retries when overloaded"] = testProducerRetries
+	tests["idempotent producer retries lost acks"] = testIdempotentProducer
+	tests["idempotent producers are fenced"] = testIdempotentProducerFenced
+	tests["consumer resumes after restart"] = testConsumerResumes
+	tests["read committed transactions"] = testTransactions
+	tests["filtered consumer tracks progress"] = testFilteredConsumer
+	tests["consumer skips truncated records"] = testConsumerSkipsTruncated
+	tests["compressed records"] = testCompression
+	tests["producer with a schema"] = testSchemaProducer
+	tests["consumer skips dead letters"] = testDeadLetters
+	tests["scheduled values"] = testSchedule
+
+	for situation, fn := range tests {
+		t.Run(situation, func(t *testing.T) {
+			client, restart, teardown := setupTest(t, nil)
+			defer teardown()
+
+			fn(t, client, restart)
+		})
+	}
+}
+
+type truncatedLog struct {
+	*log.Log
+	firstIndex uint64
+}
+
+func (l *truncatedLog) Read(index uint64) (*contracts.Record, error) {
+	if index < l.firstIndex {
+		return nil, contracts.ErrTruncated{Index: index, FirstIndex: l.firstIndex}
+	}
+	return l.Log.Read(index)
+}
+
+func setupTest(t *testing.T, fn func(*server.Config)) (client *Client, restart func(), teardown func()) {
+	// setup log
+	log, err := log.NewLog()
+	require.NoError(t, err)
+
+	// setup server
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	addr := listener.Addr().String()
+
+	scheduler := server.NewScheduler()
+	scheduler.Start(log.Append)
+
+	var gsrv *grpc.Server
+
+	serve := func(listener net.Listener) {
+		cfg := &server.Config{CommitLog: &truncatedLog{Log: log}, Scheduler: scheduler}
+		if fn != nil {
+			fn(cfg)
+		}
+
+		gsrv, err = server.NewGRPCServer(cfg)
+		require.NoError(t, err)
+
+		go func() {
+			gsrv.Serve(listener)
+		}()
+	}
+
+	serve(listener)
+
+	// setup client
+	client, err = NewClient(Config{Addr: addr, Retries: 4, Timeout: time.Second})
+	require.NoError(t, err)
+
+	// return
+	return client, func() {
+			gsrv.Stop()
+
+			listener, err := net.Listen("tcp", addr)
+			require.NoError(t, err)
+
+			serve(listener)
+		}, func() {
+			client.Close()
+			gsrv.Stop()
+			scheduler.Close()
+		}
+}
+
+func testProduceConsume(t *testing.T, client *Client, restart func()) {
+	ctx := context.Background()
+
+	index, err := client.Produce(ctx, "hello world")
+	require.NoError(t, err)
+
+	record, err := client.Consume(ctx, index)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", record.Value)
+
+	_, err = client.Consume(ctx, index+1)
+	require.Equal(t, contracts.ErrIndexOutOfRange{Index: index + 1}, err)
+
+	_, err = client.ProduceAt(ctx, index, "conflict")
+	require.Equal(t, contracts.ErrUnexpectedIndex{ExpectedIndex: index, NextIndex: index + 1}, err)
+
+	indexes, err := client.ProduceAt(ctx, index+1, "next")
+	require.NoError(t, err)
+	require.Equal(t, []uint64{index + 1}, indexes)
+}
+
+func testProducer(t *testing.T, client *Client, restart func()) {
+	ctx := context.Background()
+
+	producer := client.NewProducer(ProducerConfig{BatchSize: 3, Linger: time.Hour})
+
+	var mu sync.Mutex
+	var indexes []uint64
+
+	for i := 0; i < 7; i++ {
+		err := producer.Produce(ctx, fmt.Sprintf("record %d", i), func(index uint64, err error) {
+			require.NoError(t, err)
+			mu.Lock()
+			indexes = append(indexes, index)
+			mu.Unlock()
+		})
+		require.NoError(t, err)
+	}
+
+	index, err := producer.ProduceSync(ctx, "record 7")
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), index)
+
+	require.NoError(t, producer.Close())
+	require.Equal(t, []uint64{0, 1, 2, 3, 4, 5, 6}, indexes)
+
+	require.Equal(t, ErrProducerClosed, producer.Produce(ctx, "too late", nil))
+
+	_, err = client.Produce(ctx, "")
+	_, ok := err.(contracts.ErrInvalidRecord)
+	require.True(t, ok)
+}
+
+func testProducerRetries(t *testing.T, _ *Client, _ func()) {
+	client, _, teardown := setupTest(t, func(cfg *server.Config) {
+		cfg.RateLimits = &server.RateLimits{
+			Default: server.RateLimitPolicy{Produce: server.RateLimit{RecordsPerSecond: 10}},
+		}
+	})
+	defer teardown()
+
+	ctx := context.Background()
+
+	strict := client.NewProducer(ProducerConfig{BatchSize: 10, MaxRetries: -1})
+	defer strict.Close()
+
+	_, err := strict.ProduceSync(ctx, "first")
+	require.NoError(t, err)
+	_, err = strict.ProduceSync(ctx, "second")
+	require.NoError(t, err)
+
+	var overloaded contracts.ErrOverloaded
+	for i := 0; i < 20 && err == nil; i++ {
+		_, err = strict.ProduceSync(ctx, "flood")
+	}
+	require.ErrorAs(t, err, &overloaded)
+
+	patient := client.NewProducer(ProducerConfig{MaxRetries: 5, InitialBackoff: 10 * time.Millisecond})
+	defer patient.Close()
+
+	_, err = patient.ProduceSync(ctx, "eventually")
+	require.NoError(t, err)
+}
+
+func testIdempotentProducer(t *testing.T, client *Client, restart func()) {
+	ctx := context.Background()
+
+	// lose the response to the first append, after the server has done it
+	var lost int32
+	lossy, err := NewClient(Config{Addr: client.Conn().Target()}, grpc.WithUnaryInterceptor(
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			if err == nil && strings.HasSuffix(method, "/ProduceBatch") && atomic.AddInt32(&lost, 1) == 1 {
+				return status.Error(codes.Unavailable, "connection reset")
+			}
+			return err
+		},
+	))
+	require.NoError(t, err)
+	defer lossy.Close()
+
+	producer := lossy.NewProducer(ProducerConfig{Idempotent: true, MaxRetries: 3, InitialBackoff: time.Millisecond})
+	defer producer.Close()
+
+	index, err := producer.ProduceSync(ctx, "once")
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), index)
+
+	index, err = producer.ProduceSync(ctx, "twice")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), index)
+	require.Equal(t, int32(3), atomic.LoadInt32(&lost))
+
+	offsets, err := client.Offsets(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), offsets.NextIndex)
+}
+
+func testIdempotentProducerFenced(t *testing.T, client *Client, restart func()) {
+	ctx := context.Background()
+
+	older := client.NewProducer(ProducerConfig{Idempotent: true, Name: "orders"})
+	defer older.Close()
+
+	_, err := older.ProduceSync(ctx, "from older")
+	require.NoError(t, err)
+
+	newer := client.NewProducer(ProducerConfig{Idempotent: true, Name: "orders"})
+	defer newer.Close()
+
+	_, err = newer.ProduceSync(ctx, "from newer")
+	require.NoError(t, err)
+
+	_, err = older.ProduceSync(ctx, "from older again")
+	require.ErrorAs(t, err, &contracts.ErrProducerFenced{})
+}
+
+func testConsumerResumes(t *testing.T, client *Client, restart func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := client.ProduceBatch(ctx, "first", "second")
+	require.NoError(t, err)
+
+	consumer := client.NewConsumer(ConsumerConfig{InitialBackoff: 10 * time.Millisecond})
+
+	records := make(chan *contracts.Record)
+	go consumer.Consume(ctx, func(record *contracts.Record) error {
+		records <- record
+		return nil
+	})
+
+	require.Equal(t, "first", (<-records).Value)
+	require.Equal(t, "second", (<-records).Value)
+
+	restart()
+
+	_, err = client.Produce(ctx, "third")
+	require.NoError(t, err)
+
+	third := <-records
+	require.Equal(t, "third", third.Value)
+	require.Equal(t, uint64(2), third.Index)
+	require.Eventually(t, func() bool {
+		return consumer.Position() == 3
+	}, time.Second, 10*time.Millisecond)
+}
+
+func testTransactions(t *testing.T, client *Client, restart func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	consumer := client.NewConsumer(ConsumerConfig{ReadCommitted: true})
+
+	records := make(chan *contracts.Record, 8)
+	go consumer.Consume(ctx, func(record *contracts.Record) error {
+		records <- record
+		return nil
+	})
+
+	aborted, err := client.BeginTxn(ctx)
+	require.NoError(t, err)
+	_, err = aborted.Add(ctx, "never")
+	require.NoError(t, err)
+	require.NoError(t, aborted.Abort(ctx))
+
+	txn, err := client.BeginTxn(ctx)
+	require.NoError(t, err)
+	_, err = txn.Add(ctx, "outbox 1")
+	require.NoError(t, err)
+	_, err = txn.Add(ctx, "outbox 2")
+	require.NoError(t, err)
+
+	select {
+	case record := <-records:
+		t.Fatalf("unexpected record before commit: %q", record.Value)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, txn.Commit(ctx))
+	require.Equal(t, contracts.ErrTxnNotOpen{TxnID: txn.ID()}, txn.Commit(ctx))
+
+	require.Equal(t, "outbox 1", (<-records).Value)
+	require.Equal(t, "outbox 2", (<-records).Value)
+}
+
+func testFilteredConsumer(t *testing.T, client *Client, restart func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := client.ProduceBatch(ctx, "keep", "drop", "drop")
+	require.NoError(t, err)
+
+	consumer := client.NewConsumer(ConsumerConfig{
+		Filter: &contracts.Filter{Expression: &contracts.Filter_ValuePrefix{ValuePrefix: "keep"}},
+	})
+
+	records := make(chan *contracts.Record, 8)
+	go consumer.Consume(ctx, func(record *contracts.Record) error {
+		records <- record
+		return nil
+	})
+
+	require.Equal(t, uint64(0), (<-records).Index)
+
+	// skipped records move the position along without reaching the handler
+	require.Eventually(t, func() bool {
+		return consumer.Position() == 3
+	}, time.Second, 10*time.Millisecond)
+}
+
+func testCompression(t *testing.T, client *Client, restart func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	compressing, err := NewClient(Config{
+		Addr:            client.Conn().Target(),
+		Compression:     contracts.Compression_COMPRESSION_SNAPPY,
+		GRPCCompression: "gzip",
+	})
+	require.NoError(t, err)
+	defer compressing.Close()
+
+	value := strings.Repeat("order created, ", 50)
+
+	// batches are sent compressed as one unit
+	req, err := newBatch(newRecords([]string{value, value}), contracts.Compression_COMPRESSION_SNAPPY)
+	require.NoError(t, err)
+	require.Empty(t, req.Records)
+	require.Less(t, len(req.CompressedRecords), len(value))
+
+	_, err = compressing.ProduceBatch(ctx, value, value)
+	require.NoError(t, err)
+
+	// the consumer gets records as they're stored, and decompresses them
+	res, err := client.endpoints.Consume(ctx, &contracts.ConsumeRequest{Index: 0, AcceptCompression: compression.Codecs})
+	require.NoError(t, err)
+	require.Equal(t, contracts.Compression_COMPRESSION_SNAPPY, res.Record.GetCompression())
+
+	record, err := client.Consume(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, value, record.Value)
+
+	records := make(chan *contracts.Record, 8)
+	go client.NewConsumer(ConsumerConfig{}).Consume(ctx, func(record *contracts.Record) error {
+		records <- record
+		return nil
+	})
+
+	for i := 0; i < 2; i++ {
+		record := <-records
+		require.Equal(t, value, record.Value)
+		require.Nil(t, record.Compression)
+	}
+
+	// byte credit is given back as the records were sent, compressed
+	granting := &grantingEndpoints{EndpointsClient: client.endpoints, grants: make(chan uint64, 8)}
+	go newConsumer(granting, ConsumerConfig{Credits: 2, CreditBytes: 2}).Consume(ctx, func(*contracts.Record) error {
+		return nil
+	})
+
+	for i := 0; i < 2; i++ {
+		require.Equal(t, uint64(len(res.Record.CompressedValue)), <-granting.grants)
+	}
+}
+
+// grantingEndpoints reports the byte credit subscribers grant after their
+// first request.
+type grantingEndpoints struct {
+	contracts.EndpointsClient
+	grants chan uint64
+}
+
+func (g *grantingEndpoints) Subscribe(ctx context.Context, opts ...grpc.CallOption) (contracts.Endpoints_SubscribeClient, error) {
+	stream, err := g.EndpointsClient.Subscribe(ctx, opts...)
+	return &grantingStream{Endpoints_SubscribeClient: stream, grants: g.grants}, err
+}
+
+type grantingStream struct {
+	contracts.Endpoints_SubscribeClient
+	grants chan uint64
+}
+
+func (g *grantingStream) Send(req *contracts.SubscribeRequest) error {
+	if req.Consume == nil {
+		g.grants <- req.CreditBytes
+	}
+
+	return g.Endpoints_SubscribeClient.Send(req)
+}
+
+func testSchemaProducer(t *testing.T, client *Client, restart func()) {
+	ctx := context.Background()
+
+	res, err := client.endpoints.RegisterSchema(ctx, &contracts.RegisterSchemaRequest{
+		Subject:    "orders",
+		Type:       contracts.SchemaType_SCHEMA_TYPE_AVRO,
+		Definition: `{"type":"record","name":"Order","fields":[{"name":"id","type":"string"}]}`,
+	})
+	require.NoError(t, err)
+
+	producer := client.NewProducer(ProducerConfig{SchemaID: res.Schema.Id})
+	defer producer.Close()
+
+	index, err := producer.ProduceSync(ctx, `{"id":"o-1"}`)
+	require.NoError(t, err)
+
+	_, err = producer.ProduceSync(ctx, `{"id":1}`)
+	_, ok := err.(contracts.ErrInvalidRecord)
+	require.True(t, ok)
+
+	record, err := client.Consume(ctx, index)
+	require.NoError(t, err)
+	require.Equal(t, res.Schema.Id, record.GetSchemaId())
+}
+
+func testDeadLetters(t *testing.T, client *Client, restart func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := client.ProduceBatch(ctx, "poison", "healthy")
+	require.NoError(t, err)
+
+	consumer := client.NewConsumer(ConsumerConfig{Name: "billing"})
+
+	var handled []string
+	handler := func(record *contracts.Record) error {
+		if record.Value == "poison" {
+			return errors.New("unexpected token")
+		}
+		handled = append(handled, record.Value)
+		cancel()
+		return nil
+	}
+
+	// the consumer crashes on the record until it's dead-lettered
+	for failures := uint32(1); failures <= 3; failures++ {
+		err = consumer.Consume(ctx, handler)
+		require.EqualError(t, err, "unexpected token")
+
+		res, err := client.ReportFailure(context.Background(), "billing", consumer.Position(), err.Error())
+		require.NoError(t, err)
+		require.Equal(t, failures, res.Failures)
+		require.Equal(t, failures == 3, res.DeadLetter != nil)
+	}
+
+	require.ErrorIs(t, consumer.Consume(ctx, handler), context.Canceled)
+	require.Equal(t, []string{"healthy"}, handled)
+}
+
+func testSchedule(t *testing.T, client *Client, restart func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dropped, err := client.Schedule(ctx, "dropped", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, client.CancelSchedule(ctx, dropped))
+
+	delivered, err := client.Schedule(ctx, "retry order o-1", time.Now().Add(50*time.Millisecond))
+	require.NoError(t, err)
+
+	consumer := client.NewConsumer(ConsumerConfig{})
+
+	records := make(chan *contracts.Record, 8)
+	go consumer.Consume(ctx, func(record *contracts.Record) error {
+		records <- record
+		return nil
+	})
+
+	record := <-records
+	require.Equal(t, "retry order o-1", record.Value)
+	require.Equal(t, contracts.ErrAlreadyDelivered{ScheduleID: delivered, Index: record.Index}, client.CancelSchedule(ctx, delivered))
+}
+
+func testConsumerSkipsTruncated(t *testing.T, _ *Client, _ func()) {
+	client, _, teardown := setupTest(t, func(cfg *server.Config) {
+		cfg.CommitLog.(*truncatedLog).firstIndex = 2
+	})
+	defer teardown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := client.ProduceBatch(ctx, "first", "second", "third")
+	require.NoError(t, err)
+
+	strict := client.NewConsumer(ConsumerConfig{})
+	err = strict.Consume(ctx, func(record *contracts.Record) error { return nil })
+	require.Equal(t, contracts.ErrTruncated{Index: 0, FirstIndex: 2}, err)
+
+	skipping := client.NewConsumer(ConsumerConfig{SkipTruncated: true})
+	errStop := fmt.Errorf("stop")
+	err = skipping.Consume(ctx, func(record *contracts.Record) error {
+		require.Equal(t, "third", record.Value)
+		return errStop
+	})
+	require.Equal(t, errStop, err)
+	require.Equal(t, uint64(2), skipping.Position())
+}
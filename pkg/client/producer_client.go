@@ -0,0 +1,312 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrProducerClosed = errors.New("producer is closed")
+
+// Callback is called once per record with the index the record was
+// appended at, or the error that kept it from being appended. Callbacks run
+// on the producer's goroutine, in the order records were produced.
+type Callback func(index uint64, err error)
+
+type pending struct {
+	record   *contracts.Record
+	callback Callback
+	flushed  chan struct{}
+}
+
+// Producer batches records in the background and appends each batch with
+// a single ProduceBatch call. Batches are sent one at a time, so records
+// are appended in the order they were produced.
+type Producer struct {
+	endpoints contracts.EndpointsClient
+	timeout   time.Duration
+	config    ProducerConfig
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan *pending
+	done   chan struct{}
+
+	// owned by the run goroutine
+	producerID uint64
+	epoch      uint32
+	sequence   uint64
+	fenced     error
+}
+
+func newProducer(endpoints contracts.EndpointsClient, timeout time.Duration, config ProducerConfig) *Producer {
+	config.setDefaults()
+
+	p := &Producer{
+		endpoints: endpoints,
+		timeout:   timeout,
+		config:    config,
+		queue:     make(chan *pending, config.BufferSize),
+		done:      make(chan struct{}),
+	}
+
+	go p.run()
+
+	return p
+}
+
+// Produce queues a value to be appended, blocking while the buffer is full.
+// The callback may be nil.
+func (p *Producer) Produce(ctx context.Context, value string, callback Callback) error {
+	record := &contracts.Record{Value: value}
+	if p.config.SchemaID != 0 {
+		record.SchemaId = proto.Uint32(p.config.SchemaID)
+	}
+
+	return p.enqueue(ctx, &pending{record: record, callback: callback})
+}
+
+// ProduceSync appends a value and waits for its index.
+func (p *Producer) ProduceSync(ctx context.Context, value string) (uint64, error) {
+	type result struct {
+		index uint64
+		err   error
+	}
+
+	results := make(chan result, 1)
+
+	err := p.Produce(ctx, value, func(index uint64, err error) {
+		results <- result{index, err}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = p.Flush(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	r := <-results
+
+	return r.index, r.err
+}
+
+// Flush sends everything produced so far and waits for it to be
+// acknowledged.
+func (p *Producer) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+
+	err := p.enqueue(ctx, &pending{flushed: flushed})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close flushes outstanding records and stops the producer.
+func (p *Producer) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	<-p.done
+
+	return nil
+}
+
+func (p *Producer) enqueue(ctx context.Context, pd *pending) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrProducerClosed
+	}
+
+	select {
+	case p.queue <- pd:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Producer) run() {
+	defer close(p.done)
+
+	var batch []*pending
+	size := 0
+
+	linger := time.NewTimer(p.config.Linger)
+	linger.Stop()
+
+	send := func() {
+		linger.Stop()
+		p.send(batch)
+		batch = nil
+		size = 0
+	}
+
+	for {
+		select {
+		case pd, ok := <-p.queue:
+			if !ok {
+				send()
+				return
+			}
+
+			if pd.flushed != nil {
+				send()
+				close(pd.flushed)
+				continue
+			}
+
+			if len(batch) == 0 {
+				linger.Reset(p.config.Linger)
+			}
+
+			batch = append(batch, pd)
+			size += len(pd.record.Value)
+
+			if len(batch) >= p.config.BatchSize || size >= p.config.BatchBytes {
+				send()
+			}
+		case <-linger.C:
+			send()
+		}
+	}
+}
+
+func (p *Producer) send(batch []*pending) {
+	if len(batch) == 0 {
+		return
+	}
+
+	records := make([]*contracts.Record, len(batch))
+	for i, pd := range batch {
+		records[i] = pd.record
+	}
+
+	indexes, err := p.sendWithRetries(records)
+
+	for i, pd := range batch {
+		if pd.callback == nil {
+			continue
+		}
+
+		if err != nil {
+			pd.callback(0, err)
+		} else {
+			pd.callback(indexes[i], nil)
+		}
+	}
+}
+
+func (p *Producer) sendWithRetries(records []*contracts.Record) ([]uint64, error) {
+	if p.fenced != nil {
+		return nil, p.fenced
+	}
+
+	req, err := newBatch(records, p.config.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := p.config.InitialBackoff
+
+	for attempt := 0; ; attempt++ {
+		var res *contracts.ProduceBatchResponse
+
+		err := p.initProducer()
+		if err == nil {
+			req.ProducerId, req.ProducerEpoch, req.Sequence = p.producerID, p.epoch, p.sequence
+
+			ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+			res, err = p.endpoints.ProduceBatch(ctx, req)
+			cancel()
+		}
+
+		if err == nil {
+			p.sequence += uint64(len(records))
+			return res.Indexes, nil
+		}
+
+		err = contracts.FromError(err)
+
+		switch err.(type) {
+		case contracts.ErrProducerFenced:
+			// a newer producer with the same name has taken over for good
+			p.fenced = err
+			return nil, err
+		case contracts.ErrUnknownProducer, contracts.ErrOutOfOrderSequence:
+			// the server lost track of this producer, so start over as a new
+			// one; the batch is retried below but can't be deduplicated
+			p.producerID = 0
+		}
+
+		if attempt >= p.config.MaxRetries || !p.retryable(err) {
+			if p.config.Idempotent && ambiguous(err) {
+				// the batch may have been appended, so its sequence numbers
+				// can't be reused for the next batch
+				p.producerID = 0
+			}
+			return nil, err
+		}
+
+		_ = sleep(context.Background(), retryDelay(err, backoff))
+
+		backoff = nextBackoff(backoff, p.config.MaxBackoff)
+	}
+}
+
+func (p *Producer) retryable(err error) bool {
+	if retryable(err) {
+		return true
+	}
+
+	if !p.config.Idempotent {
+		return false
+	}
+
+	switch err.(type) {
+	case contracts.ErrUnknownProducer, contracts.ErrOutOfOrderSequence:
+		return true
+	}
+
+	return ambiguous(err)
+}
+
+// initProducer gets a producer id from the server the first time an
+// idempotent producer sends, and again whenever its sequence is lost.
+func (p *Producer) initProducer() error {
+	if !p.config.Idempotent || p.producerID != 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	res, err := p.endpoints.InitProducer(ctx, &contracts.InitProducerRequest{Name: p.config.Name})
+	if err != nil {
+		return err
+	}
+
+	p.producerID = res.ProducerId
+	p.epoch = res.ProducerEpoch
+	p.sequence = 0
+
+	return nil
+}
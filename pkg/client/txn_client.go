@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+)
+
+// Txn is an open transaction. Its records are appended as they're added,
+// but read_committed consumers only see them once it commits. The server
+// aborts transactions that go without calls for its transaction timeout.
+type Txn struct {
+	client *Client
+	id     uint64
+}
+
+func (c *Client) BeginTxn(ctx context.Context) (*Txn, error) {
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+
+	res, err := c.endpoints.BeginTxn(ctx, &contracts.BeginTxnRequest{})
+	if err != nil {
+		return nil, contracts.FromError(err)
+	}
+
+	return &Txn{client: c, id: res.TxnId}, nil
+}
+
+func (t *Txn) ID() uint64 {
+	return t.id
+}
+
+func (t *Txn) Add(ctx context.Context, values ...string) ([]uint64, error) {
+	ctx, cancel := t.client.requestContext(ctx)
+	defer cancel()
+
+	res, err := t.client.endpoints.AddRecords(ctx, &contracts.AddRecordsRequest{TxnId: t.id, Records: newRecords(values), Compression: t.client.compression})
+	if err != nil {
+		return nil, contracts.FromError(err)
+	}
+
+	return res.Indexes, nil
+}
+
+func (t *Txn) Commit(ctx context.Context) error {
+	ctx, cancel := t.client.requestContext(ctx)
+	defer cancel()
+
+	_, err := t.client.endpoints.CommitTxn(ctx, &contracts.CommitTxnRequest{TxnId: t.id})
+	if err != nil {
+		return contracts.FromError(err)
+	}
+
+	return nil
+}
+
+func (t *Txn) Abort(ctx context.Context) error {
+	ctx, cancel := t.client.requestContext(ctx)
+	defer cancel()
+
+	_, err := t.client.endpoints.AbortTxn(ctx, &contracts.AbortTxnRequest{TxnId: t.id})
+	if err != nil {
+		return contracts.FromError(err)
+	}
+
+	return nil
+}
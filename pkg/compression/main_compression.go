@@ -0,0 +1,192 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+)
+
+// Codecs are the codecs records can be compressed with.
+var Codecs = []contracts.Compression{
+	contracts.Compression_COMPRESSION_GZIP,
+	contracts.Compression_COMPRESSION_SNAPPY,
+	contracts.Compression_COMPRESSION_ZSTD,
+	contracts.Compression_COMPRESSION_LZ4,
+}
+
+// EncodeAll and DecodeAll are safe to call concurrently.
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// Parse returns the codec called name, as in "zstd" or "none".
+func Parse(name string) (contracts.Compression, error) {
+	value, ok := contracts.Compression_value["COMPRESSION_"+strings.ToUpper(name)]
+	if !ok || value == int32(contracts.Compression_COMPRESSION_UNSPECIFIED) {
+		return 0, fmt.Errorf("unknown compression %q", name)
+	}
+
+	return contracts.Compression(value), nil
+}
+
+func Compress(codec contracts.Compression, data []byte) ([]byte, error) {
+	switch codec {
+	case contracts.Compression_COMPRESSION_UNSPECIFIED, contracts.Compression_COMPRESSION_NONE:
+		return data, nil
+	case contracts.Compression_COMPRESSION_GZIP:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		return finish(&buf, w, data)
+	case contracts.Compression_COMPRESSION_SNAPPY:
+		return snappy.Encode(nil, data), nil
+	case contracts.Compression_COMPRESSION_ZSTD:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	case contracts.Compression_COMPRESSION_LZ4:
+		var buf bytes.Buffer
+		w := lz4.NewWriter(&buf)
+		return finish(&buf, w, data)
+	default:
+		return nil, fmt.Errorf("unknown compression %v", codec)
+	}
+}
+
+func finish(buf *bytes.Buffer, w io.WriteCloser, data []byte) ([]byte, error) {
+	_, err := w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func Decompress(codec contracts.Compression, data []byte) ([]byte, error) {
+	switch codec {
+	case contracts.Compression_COMPRESSION_UNSPECIFIED, contracts.Compression_COMPRESSION_NONE:
+		return data, nil
+	case contracts.Compression_COMPRESSION_GZIP:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	case contracts.Compression_COMPRESSION_SNAPPY:
+		return snappy.Decode(nil, data)
+	case contracts.Compression_COMPRESSION_ZSTD:
+		return zstdDecoder.DecodeAll(data, nil)
+	case contracts.Compression_COMPRESSION_LZ4:
+		return io.ReadAll(lz4.NewReader(bytes.NewReader(data)))
+	default:
+		return nil, fmt.Errorf("unknown compression %v", codec)
+	}
+}
+
+// ErrTooLarge is returned by DecompressLimit for data that decompresses to
+// more than its limit.
+var ErrTooLarge = errors.New("decompressed data is too large")
+
+// DecompressLimit is Decompress for data from callers that aren't trusted:
+// it stops with ErrTooLarge past limit bytes, rather than decompressing
+// whatever the data unpacks to.
+func DecompressLimit(codec contracts.Compression, data []byte, limit int) ([]byte, error) {
+	switch codec {
+	case contracts.Compression_COMPRESSION_UNSPECIFIED, contracts.Compression_COMPRESSION_NONE:
+		if len(data) > limit {
+			return nil, ErrTooLarge
+		}
+		return data, nil
+	case contracts.Compression_COMPRESSION_GZIP:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return readLimit(r, limit)
+	case contracts.Compression_COMPRESSION_SNAPPY:
+		n, err := snappy.DecodedLen(data)
+		if err != nil {
+			return nil, err
+		}
+		if n > limit {
+			return nil, ErrTooLarge
+		}
+		return snappy.Decode(nil, data)
+	case contracts.Compression_COMPRESSION_ZSTD:
+		r, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readLimit(r, limit)
+	case contracts.Compression_COMPRESSION_LZ4:
+		return readLimit(lz4.NewReader(bytes.NewReader(data)), limit)
+	default:
+		return nil, fmt.Errorf("unknown compression %v", codec)
+	}
+}
+
+func readLimit(r io.Reader, limit int) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > limit {
+		return nil, ErrTooLarge
+	}
+
+	return data, nil
+}
+
+// CompressRecord moves record's value into compressed_value, compressed
+// with codec, if that makes it smaller.
+func CompressRecord(record *contracts.Record, codec contracts.Compression) error {
+	if codec == contracts.Compression_COMPRESSION_UNSPECIFIED || codec == contracts.Compression_COMPRESSION_NONE {
+		return nil
+	}
+
+	compressed, err := Compress(codec, []byte(record.Value))
+	if err != nil {
+		return err
+	}
+
+	if len(compressed) >= len(record.Value) {
+		return nil
+	}
+
+	record.Value = ""
+	record.Compression = codec.Enum()
+	record.CompressedValue = compressed
+
+	return nil
+}
+
+// DecompressRecord moves a compressed record's value back into value.
+func DecompressRecord(record *contracts.Record) error {
+	if record.Compression == nil {
+		return nil
+	}
+
+	value, err := Decompress(*record.Compression, record.CompressedValue)
+	if err != nil {
+		return err
+	}
+
+	record.Value = string(value)
+	record.Compression = nil
+	record.CompressedValue = nil
+
+	return nil
+}
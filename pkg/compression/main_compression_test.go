@@ -0,0 +1,71 @@
+package compression
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+)
+
+func TestCompression(t *testing.T) {
+	value := strings.Repeat(`{"event":"order created","customer":"c-1","items":[]}`, 20)
+
+	for _, codec := range Codecs {
+		t.Run(codec.String(), func(t *testing.T) {
+			compressed, err := Compress(codec, []byte(value))
+			require.NoError(t, err)
+			require.Less(t, len(compressed), len(value)/5)
+
+			decompressed, err := Decompress(codec, compressed)
+			require.NoError(t, err)
+			require.Equal(t, value, string(decompressed))
+
+			decompressed, err = DecompressLimit(codec, compressed, len(value))
+			require.NoError(t, err)
+			require.Equal(t, value, string(decompressed))
+
+			_, err = DecompressLimit(codec, compressed, len(value)-1)
+			require.ErrorIs(t, err, ErrTooLarge)
+
+			record := &contracts.Record{Value: value}
+			require.NoError(t, CompressRecord(record, codec))
+			require.Empty(t, record.Value)
+			require.Equal(t, codec, record.GetCompression())
+
+			require.NoError(t, DecompressRecord(record))
+			require.Equal(t, &contracts.Record{Value: value}, record)
+
+			// values compression doesn't shrink are stored as they are
+			record = &contracts.Record{Value: "x"}
+			require.NoError(t, CompressRecord(record, codec))
+			require.Equal(t, &contracts.Record{Value: "x"}, record)
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		name  string
+		codec contracts.Compression
+		err   bool
+	}{
+		"none":        {name: "none", codec: contracts.Compression_COMPRESSION_NONE},
+		"zstd":        {name: "zstd", codec: contracts.Compression_COMPRESSION_ZSTD},
+		"upper case":  {name: "LZ4", codec: contracts.Compression_COMPRESSION_LZ4},
+		"unspecified": {name: "unspecified", err: true},
+		"unknown":     {name: "brotli", err: true},
+	}
+
+	for situation, test := range tests {
+		t.Run(situation, func(t *testing.T) {
+			codec, err := Parse(test.name)
+			if test.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.codec, codec)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var httpCodes = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusPreconditionFailed,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+}
+
+func errBadRequest(msg string) error {
+	return status.Error(codes.InvalidArgument, msg)
+}
+
+func httpCode(err error) int {
+	switch contracts.FromError(err).(type) {
+	case contracts.ErrIndexOutOfRange, contracts.ErrTruncated:
+		return http.StatusNotFound
+	case contracts.ErrUnexpectedIndex:
+		return http.StatusConflict
+	}
+
+	code, ok := httpCodes[status.Code(err)]
+	if !ok {
+		return http.StatusInternalServerError
+	}
+
+	return code
+}
+
+// writeError responds with the google.rpc.Status of err, details included,
+// so HTTP clients get the same information as gRPC clients.
+func writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+
+	if overloaded, ok := contracts.FromError(err).(contracts.ErrOverloaded); ok {
+		seconds := int(math.Ceil(overloaded.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+
+	data, marshalErr := marshaler.Marshal(st.Proto())
+	if marshalErr != nil {
+		http.Error(w, st.Message(), httpCode(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpCode(err))
+	_, _ = w.Write(data)
+}
+
+func writeStreamError(w http.ResponseWriter, sse bool, err error) {
+	data, marshalErr := marshaler.Marshal(status.Convert(err).Proto())
+	if marshalErr != nil {
+		return
+	}
+
+	if sse {
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+	} else {
+		fmt.Fprintf(w, "{\"error\":%s}\n", data)
+	}
+}
@@ -0,0 +1,294 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const maxBodyBytes = 32 * 1024 * 1024
+
+var (
+	marshaler   = protojson.MarshalOptions{EmitUnpopulated: true}
+	unmarshaler = protojson.UnmarshalOptions{DiscardUnknown: true}
+)
+
+type gateway struct {
+	client contracts.EndpointsClient
+}
+
+// NewHandler serves the Endpoints service as JSON over HTTP:
+//
+//	POST /v1/records            -> Produce
+//	POST /v1/records:batch      -> ProduceBatch
+//	GET  /v1/records/{index}    -> Consume
+//	GET  /v1/records?from={idx} -> ConsumeStream as NDJSON, or SSE when the
+//	                               client accepts text/event-stream
+//	GET  /v1/records:tail       -> ConsumeStream over a WebSocket
+//
+// Streams only deliver committed transactions with read_committed=true,
+// and only matching records with one of prefix, regex or cel.
+// Credentials are forwarded to the gRPC server, which authenticates HTTP
+// callers exactly like gRPC callers, along with the caller's address.
+func NewHandler(client contracts.EndpointsClient) http.Handler {
+	g := &gateway{client: client}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/records", g.records)
+	mux.HandleFunc("/v1/records:batch", g.produceBatch)
+	mux.HandleFunc("/v1/records:tail", g.tail)
+	mux.HandleFunc("/v1/records/", g.consume)
+
+	return mux
+}
+
+// outgoingContext forwards the caller's credentials and address as gRPC
+// metadata. Browsers can't set headers on WebSockets, so an access_token
+// query parameter is accepted too.
+func outgoingContext(r *http.Request) context.Context {
+	ctx := r.Context()
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-forwarded-for", host)
+	}
+
+	if authorization := r.Header.Get("Authorization"); len(authorization) > 0 {
+		return metadata.AppendToOutgoingContext(ctx, "authorization", authorization)
+	}
+
+	if token := r.URL.Query().Get("access_token"); len(token) > 0 {
+		return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
+
+	return ctx
+}
+
+// Credentials presents key on every call of the gateway's connection. A
+// server configured with the same key trusts the client addresses the
+// gateway forwards, so it can rate limit anonymous HTTP clients one by one
+// rather than as the gateway.
+func Credentials(key string) credentials.PerRPCCredentials {
+	return gatewayKey(key)
+}
+
+type gatewayKey string
+
+func (k gatewayKey) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"x-gateway-key": string(k)}, nil
+}
+
+func (k gatewayKey) RequireTransportSecurity() bool {
+	return false
+}
+
+// consumeRequest reads the stream options shared by the NDJSON, SSE and
+// WebSocket streams from the query.
+func consumeRequest(r *http.Request, index uint64) *contracts.ConsumeRequest {
+	query := r.URL.Query()
+
+	readCommitted, _ := strconv.ParseBool(query.Get("read_committed"))
+
+	req := &contracts.ConsumeRequest{Index: index, ReadCommitted: readCommitted}
+
+	switch {
+	case query.Has("prefix"):
+		req.Filter = &contracts.Filter{Expression: &contracts.Filter_ValuePrefix{ValuePrefix: query.Get("prefix")}}
+	case query.Has("regex"):
+		req.Filter = &contracts.Filter{Expression: &contracts.Filter_ValueRegex{ValueRegex: query.Get("regex")}}
+	case query.Has("cel"):
+		req.Filter = &contracts.Filter{Expression: &contracts.Filter_Cel{Cel: query.Get("cel")}}
+	}
+
+	return req
+}
+
+func (g *gateway) records(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		g.produce(w, r)
+	case http.MethodGet:
+		g.consumeStream(w, r)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (g *gateway) produce(w http.ResponseWriter, r *http.Request) {
+	req := &contracts.ProduceRequest{}
+
+	err := readBody(r, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res, err := g.client.Produce(outgoingContext(r), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeMessage(w, http.StatusOK, res)
+}
+
+func (g *gateway) produceBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	req := &contracts.ProduceBatchRequest{}
+
+	err := readBody(r, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res, err := g.client.ProduceBatch(outgoingContext(r), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeMessage(w, http.StatusOK, res)
+}
+
+func (g *gateway) consume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	index, err := parseIndex("index", strings.TrimPrefix(r.URL.Path, "/v1/records/"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	readCommitted, _ := strconv.ParseBool(r.URL.Query().Get("read_committed"))
+
+	res, err := g.client.Consume(outgoingContext(r), &contracts.ConsumeRequest{Index: index, ReadCommitted: readCommitted})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeMessage(w, http.StatusOK, res)
+}
+
+func (g *gateway) consumeStream(w http.ResponseWriter, r *http.Request) {
+	from := r.URL.Query().Get("from")
+	if len(from) == 0 {
+		from = "0"
+	}
+
+	index, err := parseIndex("from", from)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, fmt.Errorf("streaming is not supported by this connection"))
+		return
+	}
+
+	stream, err := g.client.ConsumeStream(outgoingContext(r), consumeRequest(r, index))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+
+	wroteHeader := false
+
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			if !wroteHeader {
+				writeError(w, err)
+			} else if r.Context().Err() == nil {
+				writeStreamError(w, sse, err)
+				flusher.Flush()
+			}
+			return
+		}
+
+		data, err := marshaler.Marshal(res)
+		if err != nil {
+			return
+		}
+
+		if sse {
+			_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", res.Record.GetIndex(), data)
+		} else {
+			_, err = fmt.Fprintf(w, "%s\n", data)
+		}
+		if err != nil {
+			return
+		}
+
+		wroteHeader = true
+		flusher.Flush()
+	}
+}
+
+func readBody(r *http.Request, m proto.Message) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes))
+	if err != nil {
+		return errBadRequest(err.Error())
+	}
+
+	err = unmarshaler.Unmarshal(body, m)
+	if err != nil {
+		return errBadRequest(err.Error())
+	}
+
+	return nil
+}
+
+func parseIndex(name, value string) (uint64, error) {
+	index, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, errBadRequest(fmt.Sprintf("%s must be a non-negative integer: %q", name, value))
+	}
+
+	return index, nil
+}
+
+func writeMessage(w http.ResponseWriter, code int, m proto.Message) {
+	data, err := marshaler.Marshal(m)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(data)
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,279 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"github.com/w-h-a/grpc-server/pkg/log"
+	"github.com/w-h-a/grpc-server/pkg/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestGateway(t *testing.T) {
+	tests := make(map[string]func(t *testing.T, url string))
+
+	tests["produce and consume"] = testProduceConsume
+	tests["consume beyond range"] = testConsumeBeyondRange
+	tests["produce invalid record"] = testProduceInvalid
+	tests["consume stream as ndjson"] = testConsumeStreamNDJSON
+	tests["consume stream as sse"] = testConsumeStreamSSE
+	tests["tail over websocket"] = testTail
+
+	for situation, fn := range tests {
+		t.Run(situation, func(t *testing.T) {
+			url, teardown := setupTest(t, nil)
+			defer teardown()
+
+			fn(t, url)
+		})
+	}
+}
+
+func setupTest(t *testing.T, fn func(*server.Config)) (url string, teardown func()) {
+	// setup log
+	log, err := log.NewLog()
+	require.NoError(t, err)
+
+	// setup server
+	cfg := &server.Config{CommitLog: log}
+	if fn != nil {
+		fn(cfg)
+	}
+
+	gsrv, err := server.NewGRPCServer(cfg)
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		gsrv.Serve(listener)
+	}()
+
+	// setup gateway
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+
+	httpServer := httptest.NewServer(NewHandler(contracts.NewEndpointsClient(conn)))
+
+	// return
+	return httpServer.URL, func() {
+		httpServer.Close()
+		conn.Close()
+		gsrv.Stop()
+		listener.Close()
+	}
+}
+
+func produce(t *testing.T, url, value string) {
+	res, err := http.Post(url+"/v1/records", "application/json", strings.NewReader(`{"record":{"value":"`+value+`"}}`))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func readAll(t *testing.T, res *http.Response) string {
+	var body strings.Builder
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		body.WriteString(scanner.Text())
+	}
+	return body.String()
+}
+
+func testProduceConsume(t *testing.T, url string) {
+	produce(t, url, "hello world")
+
+	res, err := http.Post(url+"/v1/records:batch", "application/json", strings.NewReader(`{"records":[{"value":"second"},{"value":"third"}]}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.JSONEq(t, `{"indexes":["1","2"],"duplicate":false}`, readAll(t, res))
+	res.Body.Close()
+
+	res, err = http.Get(url + "/v1/records/0")
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.JSONEq(t, `{"record":{"value":"hello world","index":"0"}}`, readAll(t, res))
+}
+
+func testConsumeBeyondRange(t *testing.T, url string) {
+	res, err := http.Get(url + "/v1/records/5")
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+	require.Contains(t, readAll(t, res), contracts.ReasonIndexOutOfRange)
+
+	res, err = http.Get(url + "/v1/records/minus-one")
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func testProduceInvalid(t *testing.T, url string) {
+	res, err := http.Post(url+"/v1/records", "application/json", strings.NewReader(`{"record":{"value":""}}`))
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	require.Contains(t, readAll(t, res), "record.value")
+}
+
+func testConsumeStreamNDJSON(t *testing.T, url string) {
+	produce(t, url, "first")
+	produce(t, url, "second")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/v1/records?from=1", nil)
+	require.NoError(t, err)
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	require.Equal(t, "application/x-ndjson", res.Header.Get("Content-Type"))
+
+	scanner := bufio.NewScanner(res.Body)
+	require.True(t, scanner.Scan())
+	require.JSONEq(t, `{"record":{"value":"second","index":"1"}}`, scanner.Text())
+}
+
+func testConsumeStreamSSE(t *testing.T, url string) {
+	produce(t, url, "first")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/v1/records", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	scanner := bufio.NewScanner(res.Body)
+	require.True(t, scanner.Scan())
+	require.Equal(t, "id: 0", scanner.Text())
+	require.True(t, scanner.Scan())
+	require.True(t, strings.HasPrefix(scanner.Text(), "data: "))
+	require.JSONEq(t, `{"record":{"value":"first","index":"0"}}`, strings.TrimPrefix(scanner.Text(), "data: "))
+}
+
+func testTail(t *testing.T, url string) {
+	produce(t, url, "before")
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http")+"/v1/records:tail?from=latest", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	produce(t, url, "after")
+
+	_, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.JSONEq(t, `{"record":{"value":"after","index":"1"}}`, string(data))
+}
+
+func TestAuthentication(t *testing.T) {
+	url, teardown := setupTest(t, func(cfg *server.Config) {
+		cfg.Tokens = map[string]string{"secret": "dashboard"}
+	})
+	defer teardown()
+
+	res, err := http.Post(url+"/v1/records", "application/json", strings.NewReader(`{"record":{"value":"hello"}}`))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	req, err := http.NewRequest(http.MethodPost, url+"/v1/records", strings.NewReader(`{"record":{"value":"hello"}}`))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	wsURL := "ws" + strings.TrimPrefix(url, "http") + "/v1/records:tail?from=0"
+
+	_, res, err = websocket.DefaultDialer.Dial(wsURL, nil)
+	require.Error(t, err)
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"&access_token=secret", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.JSONEq(t, `{"record":{"value":"hello","index":"0"}}`, string(data))
+}
+
+func TestRateLimitsPerClient(t *testing.T) {
+	log, err := log.NewLog()
+	require.NoError(t, err)
+
+	gsrv, err := server.NewGRPCServer(&server.Config{
+		CommitLog:  log,
+		RateLimits: &server.RateLimits{Default: server.RateLimitPolicy{Produce: server.RateLimit{RecordsPerSecond: 1}}},
+		GatewayKey: "key",
+	})
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		gsrv.Serve(listener)
+	}()
+	defer gsrv.Stop()
+
+	dial := func(opts ...grpc.DialOption) http.Handler {
+		conn, err := grpc.Dial(listener.Addr().String(), append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+
+		return NewHandler(contracts.NewEndpointsClient(conn))
+	}
+
+	produce := func(handler http.Handler, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/records", strings.NewReader(`{"record":{"value":"hello"}}`))
+		req.RemoteAddr = remoteAddr
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		return res.Code
+	}
+
+	// with the key, each client has its own limits
+	trusted := dial(grpc.WithPerRPCCredentials(Credentials("key")))
+
+	require.Equal(t, http.StatusOK, produce(trusted, "10.0.0.1:1234"))
+	require.Equal(t, http.StatusTooManyRequests, produce(trusted, "10.0.0.1:1234"))
+	require.Equal(t, http.StatusOK, produce(trusted, "10.0.0.2:1234"))
+
+	// without it, forwarded addresses are ignored and clients share the
+	// gateway's limits
+	untrusted := dial()
+
+	require.Equal(t, http.StatusOK, produce(untrusted, "10.0.0.3:1234"))
+	require.Equal(t, http.StatusTooManyRequests, produce(untrusted, "10.0.0.4:1234"))
+}
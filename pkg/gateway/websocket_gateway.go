@@ -0,0 +1,171 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+
+	// tailBuffer bounds how many records are read ahead of a slow browser.
+	// Once it fills up, reading stops and gRPC flow control pushes back on
+	// the server instead of records piling up in memory.
+	tailBuffer = 64
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// tail pushes records to a browser over a WebSocket, starting at the index
+// in the from query parameter, or at the end of the log when it's "latest".
+func (g *gateway) tail(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(outgoingContext(r))
+	defer cancel()
+
+	index, err := g.startIndex(ctx, r.URL.Query().Get("from"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	stream, err := g.client.ConsumeStream(ctx, consumeRequest(r, index))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	go readPongs(conn, cancel)
+
+	records := make(chan *contracts.ConsumeResponse, tailBuffer)
+	recvErr := make(chan error, 1)
+
+	go func() {
+		defer close(records)
+
+		for {
+			res, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+
+			select {
+			case records <- res:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case res, ok := <-records:
+			if !ok {
+				select {
+				case err := <-recvErr:
+					closeWithError(conn, err)
+				default:
+				}
+				return
+			}
+
+			data, err := marshaler.Marshal(res)
+			if err != nil {
+				return
+			}
+
+			_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+			err = conn.WriteMessage(websocket.TextMessage, data)
+			if err != nil {
+				return
+			}
+		case <-ticker.C:
+			_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+			err := conn.WriteMessage(websocket.PingMessage, nil)
+			if err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// startIndex resolves where the subscription starts. It always asks the
+// server for the log's offsets, so credentials are checked before the
+// connection is upgraded.
+func (g *gateway) startIndex(ctx context.Context, from string) (uint64, error) {
+	offsets, err := g.client.Offsets(ctx, &contracts.OffsetsRequest{})
+	if err != nil {
+		return 0, err
+	}
+
+	switch from {
+	case "":
+		return offsets.FirstIndex, nil
+	case "latest":
+		return offsets.NextIndex, nil
+	default:
+		return parseIndex("from", from)
+	}
+}
+
+// readPongs keeps the connection's read deadline ahead of the pings, and
+// cancels the subscription once the browser goes away.
+func readPongs(conn *websocket.Conn, cancel context.CancelFunc) {
+	defer cancel()
+
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, _, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func closeWithError(conn *websocket.Conn, err error) {
+	code := websocket.CloseInternalServerErr
+	if status.Code(err) == codes.Unavailable {
+		code = websocket.CloseTryAgainLater
+	}
+
+	// close reasons are limited to 123 bytes
+	reason := status.Convert(err).Message()
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+
+	_ = conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(writeWait),
+	)
+}
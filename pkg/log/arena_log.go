@@ -0,0 +1,100 @@
+package log
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// arena is a chunk's records serialized back to back, each prefixed with its
+// length, so the log holds no pointers for the GC to scan. ends[i] is the
+// offset record i ends at. data may be longer than what's in ends, since
+// writers append past it.
+//
+// With a data key, each record is sealed with aead, using its index as the
+// nonce.
+type arena struct {
+	data  []byte
+	ends  []uint64
+	first uint64
+	aead  cipher.AEAD
+}
+
+// appendRecord appends record to buf, sealed with aead unless it's nil.
+func appendRecord(buf []byte, record *contracts.Record, aead cipher.AEAD) ([]byte, error) {
+	size := proto.Size(record)
+
+	if aead == nil {
+		buf = binary.AppendUvarint(buf, uint64(size))
+		return proto.MarshalOptions{}.MarshalAppend(buf, record)
+	}
+
+	plaintext, err := proto.MarshalOptions{}.MarshalAppend(make([]byte, 0, size), record)
+	if err != nil {
+		return nil, err
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(size+aead.Overhead()))
+	return aead.Seal(buf, recordNonce(record.Index), plaintext, nil), nil
+}
+
+// scanArena finds where each record of data ends.
+func scanArena(data []byte) (*arena, error) {
+	a := &arena{data: data, ends: make([]uint64, 0, chunkSize)}
+
+	for offset := uint64(0); offset < uint64(len(data)); {
+		size, read := binary.Uvarint(data[offset:])
+		if read <= 0 || uint64(len(data))-offset-uint64(read) < size {
+			return nil, fmt.Errorf("record %d: %w", len(a.ends), io.ErrUnexpectedEOF)
+		}
+
+		offset += uint64(read) + size
+		a.ends = append(a.ends, offset)
+	}
+
+	return a, nil
+}
+
+func (a *arena) record(i uint64) (*contracts.Record, error) {
+	if i >= uint64(len(a.ends)) {
+		return nil, fmt.Errorf("record %d is missing", i)
+	}
+
+	start := uint64(0)
+	if i > 0 {
+		start = a.ends[i-1]
+	}
+
+	buf := a.data[start:a.ends[i]]
+
+	size, read := binary.Uvarint(buf)
+	if read <= 0 || uint64(len(buf)-read) != size {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	buf = buf[read:]
+
+	if a.aead != nil {
+		var err error
+
+		index := a.first + i
+
+		buf, err = a.aead.Open(nil, recordNonce(index), buf, nil)
+		if err != nil {
+			return nil, contracts.ErrDecryptionFailed{Index: index}
+		}
+	}
+
+	record := &contracts.Record{}
+
+	err := proto.Unmarshal(buf, record)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
@@ -0,0 +1,81 @@
+package log
+
+import (
+	"container/list"
+	"sync"
+)
+
+// chunkCache keeps the most recently read spilled chunks.
+type chunkCache struct {
+	size int
+
+	mu     sync.Mutex
+	items  map[uint64]*list.Element
+	order  *list.List
+	hits   uint64
+	misses uint64
+}
+
+type cachedChunk struct {
+	n     uint64
+	arena *arena
+}
+
+func newChunkCache(size int) *chunkCache {
+	return &chunkCache{
+		size:  size,
+		items: map[uint64]*list.Element{},
+		order: list.New(),
+	}
+}
+
+func (c *chunkCache) get(n uint64) (*arena, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[n]
+	if !ok {
+		c.misses++
+		c.recordRatio()
+		return nil, false
+	}
+
+	c.hits++
+	c.recordRatio()
+
+	c.order.MoveToFront(elem)
+
+	return elem.Value.(*cachedChunk).arena, true
+}
+
+func (c *chunkCache) put(n uint64, a *arena) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[n]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[n] = c.order.PushFront(&cachedChunk{n: n, arena: a})
+
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cachedChunk).n)
+	}
+}
+
+func (c *chunkCache) remove(n uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[n]; ok {
+		c.order.Remove(elem)
+		delete(c.items, n)
+	}
+}
+
+func (c *chunkCache) recordRatio() {
+	record(cacheHitRatio.M(float64(c.hits) / float64(c.hits+c.misses)))
+}
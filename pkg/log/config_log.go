@@ -0,0 +1,25 @@
+package log
+
+type Config struct {
+	// Dir is where records spilled out of memory are kept. A temporary
+	// directory is used when it's empty.
+	Dir string
+	// MaxMemoryBytes is roughly how much memory records may take before the
+	// oldest are spilled to Dir. Unlimited when 0.
+	MaxMemoryBytes uint64
+	// MaxRecords is how many of the newest records the log keeps. Older ones
+	// are dropped a chunk at a time, so up to a chunk more are kept.
+	// Unlimited when 0.
+	MaxRecords uint64
+	// CacheChunks is how many spilled chunks are kept in memory once read.
+	CacheChunks int
+	// Keys wraps the data keys records are encrypted with. Records are kept
+	// in plaintext when it's nil.
+	Keys KeyWrapper
+}
+
+func (c *Config) setDefaults() {
+	if c.CacheChunks <= 0 {
+		c.CacheChunks = 8
+	}
+}
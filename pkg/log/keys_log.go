@@ -0,0 +1,229 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// KeyWrapper wraps the data keys records are encrypted with in a
+// key-encryption key, the way a KMS does.
+type KeyWrapper interface {
+	// Wrap encrypts dataKey with the current key-encryption key, and returns
+	// that key's ID.
+	Wrap(dataKey []byte) (wrapped []byte, keyID string, err error)
+	// Unwrap decrypts a data key wrapped with the key-encryption key keyID.
+	Unwrap(keyID string, wrapped []byte) ([]byte, error)
+}
+
+var errUnknownKey = errors.New("unknown key-encryption key")
+
+// KeyFile wraps data keys with AES-256-GCM keys read from a file of
+// "<id> <base64 key>" lines. The last key wraps; the others are kept to
+// unwrap data keys wrapped before a rotation.
+type KeyFile struct {
+	path string
+
+	mu      sync.RWMutex
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+func LoadKeyFile(path string) (*KeyFile, error) {
+	k := &KeyFile{path: path}
+
+	err := k.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Reload reads the file again, which is how keys are rotated: append a new
+// key to the file, reload it, and rewrap the log's data keys.
+func (k *KeyFile) Reload() error {
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]cipher.AEAD{}
+	current := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: expected \"<id> <base64 key>\"", k.path, line)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("%s:%d: key must be 32 bytes of base64", k.path, line)
+		}
+
+		keys[fields[0]], err = newAEAD(key)
+		if err != nil {
+			return err
+		}
+		current = fields[0]
+	}
+
+	if len(current) == 0 {
+		return fmt.Errorf("%s: no keys", k.path)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keys = keys
+	k.current = current
+
+	return nil
+}
+
+// Current is the ID of the key data keys are wrapped with.
+func (k *KeyFile) Current() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.current
+}
+
+func (k *KeyFile) Wrap(dataKey []byte) ([]byte, string, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	aead := k.keys[k.current]
+
+	nonce := make([]byte, aead.NonceSize())
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return aead.Seal(nonce, nonce, dataKey, []byte(k.current)), k.current, nil
+}
+
+func (k *KeyFile) Unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	k.mu.RLock()
+	aead, ok := k.keys[keyID]
+	k.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownKey, keyID)
+	}
+
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key is too short")
+	}
+
+	nonce := wrapped[:aead.NonceSize()]
+
+	return aead.Open(nil, nonce, wrapped[aead.NonceSize():], []byte(keyID))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// newDataKey returns a fresh key for a chunk's records.
+func newDataKey() ([]byte, cipher.AEAD, error) {
+	key := make([]byte, 32)
+
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, aead, nil
+}
+
+// recordNonce is the nonce of the record at index. Every chunk has its own
+// data key, so a nonce is never used twice with a key.
+func recordNonce(index uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, index)
+	return nonce
+}
+
+// Sealed is a value kept outside the log, encrypted under a data key of its
+// own, which is wrapped like the log's.
+type Sealed struct {
+	KeyID      string `json:"key_id"`
+	WrappedKey []byte `json:"wrapped_key"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Seal encrypts plaintext with a new data key, wrapped with keys.
+func Seal(keys KeyWrapper, plaintext []byte) (*Sealed, error) {
+	dataKey, aead, err := newDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, keyID, err := keys.Wrap(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sealed{
+		KeyID:      keyID,
+		WrappedKey: wrapped,
+		// the data key seals nothing else, so a fixed nonce is never reused
+		Ciphertext: aead.Seal(nil, recordNonce(0), plaintext, nil),
+	}, nil
+}
+
+// Open decrypts a sealed value.
+func (s *Sealed) Open(keys KeyWrapper) ([]byte, error) {
+	dataKey, err := keys.Unwrap(s.KeyID, s.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Open(nil, recordNonce(0), s.Ciphertext, nil)
+}
+
+// Rewrap wraps the value's data key with the current key-encryption key,
+// without encrypting the value again.
+func (s *Sealed) Rewrap(keys KeyWrapper) error {
+	dataKey, err := keys.Unwrap(s.KeyID, s.WrappedKey)
+	if err != nil {
+		return err
+	}
+
+	s.WrappedKey, s.KeyID, err = keys.Wrap(dataKey)
+
+	return err
+}
@@ -1,38 +1,365 @@
 package log
 
 import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 
 	contracts "github.com/w-h-a/grpc-server/contracts/v1"
 )
 
+// chunkSize is how many records each chunk of the log holds.
+const chunkSize = 4096
+
+// segment is a chunk of the log. While it's in memory, its records are in
+// an arena: data, which writers append to past the log's next index and
+// replace when it grows, and ends. Once spilled, both are unset.
+//
+// With encryption, dataKey is the key the chunk's records are sealed with,
+// which is only ever written to disk wrapped.
+type segment struct {
+	data    atomic.Pointer[[]byte]
+	ends    *[chunkSize]uint64
+	dataKey []byte
+	aead    cipher.AEAD
+}
+
+func (l *Log) newSegment() (*segment, error) {
+	seg := &segment{ends: &[chunkSize]uint64{}}
+
+	if l.config.Keys == nil {
+		return seg, nil
+	}
+
+	var err error
+
+	seg.dataKey, seg.aead, err = newDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return seg, nil
+}
+
+func (s *segment) inMemory() bool {
+	return s.ends != nil
+}
+
+// arena returns the records of chunk n, which must be in memory.
+func (s *segment) arena(n uint64) *arena {
+	return &arena{data: *s.data.Load(), ends: s.ends[:], first: n * chunkSize, aead: s.aead}
+}
+
+// Log keeps records serialized in fixed-size chunks, decoding them on Read.
+// Writers serialize on mu, write past the published next index, and then
+// publish the new next index; readers never take mu, since what's below next
+// is never written again.
+//
+// With a memory budget, the oldest full chunks are spilled to disk once
+// records take more memory than that, and read back through a cache. With a
+// retention, the oldest chunks are dropped altogether once the log holds
+// more records than that; chunks below hot are spilled or dropped.
+//
+// With keys, records are encrypted with a data key per chunk, and spilled
+// chunks keep their data key wrapped in a key file next to them.
 type Log struct {
-	mu      sync.Mutex
-	records []*contracts.Record
+	config Config
+	dir    string
+	cache  *chunkCache
+
+	mu         sync.Mutex
+	appended   chan struct{}
+	memory     uint64
+	chunkBytes []uint64
+	hot        uint64
+
+	// chunks is replaced, never modified, when a chunk is added, spilled or
+	// dropped
+	chunks atomic.Pointer[[]*segment]
+	first  atomic.Uint64
+	next   atomic.Uint64
 }
 
+// NewLog returns a log that keeps every record in memory.
 func NewLog() (*Log, error) {
-	return &Log{}, nil
+	return OpenLog(Config{})
+}
+
+// OpenLog returns a log that spills records to config.Dir past its memory
+// budget. Chunks spilled by an earlier log in the same directory are removed.
+func OpenLog(config Config) (*Log, error) {
+	config.setDefaults()
+
+	l := &Log{
+		config:   config,
+		cache:    newChunkCache(config.CacheChunks),
+		appended: make(chan struct{}),
+	}
+	l.chunks.Store(&[]*segment{})
+
+	if config.MaxMemoryBytes == 0 {
+		return l, nil
+	}
+
+	var err error
+
+	l.dir = config.Dir
+	if len(l.dir) == 0 {
+		l.dir, err = os.MkdirTemp("", "log-")
+	} else {
+		err = os.MkdirAll(l.dir, 0o700)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = removeAll(l.dir, "*.chunk*", "*.key*")
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Close removes the chunks the log spilled.
+func (l *Log) Close() error {
+	if len(l.dir) == 0 {
+		return nil
+	}
+
+	if len(l.config.Dir) == 0 {
+		return os.RemoveAll(l.dir)
+	}
+
+	return removeAll(l.dir, "*.chunk", "*.key")
+}
+
+func removeAll(dir string, patterns ...string) error {
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return err
+		}
+
+		for _, path := range paths {
+			err = os.Remove(path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
 }
 
 func (l *Log) Append(record *contracts.Record) (uint64, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	record.Index = uint64(len(l.records))
+	indexes, err := l.appendBatch([]*contracts.Record{record})
+	if err != nil {
+		return 0, err
+	}
+
+	return indexes[0], nil
+}
 
-	l.records = append(l.records, record)
+func (l *Log) AppendBatch(records []*contracts.Record) ([]uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	return record.Index, nil
+	return l.appendBatch(records)
 }
 
-func (l *Log) Read(index uint64) (*contracts.Record, error) {
+// CompareAndAppend appends records only if the log's next index is
+// expectedIndex. The check and the append happen under the same lock.
+func (l *Log) CompareAndAppend(expectedIndex uint64, records []*contracts.Record) ([]uint64, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	if index >= uint64(len(l.records)) {
+	if next := l.next.Load(); next != expectedIndex {
+		return nil, contracts.ErrUnexpectedIndex{ExpectedIndex: expectedIndex, NextIndex: next}
+	}
+
+	return l.appendBatch(records)
+}
+
+// appendBatch drops and spills chunks before appending, and only publishes
+// the new next index once every record is encoded, so records are either
+// all appended or none are.
+func (l *Log) appendBatch(records []*contracts.Record) ([]uint64, error) {
+	err := l.truncateOverRetention()
+	if err != nil {
+		return nil, err
+	}
+
+	err = l.spillOverBudget()
+	if err != nil {
+		return nil, err
+	}
+
+	first := l.next.Load()
+	next := first
+	chunks := *l.chunks.Load()
+
+	indexes := make([]uint64, 0, len(records))
+
+	for _, record := range records {
+		if next/chunkSize == uint64(len(chunks)) {
+			seg, err := l.newSegment()
+			if err != nil {
+				return nil, err
+			}
+
+			chunks = append(chunks[:len(chunks):len(chunks)], seg)
+			l.chunks.Store(&chunks)
+			l.chunkBytes = append(l.chunkBytes, 0)
+		}
+
+		seg, slot := chunks[next/chunkSize], next%chunkSize
+
+		var data []byte
+		if loaded := seg.data.Load(); loaded != nil {
+			data = *loaded
+		}
+
+		used := uint64(0)
+		if slot > 0 {
+			used = seg.ends[slot-1]
+		}
+
+		record.Index = next
+
+		grown, err := appendRecord(data[:used], record, seg.aead)
+		if err != nil {
+			return nil, err
+		}
+
+		seg.ends[slot] = uint64(len(grown))
+
+		if cap(grown) != cap(data) {
+			grown = grown[:cap(grown)]
+			seg.data.Store(&grown)
+		}
+
+		indexes = append(indexes, next)
+		next++
+	}
+
+	for n := first / chunkSize; n*chunkSize < next; n++ {
+		last := next - 1
+		if end := (n+1)*chunkSize - 1; end < last {
+			last = end
+		}
+
+		size := uint64(len(chunks[n].ends))*8 + chunks[n].ends[last%chunkSize]
+		l.memory += size - l.chunkBytes[n]
+		l.chunkBytes[n] = size
+	}
+
+	l.next.Store(next)
+
+	record(memoryBytes.M(int64(l.memory)))
+
+	l.notifyAppended()
+
+	return indexes, nil
+}
+
+// spillOverBudget spills the oldest full chunks while records take more
+// memory than the budget.
+func (l *Log) spillOverBudget() error {
+	if l.config.MaxMemoryBytes == 0 {
+		return nil
+	}
+
+	full := l.next.Load() / chunkSize
+
+	for l.memory > l.config.MaxMemoryBytes && l.hot < full {
+		err := l.spill(l.hot)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *Log) Read(index uint64) (*contracts.Record, error) {
+	if index >= l.next.Load() {
 		return nil, contracts.ErrIndexOutOfRange{Index: index}
 	}
 
-	return l.records[index], nil
+	if first := l.first.Load(); index < first {
+		return nil, contracts.ErrTruncated{Index: index, FirstIndex: first}
+	}
+
+	seg := (*l.chunks.Load())[index/chunkSize]
+
+	if seg.inMemory() {
+		return seg.arena(index / chunkSize).record(index % chunkSize)
+	}
+
+	a, err := l.readSpilled(index / chunkSize)
+	if first := l.first.Load(); err != nil && index < first {
+		// dropped since it was looked up
+		return nil, contracts.ErrTruncated{Index: index, FirstIndex: first}
+	}
+	if errors.Is(err, errUnwrap) {
+		return nil, contracts.ErrDecryptionFailed{Index: index}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := a.record(index % chunkSize)
+	if _, ok := err.(contracts.ErrDecryptionFailed); ok {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("chunk %d: %w", index/chunkSize, err)
+	}
+
+	return record, nil
+}
+
+func (l *Log) FirstIndex() uint64 {
+	return l.first.Load()
+}
+
+func (l *Log) NextIndex() uint64 {
+	return l.next.Load()
+}
+
+// closed is returned by Appended for records that are already there.
+var closed = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// Appended returns a channel that is closed once the record at index may
+// have been appended, so readers can wait instead of polling.
+func (l *Log) Appended(index uint64) <-chan struct{} {
+	if index < l.next.Load() {
+		return closed
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if index < l.next.Load() {
+		return closed
+	}
+
+	return l.appended
+}
+
+func (l *Log) notifyAppended() {
+	close(l.appended)
+	l.appended = make(chan struct{})
 }
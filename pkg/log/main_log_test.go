@@ -1,7 +1,15 @@
 package log
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -16,6 +24,10 @@ func TestLog(t *testing.T) {
 	tests := make(map[string]func(t *testing.T, log *Log))
 	tests["append and read"] = testAppendRead
 	tests["index out of range"] = testIndexOutOfRange
+	tests["append batch"] = testAppendBatch
+	tests["wait for append"] = testAppended
+	tests["compare and append"] = testCompareAndAppend
+	tests["read while appending"] = testReadWhileAppending
 
 	for situation, fn := range tests {
 		t.Run(situation, func(t *testing.T) {
@@ -45,3 +57,439 @@ func testIndexOutOfRange(t *testing.T, log *Log) {
 	require.Nil(t, record)
 	require.Error(t, err)
 }
+
+func testAppendBatch(t *testing.T, log *Log) {
+	_, err := log.Append(&contracts.Record{Value: "first"})
+	require.NoError(t, err)
+
+	indexes, err := log.AppendBatch([]*contracts.Record{{Value: "second"}, {Value: "third"}})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 2}, indexes)
+
+	record, err := log.Read(2)
+	require.NoError(t, err)
+	require.Equal(t, "third", record.Value)
+}
+
+func testAppended(t *testing.T, log *Log) {
+	appended := log.Appended(0)
+
+	select {
+	case <-appended:
+		t.Fatal("notified before append")
+	default:
+	}
+
+	_, err := log.Append(&contracts.Record{Value: "first"})
+	require.NoError(t, err)
+
+	<-appended
+	<-log.Appended(0)
+}
+
+func testCompareAndAppend(t *testing.T, log *Log) {
+	indexes, err := log.CompareAndAppend(0, []*contracts.Record{{Value: "first"}})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0}, indexes)
+
+	_, err = log.CompareAndAppend(0, []*contracts.Record{{Value: "stale"}})
+	require.Equal(t, contracts.ErrUnexpectedIndex{ExpectedIndex: 0, NextIndex: 1}, err)
+
+	// of many writers expecting the same index, exactly one wins
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	won := 0
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			_, err := log.CompareAndAppend(1, []*contracts.Record{{Value: fmt.Sprint(i)}, {Value: fmt.Sprint(i)}})
+			if err == nil {
+				mu.Lock()
+				won++
+				mu.Unlock()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	require.Equal(t, 1, won)
+	require.Equal(t, uint64(3), log.NextIndex())
+}
+
+func testReadWhileAppending(t *testing.T, log *Log) {
+	const records = 3 * chunkSize
+
+	// goroutines report what went wrong here, since only the test's own
+	// goroutine may stop it
+	errs := make(chan error, 5)
+	failed := make(chan struct{})
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < records; i += 3 {
+			_, err := log.AppendBatch([]*contracts.Record{{Value: fmt.Sprint(i)}, {Value: fmt.Sprint(i + 1)}, {Value: fmt.Sprint(i + 2)}})
+			if err != nil {
+				errs <- err
+				close(failed)
+				return
+			}
+		}
+	}()
+
+	// readers see every record below the next index, whole, while appends
+	// carry on past chunk boundaries
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for index := uint64(0); index < records; {
+				if index >= log.NextIndex() {
+					select {
+					case <-log.Appended(index):
+					case <-failed:
+						return
+					}
+					continue
+				}
+
+				record, err := log.Read(index)
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				if record.Index != index || record.Value != fmt.Sprint(index) {
+					errs <- fmt.Errorf("read %d as record %d with value %q", index, record.Index, record.Value)
+					return
+				}
+
+				index++
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	log, err := NewLog()
+	require.NoError(b, err)
+
+	for i := 0; i < chunkSize; i++ {
+		_, err = log.Append(&contracts.Record{Value: "hello world"})
+		require.NoError(b, err)
+	}
+
+	// appends carry on while reading
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				_, _ = log.Append(&contracts.Record{Value: "hello world"})
+			}
+		}
+	}()
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		index := uint64(0)
+		for pb.Next() {
+			_, err := log.Read(index % chunkSize)
+			if err != nil {
+				b.Fatal(err)
+			}
+			index++
+		}
+	})
+}
+
+func BenchmarkAppend(b *testing.B) {
+	log, err := NewLog()
+	require.NoError(b, err)
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, err := log.Append(&contracts.Record{Value: "hello world"})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestSpill(t *testing.T) {
+	dir := t.TempDir()
+
+	// a stale chunk from an earlier log is removed
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000000.chunk"), []byte("stale"), 0o600))
+
+	value := strings.Repeat("x", 100)
+	encoded, err := appendRecord(nil, &contracts.Record{Value: value, Index: 5 * chunkSize}, nil)
+	require.NoError(t, err)
+	budget := uint64(2 * chunkSize * (len(encoded) + 8))
+
+	log, err := OpenLog(Config{Dir: dir, MaxMemoryBytes: budget, CacheChunks: 1})
+	require.NoError(t, err)
+
+	for i := 0; i < 5*chunkSize; i++ {
+		_, err := log.Append(&contracts.Record{Value: value})
+		require.NoError(t, err)
+	}
+
+	// the oldest chunks are spilled, a chunk at a time, to stay in budget
+	require.LessOrEqual(t, log.memory, budget)
+	require.Equal(t, uint64(3), log.hot)
+
+	spilled, err := filepath.Glob(filepath.Join(dir, "*.chunk"))
+	require.NoError(t, err)
+	require.Len(t, spilled, 3)
+
+	for index := uint64(0); index < 5*chunkSize; index++ {
+		record, err := log.Read(index)
+		require.NoError(t, err)
+		require.Equal(t, index, record.Index)
+		require.Equal(t, value, record.Value)
+	}
+
+	// reads within a spilled chunk are served by the cache
+	require.Equal(t, uint64(3), log.cache.misses)
+	require.Equal(t, uint64(3*chunkSize-3), log.cache.hits)
+
+	require.NoError(t, log.Close())
+
+	spilled, err = filepath.Glob(filepath.Join(dir, "*.chunk"))
+	require.NoError(t, err)
+	require.Empty(t, spilled)
+}
+
+func TestRetention(t *testing.T) {
+	dir := t.TempDir()
+
+	value := strings.Repeat("x", 100)
+	encoded, err := appendRecord(nil, &contracts.Record{Value: value, Index: 6 * chunkSize}, nil)
+	require.NoError(t, err)
+	budget := uint64(2 * chunkSize * (len(encoded) + 8))
+
+	log, err := OpenLog(Config{Dir: dir, MaxMemoryBytes: budget, MaxRecords: 2 * chunkSize, CacheChunks: 1})
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := 0; i < 6*chunkSize; i++ {
+		_, err := log.Append(&contracts.Record{Value: value})
+		require.NoError(t, err)
+	}
+
+	// the oldest chunks are dropped, spilled or not, to keep the retention
+	first := uint64(3 * chunkSize)
+	require.Equal(t, first, log.FirstIndex())
+	require.Equal(t, uint64(1), log.spilled())
+
+	spilled, err := filepath.Glob(filepath.Join(dir, "*.chunk"))
+	require.NoError(t, err)
+	require.Equal(t, []string{log.chunkPath(3)}, spilled)
+
+	for index := uint64(0); index < first; index++ {
+		_, err := log.Read(index)
+		require.ErrorIs(t, err, contracts.ErrTruncated{Index: index, FirstIndex: first})
+	}
+
+	for index := first; index < 6*chunkSize; index++ {
+		record, err := log.Read(index)
+		require.NoError(t, err)
+		require.Equal(t, index, record.Index)
+	}
+
+	// without a budget, dropped chunks give their memory back
+	log, err = OpenLog(Config{MaxRecords: chunkSize})
+	require.NoError(t, err)
+
+	for i := 0; i < 3*chunkSize; i++ {
+		_, err := log.Append(&contracts.Record{Value: value})
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, uint64(chunkSize), log.FirstIndex())
+	require.Equal(t, log.chunkBytes[1]+log.chunkBytes[2], log.memory)
+}
+
+func TestEncryption(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(t.TempDir(), "keys")
+
+	k1, k2 := newKeyLine(t, "k1"), newKeyLine(t, "k2")
+
+	require.NoError(t, os.WriteFile(keyFile, []byte(k1), 0o600))
+	keys, err := LoadKeyFile(keyFile)
+	require.NoError(t, err)
+
+	value := "ssn=078-05-1120"
+
+	log, err := OpenLog(Config{Dir: dir, MaxMemoryBytes: 1, CacheChunks: 1, Keys: keys})
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := 0; i < 3*chunkSize; i++ {
+		_, err := log.Append(&contracts.Record{Value: value})
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, uint64(2), log.hot)
+
+	readAll := func() error {
+		for index := uint64(0); index < 3*chunkSize; index++ {
+			record, err := log.Read(index)
+			if err != nil {
+				return err
+			}
+			require.Equal(t, value, record.Value)
+		}
+		return nil
+	}
+
+	require.NoError(t, readAll())
+
+	// no plaintext in memory, nor on disk
+	chunks := *log.chunks.Load()
+	require.False(t, bytes.Contains(*chunks[2].data.Load(), []byte(value)))
+
+	spilled, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	require.Len(t, spilled, 4)
+
+	for _, path := range spilled {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.False(t, bytes.Contains(data, []byte(value)), path)
+	}
+
+	// rotating re-wraps the data keys, so the old key can then be dropped
+	require.NoError(t, os.WriteFile(keyFile, []byte(k1+k2), 0o600))
+	require.NoError(t, keys.Reload())
+	require.NoError(t, log.Rewrap())
+
+	wrapped, err := os.ReadFile(log.keyPath(0))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(wrapped), "k2 "))
+
+	require.NoError(t, os.WriteFile(keyFile, []byte(k2), 0o600))
+	require.NoError(t, keys.Reload())
+	require.NoError(t, readAll())
+
+	// a chunk whose key can't be unwrapped fails to decrypt
+	require.NoError(t, os.WriteFile(keyFile, []byte(newKeyLine(t, "k3")), 0o600))
+	require.NoError(t, keys.Reload())
+
+	_, err = log.Read(0)
+	require.Equal(t, contracts.ErrDecryptionFailed{Index: 0}, err)
+
+	require.NoError(t, os.WriteFile(keyFile, []byte(k2), 0o600))
+	require.NoError(t, keys.Reload())
+
+	// and so does a tampered record, while its neighbours still read
+	data, err := os.ReadFile(log.chunkPath(0))
+	require.NoError(t, err)
+	a, err := scanArena(data)
+	require.NoError(t, err)
+	data[a.ends[5]-1] ^= 1
+	require.NoError(t, os.WriteFile(log.chunkPath(0), data, 0o600))
+
+	_, err = log.Read(5)
+	require.Equal(t, contracts.ErrDecryptionFailed{Index: 5}, err)
+
+	_, err = log.Read(6)
+	require.NoError(t, err)
+}
+
+func TestSealed(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	k1, k2 := newKeyLine(t, "k1"), newKeyLine(t, "k2")
+
+	require.NoError(t, os.WriteFile(keyFile, []byte(k1), 0o600))
+	keys, err := LoadKeyFile(keyFile)
+	require.NoError(t, err)
+
+	value := []byte("ssn=078-05-1120")
+
+	sealed, err := Seal(keys, value)
+	require.NoError(t, err)
+	require.Equal(t, "k1", sealed.KeyID)
+	require.False(t, bytes.Contains(sealed.Ciphertext, value))
+
+	// once re-wrapped, the value opens without the old key
+	require.NoError(t, os.WriteFile(keyFile, []byte(k1+k2), 0o600))
+	require.NoError(t, keys.Reload())
+	require.NoError(t, sealed.Rewrap(keys))
+	require.Equal(t, "k2", sealed.KeyID)
+
+	require.NoError(t, os.WriteFile(keyFile, []byte(k2), 0o600))
+	require.NoError(t, keys.Reload())
+
+	opened, err := sealed.Open(keys)
+	require.NoError(t, err)
+	require.Equal(t, value, opened)
+
+	sealed.Ciphertext[0] ^= 1
+	_, err = sealed.Open(keys)
+	require.Error(t, err)
+}
+
+func newKeyLine(t *testing.T, id string) string {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	return id + " " + base64.StdEncoding.EncodeToString(key) + "\n"
+}
+
+// BenchmarkRetained measures GC cycles, and reports the heap, with 10M
+// records in the log.
+func BenchmarkRetained(b *testing.B) {
+	log, err := NewLog()
+	require.NoError(b, err)
+
+	records := make([]*contracts.Record, 1000)
+	for i := 0; i < 10_000_000; i += len(records) {
+		for j := range records {
+			records[j] = &contracts.Record{Value: "hello world"}
+		}
+		_, err = log.AppendBatch(records)
+		require.NoError(b, err)
+	}
+
+	runtime.GC()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+
+	b.StopTimer()
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	b.ReportMetric(float64(stats.HeapAlloc)/(1<<20), "heap-MB")
+	b.ReportMetric(float64(stats.PauseNs[(stats.NumGC+255)%256])/1e3, "pause-us")
+
+	runtime.KeepAlive(log)
+}
@@ -0,0 +1,40 @@
+package log
+
+import (
+	"context"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+)
+
+var (
+	memoryBytes   = stats.Int64("log/memory_bytes", "Bytes of records held in memory", stats.UnitBytes)
+	spilledChunks = stats.Int64("log/spilled_chunks", "Number of chunks spilled to disk", stats.UnitDimensionless)
+	cacheHitRatio = stats.Float64("log/cache_hit_ratio", "Share of reads of spilled chunks served from the cache", stats.UnitDimensionless)
+)
+
+// Views are the log's metrics, for the agent to register.
+var Views = []*view.View{
+	{
+		Name:        memoryBytes.Name(),
+		Description: memoryBytes.Description(),
+		Measure:     memoryBytes,
+		Aggregation: view.LastValue(),
+	},
+	{
+		Name:        spilledChunks.Name(),
+		Description: spilledChunks.Description(),
+		Measure:     spilledChunks,
+		Aggregation: view.LastValue(),
+	},
+	{
+		Name:        cacheHitRatio.Name(),
+		Description: cacheHitRatio.Description(),
+		Measure:     cacheHitRatio,
+		Aggregation: view.LastValue(),
+	},
+}
+
+func record(measurement stats.Measurement) {
+	stats.Record(context.Background(), measurement)
+}
@@ -0,0 +1,65 @@
+package log
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// truncateOverRetention drops the oldest chunks while the log would still
+// keep its retention without them.
+func (l *Log) truncateOverRetention() error {
+	if l.config.MaxRecords == 0 {
+		return nil
+	}
+
+	for l.next.Load()-l.first.Load() >= l.config.MaxRecords+chunkSize {
+		err := l.truncate(l.first.Load() / chunkSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// truncate drops chunk n, the oldest left, whether it's in memory or
+// spilled. The first index moves past it before anything is removed, so
+// readers that find it gone report it truncated; readers that already
+// loaded it keep their copy.
+func (l *Log) truncate(n uint64) error {
+	l.first.Store((n + 1) * chunkSize)
+
+	chunks := *l.chunks.Load()
+
+	truncated := make([]*segment, len(chunks))
+	copy(truncated, chunks)
+	truncated[n] = &segment{}
+	l.chunks.Store(&truncated)
+
+	if n < l.hot {
+		l.cache.remove(n)
+
+		for _, path := range []string{l.chunkPath(n), l.keyPath(n)} {
+			err := os.Remove(path)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	} else {
+		l.memory -= l.chunkBytes[n]
+		l.chunkBytes[n] = 0
+		l.hot = n + 1
+	}
+
+	record(memoryBytes.M(int64(l.memory)))
+	record(spilledChunks.M(int64(l.spilled())))
+
+	return nil
+}
+
+// spilled is how many chunks are on disk: those below hot that haven't
+// been truncated.
+func (l *Log) spilled() uint64 {
+	return l.hot - l.first.Load()/chunkSize
+}
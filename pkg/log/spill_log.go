@@ -0,0 +1,161 @@
+package log
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errUnwrap is returned when a spilled chunk's data key can't be unwrapped.
+var errUnwrap = errors.New("data key could not be unwrapped")
+
+func (l *Log) chunkPath(n uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d.chunk", n))
+}
+
+func (l *Log) keyPath(n uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d.key", n))
+}
+
+// spill writes chunk n's arena to disk, and then drops it from memory.
+// Readers that already loaded it keep their copy.
+func (l *Log) spill(n uint64) error {
+	chunks := *l.chunks.Load()
+	seg := chunks[n]
+
+	if seg.dataKey != nil {
+		err := l.writeKey(n, seg.dataKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := writeFile(l.chunkPath(n), (*seg.data.Load())[:seg.ends[chunkSize-1]])
+	if err != nil {
+		return err
+	}
+
+	spilled := make([]*segment, len(chunks))
+	copy(spilled, chunks)
+	spilled[n] = &segment{}
+	l.chunks.Store(&spilled)
+
+	l.memory -= l.chunkBytes[n]
+	l.chunkBytes[n] = 0
+	l.hot++
+
+	record(memoryBytes.M(int64(l.memory)))
+	record(spilledChunks.M(int64(l.spilled())))
+
+	return nil
+}
+
+// writeKey writes chunk n's data key, wrapped with the current
+// key-encryption key, as "<key id> <base64 wrapped key>".
+func (l *Log) writeKey(n uint64, dataKey []byte) error {
+	wrapped, keyID, err := l.config.Keys.Wrap(dataKey)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(l.keyPath(n), []byte(keyID+" "+base64.StdEncoding.EncodeToString(wrapped)+"\n"))
+}
+
+func (l *Log) readKey(n uint64) ([]byte, error) {
+	data, err := os.ReadFile(l.keyPath(n))
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, encoded, ok := strings.Cut(strings.TrimSpace(string(data)), " ")
+	if !ok {
+		return nil, fmt.Errorf("chunk %d: %w: malformed key file", n, errUnwrap)
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %d: %w: %v", n, errUnwrap, err)
+	}
+
+	dataKey, err := l.config.Keys.Unwrap(keyID, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %d: %w: %v", n, errUnwrap, err)
+	}
+
+	return dataKey, nil
+}
+
+// writeFile replaces path with data, so readers never see it half written.
+func writeFile(path string, data []byte) error {
+	err := os.WriteFile(path+".tmp", data, 0o600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// readSpilled reads chunk n back from disk, through the cache.
+func (l *Log) readSpilled(n uint64) (*arena, error) {
+	a, ok := l.cache.get(n)
+	if ok {
+		return a, nil
+	}
+
+	data, err := os.ReadFile(l.chunkPath(n))
+	if err != nil {
+		return nil, err
+	}
+
+	a, err = scanArena(data)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %d: %w", n, err)
+	}
+
+	a.first = n * chunkSize
+
+	if l.config.Keys != nil {
+		dataKey, err := l.readKey(n)
+		if err != nil {
+			return nil, err
+		}
+
+		a.aead, err = newAEAD(dataKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	l.cache.put(n, a)
+
+	return a, nil
+}
+
+// Rewrap wraps the data key of every spilled chunk again with the current
+// key-encryption key, without touching the records, so a rotated-out key
+// is no longer needed.
+func (l *Log) Rewrap() error {
+	if l.config.Keys == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for n := l.first.Load() / chunkSize; n < l.hot; n++ {
+		dataKey, err := l.readKey(n)
+		if err != nil {
+			return err
+		}
+
+		err = l.writeKey(n, dataKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
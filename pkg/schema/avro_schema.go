@@ -0,0 +1,201 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"unicode/utf8"
+
+	"github.com/hamba/avro/v2"
+)
+
+// avroSchema checks values in Avro's JSON encoding, where a union's value is
+// null or {"<branch>": value}.
+type avroSchema struct {
+	schema avro.Schema
+}
+
+func parseAvro(definition string) (Schema, error) {
+	// a cache per schema, so versions can redefine the same names
+	parsed, err := avro.ParseWithCache(definition, "", &avro.SchemaCache{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &avroSchema{schema: parsed}, nil
+}
+
+func (s *avroSchema) Validate(value string) error {
+	v, err := decodeJSON(value)
+	if err != nil {
+		return err
+	}
+
+	return avroConforms(s.schema, v, "value")
+}
+
+func (s *avroSchema) reads(writer Schema) error {
+	w, ok := writer.(*avroSchema)
+	if !ok {
+		return errTypeChanged
+	}
+
+	return avro.NewSchemaCompatibility().Compatible(s.schema, w.schema)
+}
+
+func avroConforms(schema avro.Schema, v interface{}, path string) error {
+	switch s := schema.(type) {
+	case *avro.RefSchema:
+		return avroConforms(s.Schema(), v, path)
+	case *avro.NullSchema:
+		if v != nil {
+			return fmt.Errorf("%s: expected null", path)
+		}
+	case *avro.PrimitiveSchema:
+		return avroPrimitive(s.Type(), v, path)
+	case *avro.FixedSchema:
+		str, ok := v.(string)
+		if !ok || utf8.RuneCountInString(str) != s.Size() {
+			return fmt.Errorf("%s: expected %d bytes", path, s.Size())
+		}
+	case *avro.EnumSchema:
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected a symbol of %s", path, s.FullName())
+		}
+		for _, symbol := range s.Symbols() {
+			if symbol == str {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: %q is not a symbol of %s", path, str, s.FullName())
+	case *avro.ArraySchema:
+		items, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an array", path)
+		}
+		for i, item := range items {
+			err := avroConforms(s.Items(), item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return err
+			}
+		}
+	case *avro.MapSchema:
+		values, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected a map", path)
+		}
+		for key, value := range values {
+			err := avroConforms(s.Values(), value, fmt.Sprintf("%s[%q]", path, key))
+			if err != nil {
+				return err
+			}
+		}
+	case *avro.RecordSchema:
+		return avroRecord(s, v, path)
+	case *avro.UnionSchema:
+		return avroUnion(s, v, path)
+	default:
+		return fmt.Errorf("%s: unsupported schema %s", path, schema.Type())
+	}
+
+	return nil
+}
+
+func avroPrimitive(typ avro.Type, v interface{}, path string) error {
+	ok := false
+
+	switch typ {
+	case avro.Boolean:
+		_, ok = v.(bool)
+	case avro.Int, avro.Long:
+		if n, isNumber := v.(json.Number); isNumber {
+			i, err := n.Int64()
+			ok = err == nil && (typ == avro.Long || (i >= math.MinInt32 && i <= math.MaxInt32))
+		}
+	case avro.Float, avro.Double:
+		if n, isNumber := v.(json.Number); isNumber {
+			_, err := n.Float64()
+			ok = err == nil
+		}
+	case avro.String, avro.Bytes:
+		_, ok = v.(string)
+	}
+
+	if !ok {
+		return fmt.Errorf("%s: expected %s", path, typ)
+	}
+
+	return nil
+}
+
+func avroRecord(s *avro.RecordSchema, v interface{}, path string) error {
+	fields, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s: expected a %s record", path, s.FullName())
+	}
+
+	known := make(map[string]bool, len(s.Fields()))
+
+	for _, field := range s.Fields() {
+		known[field.Name()] = true
+
+		value, ok := fields[field.Name()]
+		if !ok {
+			if field.HasDefault() {
+				continue
+			}
+			return fmt.Errorf("%s.%s: missing", path, field.Name())
+		}
+
+		err := avroConforms(field.Type(), value, path+"."+field.Name())
+		if err != nil {
+			return err
+		}
+	}
+
+	for name := range fields {
+		if !known[name] {
+			return fmt.Errorf("%s.%s: not a field of %s", path, name, s.FullName())
+		}
+	}
+
+	return nil
+}
+
+func avroUnion(s *avro.UnionSchema, v interface{}, path string) error {
+	if v == nil {
+		if s.Nullable() {
+			return nil
+		}
+		return fmt.Errorf("%s: null is not a branch of the union", path)
+	}
+
+	branch, ok := v.(map[string]interface{})
+	if !ok || len(branch) != 1 {
+		return fmt.Errorf("%s: expected null or {\"<branch>\": value}", path)
+	}
+
+	for name, value := range branch {
+		for _, typ := range s.Types() {
+			if avroName(typ) == name {
+				return avroConforms(typ, value, path+"."+name)
+			}
+		}
+		return fmt.Errorf("%s: %s is not a branch of the union", path, name)
+	}
+
+	return nil
+}
+
+func avroName(schema avro.Schema) string {
+	if ref, ok := schema.(*avro.RefSchema); ok {
+		schema = ref.Schema()
+	}
+
+	if named, ok := schema.(avro.NamedSchema); ok {
+		return named.FullName()
+	}
+
+	return string(schema.Type())
+}
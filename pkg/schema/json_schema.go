@@ -0,0 +1,161 @@
+package schema
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// jsonSchemaURL is where a definition is added to the compiler. Definitions
+// can't refer to any other document.
+const jsonSchemaURL = "mem:///schema.json"
+
+type jsonSchema struct {
+	schema *jsonschema.Schema
+}
+
+func parseJSON(definition string) (Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("%s: schemas can't refer to other documents", url)
+	}
+
+	err := compiler.AddResource(jsonSchemaURL, strings.NewReader(definition))
+	if err != nil {
+		return nil, err
+	}
+
+	compiled, err := compiler.Compile(jsonSchemaURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jsonSchema{schema: compiled}, nil
+}
+
+func (s *jsonSchema) Validate(value string) error {
+	v, err := decodeJSON(value)
+	if err != nil {
+		return err
+	}
+
+	return s.schema.Validate(v)
+}
+
+// reads checks types, enums, required properties, properties, closed
+// objects and items. Properties the reader has and the writer doesn't are
+// allowed, even though an open writer could have written anything there.
+func (s *jsonSchema) reads(writer Schema) error {
+	w, ok := writer.(*jsonSchema)
+	if !ok {
+		return errTypeChanged
+	}
+
+	return jsonReads(s.schema, w.schema, "#")
+}
+
+func jsonReads(reader, writer *jsonschema.Schema, path string) error {
+	reader, writer = jsonDeref(reader), jsonDeref(writer)
+
+	if reader == nil || (reader.Always != nil && *reader.Always) {
+		return nil
+	}
+
+	if writer == nil {
+		writer = &jsonschema.Schema{}
+	}
+
+	if reader.Always != nil {
+		return fmt.Errorf("%s: nothing is read", path)
+	}
+
+	if len(reader.Types) > 0 {
+		if len(writer.Types) == 0 {
+			return fmt.Errorf("%s: any type may be written, but only %s is read", path, strings.Join(reader.Types, ", "))
+		}
+		for _, typ := range writer.Types {
+			if !jsonTypeAllowed(reader.Types, typ) {
+				return fmt.Errorf("%s: %s may be written, but isn't read", path, typ)
+			}
+		}
+	}
+
+	if len(reader.Enum) > 0 {
+		if len(writer.Enum) == 0 {
+			return fmt.Errorf("%s: any value may be written, but only an enum is read", path)
+		}
+		allowed := map[string]bool{}
+		for _, value := range reader.Enum {
+			allowed[jsonString(value)] = true
+		}
+		for _, value := range writer.Enum {
+			if !allowed[jsonString(value)] {
+				return fmt.Errorf("%s: %s may be written, but isn't in the enum that's read", path, jsonString(value))
+			}
+		}
+	}
+
+	required := map[string]bool{}
+	for _, name := range writer.Required {
+		required[name] = true
+	}
+	for _, name := range reader.Required {
+		if !required[name] {
+			return fmt.Errorf("%s: %s is required, but may not be written", path, name)
+		}
+	}
+
+	for name, property := range writer.Properties {
+		readerProperty, ok := reader.Properties[name]
+		if !ok {
+			switch additional := reader.AdditionalProperties.(type) {
+			case bool:
+				if !additional {
+					return fmt.Errorf("%s: %s is written, but additional properties aren't read", path, name)
+				}
+			case *jsonschema.Schema:
+				readerProperty = additional
+			}
+		}
+
+		err := jsonReads(readerProperty, property, path+"/properties/"+name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if additional, ok := reader.AdditionalProperties.(bool); ok && !additional {
+		if open, ok := writer.AdditionalProperties.(bool); !ok || open {
+			return fmt.Errorf("%s: additional properties may be written, but aren't read", path)
+		}
+	}
+
+	return jsonReads(jsonItems(reader), jsonItems(writer), path+"/items")
+}
+
+func jsonDeref(s *jsonschema.Schema) *jsonschema.Schema {
+	for s != nil && s.Ref != nil {
+		s = s.Ref
+	}
+	return s
+}
+
+func jsonItems(s *jsonschema.Schema) *jsonschema.Schema {
+	if s.Items2020 != nil {
+		return s.Items2020
+	}
+
+	items, _ := s.Items.(*jsonschema.Schema)
+	return items
+}
+
+func jsonTypeAllowed(types []string, typ string) bool {
+	for _, allowed := range types {
+		if allowed == typ || (allowed == "number" && typ == "integer") {
+			return true
+		}
+	}
+	return false
+}
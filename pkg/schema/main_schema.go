@@ -0,0 +1,93 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+)
+
+// Schema is a parsed schema that record values are checked against.
+type Schema interface {
+	// Validate returns why value doesn't conform to the schema.
+	Validate(value string) error
+	// reads returns why records written with writer can't be read with
+	// this schema.
+	reads(writer Schema) error
+}
+
+var errTypeChanged = errors.New("schema type changed")
+
+func Parse(typ contracts.SchemaType, definition string) (Schema, error) {
+	switch typ {
+	case contracts.SchemaType_SCHEMA_TYPE_PROTOBUF:
+		return parseProtobuf(definition)
+	case contracts.SchemaType_SCHEMA_TYPE_JSON:
+		return parseJSON(definition)
+	case contracts.SchemaType_SCHEMA_TYPE_AVRO:
+		return parseAvro(definition)
+	default:
+		return nil, fmt.Errorf("unknown schema type %v", typ)
+	}
+}
+
+// ParseCompatibility returns the compatibility level called name, as in
+// "backward" or "none".
+func ParseCompatibility(name string) (contracts.Compatibility, error) {
+	value, ok := contracts.Compatibility_value["COMPATIBILITY_"+strings.ToUpper(name)]
+	if !ok || value == int32(contracts.Compatibility_COMPATIBILITY_UNSPECIFIED) {
+		return 0, fmt.Errorf("unknown compatibility %q", name)
+	}
+
+	return contracts.Compatibility(value), nil
+}
+
+// Check returns why next can't follow previous at compatibility.
+func Check(compatibility contracts.Compatibility, previous, next Schema) error {
+	switch compatibility {
+	case contracts.Compatibility_COMPATIBILITY_UNSPECIFIED, contracts.Compatibility_COMPATIBILITY_NONE:
+		return nil
+	case contracts.Compatibility_COMPATIBILITY_BACKWARD:
+		return next.reads(previous)
+	case contracts.Compatibility_COMPATIBILITY_FORWARD:
+		return previous.reads(next)
+	case contracts.Compatibility_COMPATIBILITY_FULL:
+		err := next.reads(previous)
+		if err != nil {
+			return err
+		}
+		return previous.reads(next)
+	default:
+		return fmt.Errorf("unknown compatibility %v", compatibility)
+	}
+}
+
+// decodeJSON decodes value, keeping numbers as json.Number.
+func decodeJSON(value string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+
+	var v interface{}
+
+	err := decoder.Decode(&v)
+	if err != nil {
+		return nil, fmt.Errorf("value is not JSON: %w", err)
+	}
+
+	_, err = decoder.Token()
+	if err != io.EOF {
+		return nil, errors.New("value is not JSON: data after the top-level value")
+	}
+
+	return v, nil
+}
+
+func jsonString(v interface{}) string {
+	var buf bytes.Buffer
+	_ = json.NewEncoder(&buf).Encode(v)
+	return strings.TrimSpace(buf.String())
+}
@@ -0,0 +1,233 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+)
+
+const (
+	protobufOrder = `syntax = "proto3";
+message Order {
+    string id = 1;
+    int64 cents = 2;
+    Status status = 3;
+    enum Status {
+        STATUS_UNSPECIFIED = 0;
+        STATUS_OPEN = 1;
+    }
+}`
+
+	jsonOrder = `{
+    "type": "object",
+    "properties": {"id": {"type": "string"}, "cents": {"type": "integer"}},
+    "required": ["id"]
+}`
+
+	avroOrder = `{
+    "type": "record",
+    "name": "Order",
+    "fields": [
+        {"name": "id", "type": "string"},
+        {"name": "cents", "type": "long"},
+        {"name": "note", "type": ["null", "string"], "default": null}
+    ]
+}`
+)
+
+func TestValidate(t *testing.T) {
+	tests := map[string]struct {
+		typ        contracts.SchemaType
+		definition string
+		value      string
+		err        bool
+	}{
+		"protobuf":                {typ: contracts.SchemaType_SCHEMA_TYPE_PROTOBUF, definition: protobufOrder, value: `{"id":"o-1","cents":"250","status":"STATUS_OPEN"}`},
+		"protobuf unknown field":  {typ: contracts.SchemaType_SCHEMA_TYPE_PROTOBUF, definition: protobufOrder, value: `{"id":"o-1","total":3}`, err: true},
+		"protobuf wrong type":     {typ: contracts.SchemaType_SCHEMA_TYPE_PROTOBUF, definition: protobufOrder, value: `{"id":1}`, err: true},
+		"json":                    {typ: contracts.SchemaType_SCHEMA_TYPE_JSON, definition: jsonOrder, value: `{"id":"o-1","cents":250}`},
+		"json missing required":   {typ: contracts.SchemaType_SCHEMA_TYPE_JSON, definition: jsonOrder, value: `{"cents":250}`, err: true},
+		"json not json":           {typ: contracts.SchemaType_SCHEMA_TYPE_JSON, definition: jsonOrder, value: `order o-1`, err: true},
+		"json trailing data":      {typ: contracts.SchemaType_SCHEMA_TYPE_JSON, definition: jsonOrder, value: `{"id":"o-1"} {}`, err: true},
+		"avro":                    {typ: contracts.SchemaType_SCHEMA_TYPE_AVRO, definition: avroOrder, value: `{"id":"o-1","cents":250}`},
+		"avro union":              {typ: contracts.SchemaType_SCHEMA_TYPE_AVRO, definition: avroOrder, value: `{"id":"o-1","cents":250,"note":{"string":"gift"}}`},
+		"avro union without null": {typ: contracts.SchemaType_SCHEMA_TYPE_AVRO, definition: avroOrder, value: `{"id":"o-1","cents":250,"note":"gift"}`, err: true},
+		"avro missing field":      {typ: contracts.SchemaType_SCHEMA_TYPE_AVRO, definition: avroOrder, value: `{"id":"o-1"}`, err: true},
+		"avro unknown field":      {typ: contracts.SchemaType_SCHEMA_TYPE_AVRO, definition: avroOrder, value: `{"id":"o-1","cents":1,"total":3}`, err: true},
+		"avro fractional long":    {typ: contracts.SchemaType_SCHEMA_TYPE_AVRO, definition: avroOrder, value: `{"id":"o-1","cents":2.5}`, err: true},
+	}
+
+	for situation, test := range tests {
+		t.Run(situation, func(t *testing.T) {
+			schema, err := Parse(test.typ, test.definition)
+			require.NoError(t, err)
+
+			err = schema.Validate(test.value)
+			if test.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		typ        contracts.SchemaType
+		definition string
+	}{
+		"protobuf syntax error":  {typ: contracts.SchemaType_SCHEMA_TYPE_PROTOBUF, definition: `message {`},
+		"protobuf no messages":   {typ: contracts.SchemaType_SCHEMA_TYPE_PROTOBUF, definition: `syntax = "proto3";`},
+		"protobuf other imports": {typ: contracts.SchemaType_SCHEMA_TYPE_PROTOBUF, definition: `syntax = "proto3"; import "other.proto";`},
+		"json not json":          {typ: contracts.SchemaType_SCHEMA_TYPE_JSON, definition: `{`},
+		"json remote ref":        {typ: contracts.SchemaType_SCHEMA_TYPE_JSON, definition: `{"$ref": "file:///etc/passwd"}`},
+		"avro unknown type":      {typ: contracts.SchemaType_SCHEMA_TYPE_AVRO, definition: `{"type": "Order"}`},
+		"unspecified":            {typ: contracts.SchemaType_SCHEMA_TYPE_UNSPECIFIED, definition: `{}`},
+	}
+
+	for situation, test := range tests {
+		t.Run(situation, func(t *testing.T) {
+			_, err := Parse(test.typ, test.definition)
+			require.Error(t, err)
+		})
+	}
+
+	_, err := Parse(contracts.SchemaType_SCHEMA_TYPE_PROTOBUF, `syntax = "proto3"; import "google/protobuf/timestamp.proto"; message Event { google.protobuf.Timestamp at = 1; }`)
+	require.NoError(t, err)
+}
+
+func TestCheck(t *testing.T) {
+	const (
+		backward = contracts.Compatibility_COMPATIBILITY_BACKWARD
+		forward  = contracts.Compatibility_COMPATIBILITY_FORWARD
+		full     = contracts.Compatibility_COMPATIBILITY_FULL
+		none     = contracts.Compatibility_COMPATIBILITY_NONE
+	)
+
+	tests := map[string]struct {
+		typ           contracts.SchemaType
+		previous      string
+		next          string
+		compatibility contracts.Compatibility
+		err           bool
+	}{
+		"avro field with a default added": {
+			typ:           contracts.SchemaType_SCHEMA_TYPE_AVRO,
+			previous:      avroOrder,
+			next:          `{"type":"record","name":"Order","fields":[{"name":"id","type":"string"},{"name":"cents","type":"long"},{"name":"note","type":["null","string"],"default":null},{"name":"currency","type":"string","default":"EUR"}]}`,
+			compatibility: full,
+		},
+		"avro field without a default added": {
+			typ:           contracts.SchemaType_SCHEMA_TYPE_AVRO,
+			previous:      avroOrder,
+			next:          `{"type":"record","name":"Order","fields":[{"name":"id","type":"string"},{"name":"cents","type":"long"},{"name":"currency","type":"string"}]}`,
+			compatibility: backward,
+			err:           true,
+		},
+		"avro field without a default removed": {
+			typ:           contracts.SchemaType_SCHEMA_TYPE_AVRO,
+			previous:      avroOrder,
+			next:          `{"type":"record","name":"Order","fields":[{"name":"id","type":"string"}]}`,
+			compatibility: forward,
+			err:           true,
+		},
+		"avro promoted type": {
+			typ:           contracts.SchemaType_SCHEMA_TYPE_AVRO,
+			previous:      `{"type":"record","name":"Order","fields":[{"name":"cents","type":"int"}]}`,
+			next:          `{"type":"record","name":"Order","fields":[{"name":"cents","type":"long"}]}`,
+			compatibility: backward,
+		},
+		"json optional property added": {
+			typ:           contracts.SchemaType_SCHEMA_TYPE_JSON,
+			previous:      jsonOrder,
+			next:          `{"type":"object","properties":{"id":{"type":"string"},"cents":{"type":"integer"},"note":{"type":"string"}},"required":["id"]}`,
+			compatibility: full,
+		},
+		"json required property added": {
+			typ:           contracts.SchemaType_SCHEMA_TYPE_JSON,
+			previous:      jsonOrder,
+			next:          `{"type":"object","properties":{"id":{"type":"string"},"cents":{"type":"integer"}},"required":["id","cents"]}`,
+			compatibility: backward,
+			err:           true,
+		},
+		"json type widened": {
+			typ:           contracts.SchemaType_SCHEMA_TYPE_JSON,
+			previous:      jsonOrder,
+			next:          `{"type":"object","properties":{"id":{"type":"string"},"cents":{"type":"number"}},"required":["id"]}`,
+			compatibility: backward,
+		},
+		"json type narrowed": {
+			typ:           contracts.SchemaType_SCHEMA_TYPE_JSON,
+			previous:      jsonOrder,
+			next:          `{"type":"object","properties":{"id":{"type":"string"},"cents":{"type":"number"}},"required":["id"]}`,
+			compatibility: forward,
+			err:           true,
+		},
+		"json closed": {
+			typ:           contracts.SchemaType_SCHEMA_TYPE_JSON,
+			previous:      jsonOrder,
+			next:          `{"type":"object","properties":{"id":{"type":"string"}},"required":["id"],"additionalProperties":false}`,
+			compatibility: backward,
+			err:           true,
+		},
+		"protobuf field added": {
+			typ:           contracts.SchemaType_SCHEMA_TYPE_PROTOBUF,
+			previous:      protobufOrder,
+			next:          `syntax = "proto3"; message Order { string id = 1; int64 cents = 2; string note = 4; }`,
+			compatibility: full,
+		},
+		"protobuf field kind changed": {
+			typ:           contracts.SchemaType_SCHEMA_TYPE_PROTOBUF,
+			previous:      protobufOrder,
+			next:          `syntax = "proto3"; message Order { string id = 1; string cents = 2; }`,
+			compatibility: backward,
+			err:           true,
+		},
+		"protobuf field renamed": {
+			typ:           contracts.SchemaType_SCHEMA_TYPE_PROTOBUF,
+			previous:      protobufOrder,
+			next:          `syntax = "proto3"; message Order { string id = 1; int64 amount = 2; }`,
+			compatibility: forward,
+			err:           true,
+		},
+		"protobuf enum value removed": {
+			typ:           contracts.SchemaType_SCHEMA_TYPE_PROTOBUF,
+			previous:      protobufOrder,
+			next:          `syntax = "proto3"; message Order { string id = 1; Status status = 3; enum Status { STATUS_UNSPECIFIED = 0; } }`,
+			compatibility: backward,
+			err:           true,
+		},
+		"incompatible without a check": {
+			typ:           contracts.SchemaType_SCHEMA_TYPE_PROTOBUF,
+			previous:      protobufOrder,
+			next:          `syntax = "proto3"; message Order { bool id = 1; }`,
+			compatibility: none,
+		},
+	}
+
+	for situation, test := range tests {
+		t.Run(situation, func(t *testing.T) {
+			previous, err := Parse(test.typ, test.previous)
+			require.NoError(t, err)
+
+			next, err := Parse(test.typ, test.next)
+			require.NoError(t, err)
+
+			err = Check(test.compatibility, previous, next)
+			if test.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+
+	// a subject can't change type
+	previous, err := Parse(contracts.SchemaType_SCHEMA_TYPE_JSON, jsonOrder)
+	require.NoError(t, err)
+	next, err := Parse(contracts.SchemaType_SCHEMA_TYPE_AVRO, avroOrder)
+	require.NoError(t, err)
+	require.ErrorIs(t, Check(backward, previous, next), errTypeChanged)
+}
@@ -0,0 +1,109 @@
+package schema
+
+import (
+	"fmt"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// protobufSchema checks values in protobuf's JSON encoding against the
+// first message of a .proto file, which may only import the well-known
+// types.
+type protobufSchema struct {
+	message protoreflect.MessageDescriptor
+}
+
+func parseProtobuf(definition string) (Schema, error) {
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{"schema.proto": definition}),
+	}
+
+	files, err := parser.ParseFiles("schema.proto")
+	if err != nil {
+		return nil, err
+	}
+
+	messages := files[0].GetMessageTypes()
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("schema.proto: no messages")
+	}
+
+	registry, err := protodesc.NewFiles(desc.ToFileDescriptorSet(files...))
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := registry.FindDescriptorByName(protoreflect.FullName(messages[0].GetFullyQualifiedName()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &protobufSchema{message: message.(protoreflect.MessageDescriptor)}, nil
+}
+
+func (s *protobufSchema) Validate(value string) error {
+	return protojson.Unmarshal([]byte(value), dynamicpb.NewMessage(s.message))
+}
+
+// reads matches fields by number. Fields may be added and removed, but a
+// field in both must keep its name, since values are JSON, and its kind and
+// cardinality, and an enum must keep the values the writer has.
+func (s *protobufSchema) reads(writer Schema) error {
+	w, ok := writer.(*protobufSchema)
+	if !ok {
+		return errTypeChanged
+	}
+
+	return protobufReads(s.message, w.message, map[protoreflect.FullName]bool{})
+}
+
+func protobufReads(reader, writer protoreflect.MessageDescriptor, seen map[protoreflect.FullName]bool) error {
+	if seen[writer.FullName()] {
+		return nil
+	}
+	seen[writer.FullName()] = true
+
+	fields := writer.Fields()
+
+	for i := 0; i < fields.Len(); i++ {
+		wf := fields.Get(i)
+
+		rf := reader.Fields().ByNumber(wf.Number())
+		if rf == nil {
+			continue
+		}
+
+		path := fmt.Sprintf("%s field %d", writer.FullName(), wf.Number())
+
+		switch {
+		case rf.Name() != wf.Name():
+			return fmt.Errorf("%s: named %s and %s", path, wf.Name(), rf.Name())
+		case rf.Kind() != wf.Kind():
+			return fmt.Errorf("%s: %s and %s", path, wf.Kind(), rf.Kind())
+		case rf.Cardinality() != wf.Cardinality() || rf.IsMap() != wf.IsMap():
+			return fmt.Errorf("%s: %s and %s", path, wf.Cardinality(), rf.Cardinality())
+		}
+
+		switch wf.Kind() {
+		case protoreflect.MessageKind, protoreflect.GroupKind:
+			err := protobufReads(rf.Message(), wf.Message(), seen)
+			if err != nil {
+				return err
+			}
+		case protoreflect.EnumKind:
+			values := wf.Enum().Values()
+			for j := 0; j < values.Len(); j++ {
+				if rf.Enum().Values().ByName(values.Get(j).Name()) == nil {
+					return fmt.Errorf("%s: %s may be written, but isn't a value of %s that's read", path, values.Get(j).Name(), rf.Enum().FullName())
+				}
+			}
+		}
+	}
+
+	return nil
+}
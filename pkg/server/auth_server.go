@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type principalKey struct{}
+
+// LoadTokens reads bearer tokens from a file with one "<token> <principal>"
+// pair per line. Blank lines and lines starting with # are ignored.
+func LoadTokens(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tokens := map[string]string{}
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want \"<token> <principal>\"", path, line)
+		}
+
+		tokens[fields[0]] = fields[1]
+	}
+
+	return tokens, scanner.Err()
+}
+
+type authenticator struct {
+	tokens map[string]string
+}
+
+func (a *authenticator) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if !strings.HasPrefix(method, "/"+contracts.Endpoints_ServiceDesc.ServiceName+"/") {
+		return ctx, nil
+	}
+
+	if principal(ctx) != anonymous {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	for _, value := range md.Get("authorization") {
+		token := strings.TrimPrefix(value, "Bearer ")
+
+		if p, ok := a.tokens[token]; ok && token != value {
+			return context.WithValue(ctx, principalKey{}, p), nil
+		}
+	}
+
+	return ctx, status.Error(codes.Unauthenticated, "a valid bearer token or client certificate is required")
+}
+
+func (a *authenticator) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (a *authenticator) streamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
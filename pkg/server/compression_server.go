@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"github.com/w-h-a/grpc-server/pkg/compression"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// compress stores records' values compressed with the requested codec, or
+// the server's default one. Batches sent compressed as one unit are still
+// stored a value at a time: each record is stored, encrypted, spilled and
+// read at its own index, so each value is compressed on its own.
+func (g *grpcServer) compress(records []*contracts.Record, requested contracts.Compression) error {
+	codec := requested
+	if codec == contracts.Compression_COMPRESSION_UNSPECIFIED {
+		codec = g.Config.Compression
+	}
+
+	for _, record := range records {
+		err := compression.CompressRecord(record, codec)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// unpackBatchUnaryInterceptor turns batches sent as compressed_records into
+// plain records before rate limits and handlers see them, so they're charged,
+// validated and stored like any other batch.
+func (c *Config) unpackBatchUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if req, ok := req.(*contracts.ProduceBatchRequest); ok {
+		err := c.unpackBatch(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return handler(ctx, req)
+}
+
+// unpackBatch decompresses a batch's compressed_records into its records. It
+// stops past the largest message the server would otherwise take, so a small
+// batch can't unpack into an unbounded one.
+func (c *Config) unpackBatch(req *contracts.ProduceBatchRequest) error {
+	if len(req.CompressedRecords) == 0 {
+		return nil
+	}
+
+	violation := func(description string) error {
+		return invalidArgument([]*errdetails.BadRequest_FieldViolation{{Field: "compressed_records", Description: description}})
+	}
+
+	if len(req.Records) > 0 {
+		return violation("can't be combined with records")
+	}
+
+	limit := c.maxRecvMsgSize()
+	if limit == 0 {
+		limit = defaultMaxRecvMsgSize
+	}
+
+	data, err := compression.DecompressLimit(req.Compression, req.CompressedRecords, limit)
+	if errors.Is(err, compression.ErrTooLarge) {
+		return violation(fmt.Sprintf("decompresses to more than %d bytes", limit))
+	}
+	if err != nil {
+		return violation(err.Error())
+	}
+
+	batch := &contracts.RecordBatch{}
+
+	err = proto.Unmarshal(data, batch)
+	if err != nil {
+		return violation(err.Error())
+	}
+
+	req.Records = batch.Records
+	req.CompressedRecords = nil
+
+	return nil
+}
+
+// decompress decompresses record's value, unless the consumer accepts its
+// codec.
+func decompress(record *contracts.Record, accept []contracts.Compression) error {
+	if record.Compression == nil {
+		return nil
+	}
+
+	for _, codec := range accept {
+		if codec == *record.Compression {
+			return nil
+		}
+	}
+
+	err := compression.DecompressRecord(record)
+	if err != nil {
+		return status.Errorf(codes.DataLoss, "record %d: %v", record.Index, err)
+	}
+
+	return nil
+}
+
+// decompressed returns record with its value decompressed, for filters to
+// look at records that are sent compressed.
+func decompressed(record *contracts.Record) (*contracts.Record, error) {
+	if record.Compression == nil {
+		return record, nil
+	}
+
+	clone := proto.Clone(record).(*contracts.Record)
+
+	return clone, decompress(clone, nil)
+}
+
+// valueSize is how many bytes of value record carries, compressed or not.
+func valueSize(record *contracts.Record) int {
+	return len(record.GetValue()) + len(record.GetCompressedValue())
+}
@@ -1,5 +1,38 @@
 package server
 
+import (
+	"time"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+)
+
 type Config struct {
-	CommitLog CommitLog
+	CommitLog  CommitLog
+	RateLimits *RateLimits
+	Health     *Health
+	Producers  *Producers
+	Schemas    *Schemas
+	// DeadLetters, when nil, takes records in after three failures.
+	DeadLetters *DeadLetters
+	// Scheduler holds records produced with deliver_at until they're due,
+	// once the caller has started it. Without one, deliver_at is rejected.
+	Scheduler *Scheduler
+	Tokens    map[string]string
+	// GatewayKey is the key the HTTP gateway's connection presents. Rate
+	// limits key anonymous calls carrying it by the client address the
+	// gateway forwards, rather than by the gateway's own address.
+	GatewayKey string
+
+	MaxRecordSize    int
+	MaxBatchSize     int
+	AllowEmptyValues bool
+	// RequireSchemas rejects records without a schema_id.
+	RequireSchemas bool
+
+	TransactionTimeout time.Duration
+	MaxConsumeStreams  int
+
+	// Compression is the codec for records whose producers don't pick one.
+	// The log has no topics, so it's the one policy for every record.
+	Compression contracts.Compression
 }
@@ -0,0 +1,459 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"github.com/w-h-a/grpc-server/pkg/log"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultMaxFailures     = 3
+	defaultDeadLetterLimit = 100
+
+	// failureTimeout is how long failures a consumer doesn't report again
+	// are counted for, so those of records it got past don't pile up.
+	failureTimeout = time.Hour
+)
+
+type deadLetterState struct {
+	ID       uint64 `json:"id"`
+	Consumer string `json:"consumer"`
+	Index    uint64 `json:"index"`
+	// Value is the record's value, or Sealed is, encrypted, when the log's
+	// records are.
+	Value          string      `json:"value,omitempty"`
+	Sealed         *log.Sealed `json:"sealed_value,omitempty"`
+	SchemaID       *uint32     `json:"schema_id,omitempty"`
+	Reason         string      `json:"reason,omitempty"`
+	Failures       uint32      `json:"failures"`
+	DeadLetteredAt time.Time   `json:"dead_lettered_at"`
+	RedrivenIndex  *uint64     `json:"redriven_index,omitempty"`
+}
+
+// record returns the dead-lettered record, with its value decrypted.
+func (s *deadLetterState) record(keys log.KeyWrapper) (*contracts.Record, error) {
+	value := s.Value
+
+	if s.Sealed != nil {
+		if keys == nil {
+			return nil, contracts.ErrDecryptionFailed{Index: s.Index}
+		}
+
+		plaintext, err := s.Sealed.Open(keys)
+		if err != nil {
+			return nil, contracts.ErrDecryptionFailed{Index: s.Index}
+		}
+
+		value = string(plaintext)
+	}
+
+	return &contracts.Record{Value: value, Index: s.Index, SchemaId: s.SchemaID}, nil
+}
+
+func (s *deadLetterState) info(keys log.KeyWrapper) (*contracts.DeadLetter, error) {
+	record, err := s.record(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &contracts.DeadLetter{
+		Id:             s.ID,
+		Consumer:       s.Consumer,
+		Index:          s.Index,
+		Record:         record,
+		Reason:         s.Reason,
+		Failures:       s.Failures,
+		DeadLetteredAt: timestamppb.New(s.DeadLetteredAt),
+		RedrivenIndex:  s.RedrivenIndex,
+	}, nil
+}
+
+type consumerIndex struct {
+	consumer string
+	index    uint64
+}
+
+type failureCount struct {
+	count uint32
+	last  time.Time
+}
+
+// DeadLetters is the dead-letter log: records consumers reported failing
+// on too many times, which their streams skip from then on.
+type DeadLetters struct {
+	maxFailures    uint32
+	failureTimeout time.Duration
+	keys           log.KeyWrapper
+
+	mu        sync.RWMutex
+	nextID    uint64
+	failures  map[consumerIndex]*failureCount
+	lastSweep time.Time
+	byIndex   map[consumerIndex]*deadLetterState
+	byID      map[uint64]*deadLetterState
+	ordered   []*deadLetterState
+	path      string
+	journal   *os.File
+}
+
+// NewDeadLetters returns a dead-letter log that takes records in after
+// maxFailures reports.
+func NewDeadLetters(maxFailures int) *DeadLetters {
+	if maxFailures <= 0 {
+		maxFailures = defaultMaxFailures
+	}
+
+	return &DeadLetters{
+		maxFailures:    uint32(maxFailures),
+		failureTimeout: failureTimeout,
+		nextID:         1,
+		failures:       map[consumerIndex]*failureCount{},
+		lastSweep:      time.Now(),
+		byIndex:        map[consumerIndex]*deadLetterState{},
+		byID:           map[uint64]*deadLetterState{},
+	}
+}
+
+// LoadDeadLetters restores dead letters from a journal file, where every
+// change to a dead letter is appended as a line of JSON and the last line
+// for a dead letter wins. The journal is compacted on load and appended to
+// after. With keys, values are kept sealed with them, in memory and in the
+// journal, and values journaled in plaintext before are sealed on load.
+//
+// Dead letters at or past nextIndex, the log's next index, are of records
+// the log doesn't have any more: they're still listed and can be re-driven,
+// but streams no longer skip whatever record now has their index. Re-drives
+// past nextIndex were lost with the log, so those can be re-driven again.
+func LoadDeadLetters(path string, maxFailures int, nextIndex uint64, keys log.KeyWrapper) (*DeadLetters, error) {
+	d := NewDeadLetters(maxFailures)
+	d.keys = keys
+
+	f, err := os.Open(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+		for scanner.Scan() {
+			state := &deadLetterState{}
+
+			err = json.Unmarshal(scanner.Bytes(), state)
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+
+			d.restore(state)
+		}
+
+		f.Close()
+
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(d.ordered, func(i, j int) bool { return d.ordered[i].ID < d.ordered[j].ID })
+
+	for _, state := range d.ordered {
+		if state.Index >= nextIndex {
+			delete(d.byIndex, consumerIndex{state.Consumer, state.Index})
+		}
+
+		if state.RedrivenIndex != nil && *state.RedrivenIndex >= nextIndex {
+			state.RedrivenIndex = nil
+		}
+
+		if keys != nil && state.Sealed == nil {
+			state.Sealed, err = log.Seal(keys, []byte(state.Value))
+			if err != nil {
+				return nil, err
+			}
+			state.Value = ""
+		}
+	}
+
+	d.path = path
+
+	err = d.compact()
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (d *DeadLetters) restore(state *deadLetterState) {
+	if previous, ok := d.byID[state.ID]; ok {
+		*previous = *state
+		return
+	}
+
+	d.byID[state.ID] = state
+	d.byIndex[consumerIndex{state.Consumer, state.Index}] = state
+	d.ordered = append(d.ordered, state)
+
+	if state.ID >= d.nextID {
+		d.nextID = state.ID + 1
+	}
+}
+
+func (d *DeadLetters) compact() error {
+	journal, err := rewriteJournal(d.path, func(encoder *json.Encoder) error {
+		for _, state := range d.ordered {
+			err := encoder.Encode(state)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if d.journal != nil {
+		d.journal.Close()
+	}
+
+	d.journal = journal
+
+	return nil
+}
+
+// Rewrap wraps the data keys of sealed values with the current
+// key-encryption key, and rewrites the journal with them, so older keys can
+// be dropped.
+func (d *DeadLetters) Rewrap() error {
+	if d.keys == nil {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, state := range d.ordered {
+		if state.Sealed == nil {
+			continue
+		}
+
+		err := state.Sealed.Rewrap(d.keys)
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.journal == nil {
+		return nil
+	}
+
+	return d.compact()
+}
+
+func (d *DeadLetters) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.journal == nil {
+		return nil
+	}
+
+	return d.journal.Close()
+}
+
+// Report counts a failure of consumer on record, and dead-letters record
+// with reason once consumer has failed on it maxFailures times. A record
+// already dead-lettered for consumer stays as it is.
+func (d *DeadLetters) Report(consumer string, record *contracts.Record, reason string) (uint32, *contracts.DeadLetter, error) {
+	key := consumerIndex{consumer, record.Index}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if state, ok := d.byIndex[key]; ok {
+		info, err := state.info(d.keys)
+		return state.Failures, info, err
+	}
+
+	now := time.Now()
+	d.sweepFailures(now)
+
+	count, ok := d.failures[key]
+	if !ok {
+		count = &failureCount{}
+		d.failures[key] = count
+	}
+
+	count.count++
+	count.last = now
+
+	if count.count < d.maxFailures {
+		return count.count, nil, nil
+	}
+
+	state := &deadLetterState{
+		ID:             d.nextID,
+		Consumer:       consumer,
+		Index:          record.Index,
+		Value:          record.Value,
+		SchemaID:       record.SchemaId,
+		Reason:         reason,
+		Failures:       count.count,
+		DeadLetteredAt: now.UTC(),
+	}
+
+	if d.keys != nil {
+		sealed, err := log.Seal(d.keys, []byte(record.Value))
+		if err != nil {
+			return 0, nil, err
+		}
+		state.Value, state.Sealed = "", sealed
+	}
+
+	err := d.persist(state)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	delete(d.failures, key)
+	d.restore(state)
+
+	info, err := state.info(d.keys)
+	return state.Failures, info, err
+}
+
+// sweepFailures forgets failures that weren't reported again for the
+// failure timeout, at most once per timeout.
+func (d *DeadLetters) sweepFailures(now time.Time) {
+	if now.Sub(d.lastSweep) < d.failureTimeout {
+		return
+	}
+
+	d.lastSweep = now
+
+	for key, count := range d.failures {
+		if now.Sub(count.last) >= d.failureTimeout {
+			delete(d.failures, key)
+		}
+	}
+}
+
+// Skipped reports whether the record at index is dead-lettered for
+// consumer.
+func (d *DeadLetters) Skipped(consumer string, index uint64) bool {
+	if len(consumer) == 0 {
+		return false
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	_, ok := d.byIndex[consumerIndex{consumer, index}]
+	return ok
+}
+
+// List returns up to limit dead letters after afterID, of consumer if it's
+// set.
+func (d *DeadLetters) List(consumer string, afterID uint64, limit int) ([]*contracts.DeadLetter, error) {
+	if limit <= 0 {
+		limit = defaultDeadLetterLimit
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	start := sort.Search(len(d.ordered), func(i int) bool { return d.ordered[i].ID > afterID })
+
+	var deadLetters []*contracts.DeadLetter
+
+	for _, state := range d.ordered[start:] {
+		if len(deadLetters) == limit {
+			break
+		}
+
+		if len(consumer) > 0 && state.Consumer != consumer {
+			continue
+		}
+
+		info, err := state.info(d.keys)
+		if err != nil {
+			return nil, err
+		}
+
+		deadLetters = append(deadLetters, info)
+	}
+
+	return deadLetters, nil
+}
+
+func (d *DeadLetters) Get(id uint64) (*contracts.DeadLetter, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	state, ok := d.byID[id]
+	if !ok {
+		return nil, contracts.ErrUnknownDeadLetter{ID: id}
+	}
+
+	return state.info(d.keys)
+}
+
+// Redrive calls appendRecord with dead letter id's record, unless it was
+// re-driven before, and records where it was appended. Re-drives are
+// serialized so a record is never appended twice.
+func (d *DeadLetters) Redrive(id uint64, appendRecord func(*contracts.Record) (uint64, error)) (*contracts.DeadLetter, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, ok := d.byID[id]
+	if !ok {
+		return nil, contracts.ErrUnknownDeadLetter{ID: id}
+	}
+
+	if state.RedrivenIndex != nil {
+		return state.info(d.keys)
+	}
+
+	record, err := state.record(d.keys)
+	if err != nil {
+		return nil, err
+	}
+
+	record.Index = 0
+
+	index, err := appendRecord(record)
+	if err != nil {
+		return nil, err
+	}
+
+	state.RedrivenIndex = &index
+
+	// the record is in the log either way; re-driving again after this fails
+	// is still caught by the state in memory
+	err = d.persist(state)
+	if err != nil {
+		return nil, err
+	}
+
+	return state.info(d.keys)
+}
+
+func (d *DeadLetters) persist(state *deadLetterState) error {
+	if d.journal == nil {
+		return nil
+	}
+
+	return appendJournal(d.journal, state)
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"container/list"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	maxFilterLength  = 1024
+	filterCostLimit  = 10000
+	filterCacheSize  = 256
+	progressInterval = time.Second
+)
+
+type recordFilter func(*contracts.Record) (bool, error)
+
+type cachedFilter struct {
+	key   string
+	match recordFilter
+}
+
+// filterCache compiles ConsumeStream filters, keeping the most recently
+// used ones so consumers reconnecting with the same filter don't pay for
+// compiling it again.
+type filterCache struct {
+	env *cel.Env
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+func newFilterCache() (*filterCache, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("value", cel.StringType),
+		cel.Variable("index", cel.UintType),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &filterCache{
+		env:     env,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+	}, nil
+}
+
+// compile returns nil for requests without a filter.
+func (c *filterCache) compile(filter *contracts.Filter) (recordFilter, error) {
+	if filter == nil {
+		return nil, nil
+	}
+
+	key := filterKey(filter)
+
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		c.mu.Unlock()
+		return element.Value.(*cachedFilter).match, nil
+	}
+	c.mu.Unlock()
+
+	match, err := c.compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = c.order.PushFront(&cachedFilter{key: key, match: match})
+
+		if c.order.Len() > filterCacheSize {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*cachedFilter).key)
+		}
+	}
+
+	return match, nil
+}
+
+func filterKey(filter *contracts.Filter) string {
+	switch expression := filter.Expression.(type) {
+	case *contracts.Filter_ValuePrefix:
+		return "prefix:" + expression.ValuePrefix
+	case *contracts.Filter_ValueRegex:
+		return "regex:" + expression.ValueRegex
+	case *contracts.Filter_Cel:
+		return "cel:" + expression.Cel
+	default:
+		return ""
+	}
+}
+
+func (c *filterCache) compileFilter(filter *contracts.Filter) (recordFilter, error) {
+	switch expression := filter.Expression.(type) {
+	case *contracts.Filter_ValuePrefix:
+		prefix := expression.ValuePrefix
+
+		return func(record *contracts.Record) (bool, error) {
+			return strings.HasPrefix(record.Value, prefix), nil
+		}, nil
+	case *contracts.Filter_ValueRegex:
+		err := checkFilterLength("filter.value_regex", expression.ValueRegex)
+		if err != nil {
+			return nil, err
+		}
+
+		re, err := regexp.Compile(expression.ValueRegex)
+		if err != nil {
+			return nil, contracts.ErrInvalidFilter{Field: "filter.value_regex", Description: err.Error()}
+		}
+
+		return func(record *contracts.Record) (bool, error) {
+			return re.MatchString(record.Value), nil
+		}, nil
+	case *contracts.Filter_Cel:
+		return c.compileCEL(expression.Cel)
+	default:
+		return nil, contracts.ErrInvalidFilter{Field: "filter", Description: "an expression is required"}
+	}
+}
+
+func (c *filterCache) compileCEL(expression string) (recordFilter, error) {
+	const field = "filter.cel"
+
+	err := checkFilterLength(field, expression)
+	if err != nil {
+		return nil, err
+	}
+
+	ast, issues := c.env.Compile(expression)
+	if issues.Err() != nil {
+		return nil, contracts.ErrInvalidFilter{Field: field, Description: issues.Err().Error()}
+	}
+
+	if ast.OutputType() != cel.BoolType {
+		return nil, contracts.ErrInvalidFilter{Field: field, Description: "expression must evaluate to a bool"}
+	}
+
+	program, err := c.env.Program(ast, cel.CostLimit(filterCostLimit))
+	if err != nil {
+		return nil, contracts.ErrInvalidFilter{Field: field, Description: err.Error()}
+	}
+
+	return func(record *contracts.Record) (bool, error) {
+		out, _, err := program.Eval(map[string]interface{}{
+			"value": record.Value,
+			"index": record.Index,
+		})
+		if err != nil {
+			return false, contracts.ErrInvalidFilter{
+				Field:       field,
+				Description: fmt.Sprintf("evaluating record %d: %s", record.Index, err),
+			}
+		}
+
+		matched, _ := out.Value().(bool)
+		return matched, nil
+	}, nil
+}
+
+func checkFilterLength(field string, expression string) error {
+	if len(expression) <= maxFilterLength {
+		return nil
+	}
+
+	return contracts.ErrInvalidFilter{
+		Field:       field,
+		Description: fmt.Sprintf("expression is %d bytes, which exceeds the maximum of %d", len(expression), maxFilterLength),
+	}
+}
+
+// progress tells filtered streams how far they've got now and then, so a
+// consumer whose filter matches nothing for a while can still resume from
+// past the records it skipped.
+type progress struct {
+	enabled  bool
+	reported uint64
+	sentAt   time.Time
+}
+
+func (p *progress) sent(index uint64) {
+	p.reported = index
+	p.sentAt = time.Now()
+}
+
+// report sends index as progress if the stream is filtered and hasn't been
+// told about it yet, at most once per progressInterval unless forced.
+func (p *progress) report(stream responseStream, index uint64, force bool) error {
+	if !p.enabled || index <= p.reported {
+		return nil
+	}
+
+	if !force && time.Since(p.sentAt) < progressInterval {
+		return nil
+	}
+
+	p.sent(index)
+
+	return stream.Send(&contracts.ConsumeResponse{ProgressIndex: proto.Uint64(index)})
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/health"
+	healthsrv "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var healthServices = []string{"", contracts.Endpoints_ServiceDesc.ServiceName}
+
+// healthCheckTimeout bounds each check when they don't run periodically.
+const healthCheckTimeout = 10 * time.Second
+
+// Health reports SERVING for the Endpoints service only once it has been
+// marked ready, is not draining, and every registered check passes.
+type Health struct {
+	server   *health.Server
+	checks   []HealthCheck
+	interval time.Duration
+	logger   *zap.Logger
+
+	mu       sync.Mutex
+	ready    bool
+	draining bool
+	failing  map[string]error
+
+	drain chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewHealth runs checks once before returning, so being marked ready never
+// reports SERVING ahead of them, and then every interval, unless interval
+// isn't positive.
+func NewHealth(interval time.Duration, checks ...HealthCheck) *Health {
+	h := &Health{
+		server:   health.NewServer(),
+		checks:   checks,
+		interval: interval,
+		logger:   zap.L().Named("server.health"),
+		failing:  map[string]error{},
+		drain:    make(chan struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	h.runChecks()
+
+	if len(checks) > 0 && interval > 0 {
+		go h.run()
+	} else {
+		close(h.done)
+	}
+
+	return h
+}
+
+func (h *Health) SetReady(ready bool) {
+	h.mu.Lock()
+	h.ready = ready
+	h.mu.Unlock()
+
+	h.update()
+}
+
+// Drain permanently reports NOT_SERVING so load balancers stop sending new
+// traffic while in-flight requests finish, and tells long-lived streams to
+// wrap up.
+func (h *Health) Drain() {
+	h.mu.Lock()
+	if !h.draining {
+		h.draining = true
+		close(h.drain)
+	}
+	h.mu.Unlock()
+
+	h.update()
+}
+
+func (h *Health) Draining() <-chan struct{} {
+	return h.drain
+}
+
+func (h *Health) Close() {
+	select {
+	case <-h.stop:
+	default:
+		close(h.stop)
+	}
+
+	<-h.done
+}
+
+func (h *Health) run() {
+	defer close(h.done)
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+			h.runChecks()
+		}
+	}
+}
+
+func (h *Health) runChecks() {
+	failing := map[string]error{}
+
+	timeout := h.interval
+	if timeout <= 0 {
+		timeout = healthCheckTimeout
+	}
+
+	for _, check := range h.checks {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := check.Check(ctx)
+		cancel()
+
+		if err != nil {
+			failing[check.Name()] = err
+		}
+	}
+
+	h.mu.Lock()
+	for name, err := range failing {
+		if _, ok := h.failing[name]; !ok {
+			h.logger.Warn("health check failing", zap.String("check", name), zap.Error(err))
+		}
+	}
+	for name := range h.failing {
+		if _, ok := failing[name]; !ok {
+			h.logger.Info("health check recovered", zap.String("check", name))
+		}
+	}
+	h.failing = failing
+	h.mu.Unlock()
+
+	h.update()
+}
+
+func (h *Health) update() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	status := healthsrv.HealthCheckResponse_SERVING
+	if !h.ready || h.draining || len(h.failing) > 0 {
+		status = healthsrv.HealthCheckResponse_NOT_SERVING
+	}
+
+	for _, service := range healthServices {
+		h.server.SetServingStatus(service, status)
+	}
+}
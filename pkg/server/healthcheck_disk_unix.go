@@ -0,0 +1,16 @@
+//go:build !windows
+
+package server
+
+import "syscall"
+
+func freeBytes(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+
+	err := syscall.Statfs(dir, &stat)
+	if err != nil {
+		return 0, err
+	}
+
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
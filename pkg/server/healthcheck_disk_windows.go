@@ -0,0 +1,9 @@
+//go:build windows
+
+package server
+
+import "errors"
+
+func freeBytes(dir string) (uint64, error) {
+	return 0, errors.New("disk space checks are not supported on windows")
+}
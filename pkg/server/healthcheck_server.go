@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type healthCheckFunc struct {
+	name string
+	fn   func(context.Context) error
+}
+
+func HealthCheckFunc(name string, fn func(context.Context) error) HealthCheck {
+	return &healthCheckFunc{name: name, fn: fn}
+}
+
+func (h *healthCheckFunc) Name() string {
+	return h.name
+}
+
+func (h *healthCheckFunc) Check(ctx context.Context) error {
+	return h.fn(ctx)
+}
+
+type DiskSpaceCheck struct {
+	Dir          string
+	MinFreeBytes uint64
+}
+
+func (d *DiskSpaceCheck) Name() string {
+	return "disk_space"
+}
+
+func (d *DiskSpaceCheck) Check(ctx context.Context) error {
+	free, err := freeBytes(d.Dir)
+	if err != nil {
+		return err
+	}
+
+	if free < d.MinFreeBytes {
+		return fmt.Errorf("%s has %d bytes free, want at least %d", d.Dir, free, d.MinFreeBytes)
+	}
+
+	return nil
+}
+
+type WritableDirCheck struct {
+	Dir string
+}
+
+func (w *WritableDirCheck) Name() string {
+	return "writable_dir"
+}
+
+func (w *WritableDirCheck) Check(ctx context.Context) error {
+	file, err := os.CreateTemp(w.Dir, ".health-*")
+	if err != nil {
+		return err
+	}
+
+	name := file.Name()
+
+	_, err = file.Write([]byte("ok"))
+	if err == nil {
+		err = file.Sync()
+	}
+
+	closeErr := file.Close()
+	removeErr := os.Remove(filepath.Clean(name))
+
+	switch {
+	case err != nil:
+		return err
+	case closeErr != nil:
+		return closeErr
+	default:
+		return removeErr
+	}
+}
+
+type LagCheck struct {
+	Lag    func() time.Duration
+	MaxLag time.Duration
+}
+
+func (l *LagCheck) Name() string {
+	return "replication_lag"
+}
+
+func (l *LagCheck) Check(ctx context.Context) error {
+	if lag := l.Lag(); lag > l.MaxLag {
+		return fmt.Errorf("replication lag is %s, want at most %s", lag, l.MaxLag)
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+const anonymous = "anonymous"
+
+func principal(ctx context.Context) string {
+	if p, ok := ctx.Value(principalKey{}).(string); ok {
+		return p
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.AuthInfo == nil {
+		return anonymous
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return anonymous
+	}
+
+	return tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
+}
+
+func peerAddress(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	return p.Addr.String()
+}
+
+func tagRequest(ctx context.Context) {
+	tags := grpc_ctxtags.Extract(ctx)
+	tags.Set("peer.address", peerAddress(ctx))
+	tags.Set("auth.principal", principal(ctx))
+}
+
+func requestTagsUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	tagRequest(ctx)
+	return handler(ctx, req)
+}
+
+func requestTagsStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	tagRequest(stream.Context())
+	return handler(srv, stream)
+}
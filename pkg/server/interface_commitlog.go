@@ -4,5 +4,10 @@ import contracts "github.com/w-h-a/grpc-server/contracts/v1"
 
 type CommitLog interface {
 	Append(*contracts.Record) (uint64, error)
+	AppendBatch([]*contracts.Record) ([]uint64, error)
+	CompareAndAppend(uint64, []*contracts.Record) ([]uint64, error)
 	Read(uint64) (*contracts.Record, error)
+	FirstIndex() uint64
+	NextIndex() uint64
+	Appended(uint64) <-chan struct{}
 }
@@ -0,0 +1,8 @@
+package server
+
+import "context"
+
+type HealthCheck interface {
+	Name() string
+	Check(context.Context) error
+}
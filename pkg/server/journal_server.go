@@ -0,0 +1,53 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// journalCompactEvery is how many lines are appended to a journal, beyond
+// the state it was last compacted to, before it's compacted again.
+const journalCompactEvery = 1024
+
+// rewriteJournal replaces the journal at path with the lines write encodes,
+// and opens it to be appended to.
+func rewriteJournal(path string, write func(*json.Encoder) error) (*os.File, error) {
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	err = write(json.NewEncoder(f))
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// appendJournal appends v to a journal as a line of JSON, and syncs it so
+// the line survives a crash.
+func appendJournal(journal *os.File, v interface{}) error {
+	err := json.NewEncoder(journal).Encode(v)
+	if err != nil {
+		return err
+	}
+
+	return journal.Sync()
+}
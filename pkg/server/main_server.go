@@ -14,7 +14,9 @@ import (
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/health"
+	// registering gzip lets clients compress messages, and get compressed
+	// responses back
+	_ "google.golang.org/grpc/encoding/gzip"
 	healthsrv "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
@@ -22,9 +24,18 @@ import (
 type grpcServer struct {
 	contracts.UnimplementedEndpointsServer
 	Config *Config
+
+	txns    *transactions
+	filters *filterCache
+	streams *gauge
+	paused  *gauge
 }
 
 func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, error) {
+	// defaults are filled in on a copy, so the caller's config is left as it is
+	cfg := *config
+	config = &cfg
+
 	logger := zap.L().Named("server")
 	zapOpts := []grpc_zap.Option{
 		grpc_zap.WithDurationField(
@@ -39,31 +50,81 @@ func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, err
 		return nil, err
 	}
 
+	err = view.Register(serverViews...)
+	if err != nil {
+		return nil, err
+	}
+
 	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
 
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		grpc_ctxtags.StreamServerInterceptor(),
+		grpc_zap.StreamServerInterceptor(logger, zapOpts...),
+	}
+
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		grpc_ctxtags.UnaryServerInterceptor(),
+		grpc_zap.UnaryServerInterceptor(logger, zapOpts...),
+	}
+
+	if len(config.Tokens) > 0 {
+		auth := &authenticator{tokens: config.Tokens}
+		streamInterceptors = append(streamInterceptors, auth.streamInterceptor)
+		unaryInterceptors = append(unaryInterceptors, auth.unaryInterceptor)
+	}
+
+	streamInterceptors = append(streamInterceptors, requestTagsStreamInterceptor)
+	unaryInterceptors = append(unaryInterceptors, requestTagsUnaryInterceptor, config.unpackBatchUnaryInterceptor)
+
+	if config.RateLimits != nil {
+		limiter := newRateLimiter(config.RateLimits)
+		limiter.gatewayKey = config.GatewayKey
+		streamInterceptors = append(streamInterceptors, limiter.streamInterceptor)
+		unaryInterceptors = append(unaryInterceptors, limiter.unaryInterceptor)
+	}
+
 	opts = append(opts,
-		grpc.StreamInterceptor(
-			grpc_middleware.ChainStreamServer(
-				grpc_ctxtags.StreamServerInterceptor(),
-				grpc_zap.StreamServerInterceptor(logger, zapOpts...),
-			),
-		),
-		grpc.UnaryInterceptor(
-			grpc_middleware.ChainUnaryServer(
-				grpc_ctxtags.UnaryServerInterceptor(),
-				grpc_zap.UnaryServerInterceptor(logger, zapOpts...),
-			),
-		),
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(streamInterceptors...)),
+		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unaryInterceptors...)),
 		grpc.StatsHandler(&ocgrpc.ServerHandler{}),
 	)
 
+	if size := config.maxRecvMsgSize(); size > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(size))
+	}
+
 	gsrv := grpc.NewServer(opts...)
 
-	hsrv := health.NewServer()
-	hsrv.SetServingStatus("", healthsrv.HealthCheckResponse_SERVING)
-	healthsrv.RegisterHealthServer(gsrv, hsrv)
+	if config.Health == nil {
+		config.Health = NewHealth(0)
+		config.Health.SetReady(true)
+	}
+	healthsrv.RegisterHealthServer(gsrv, config.Health.server)
+
+	if config.Producers == nil {
+		config.Producers = NewProducers()
+	}
 
-	srv := &grpcServer{Config: config}
+	if config.Schemas == nil {
+		config.Schemas = NewSchemas(contracts.Compatibility_COMPATIBILITY_BACKWARD)
+	}
+
+	if config.DeadLetters == nil {
+		config.DeadLetters = NewDeadLetters(defaultMaxFailures)
+	}
+
+	filters, err := newFilterCache()
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &grpcServer{
+		Config:  config,
+		txns:    newTransactions(config.CommitLog, config.TransactionTimeout),
+		filters: filters,
+		streams: &gauge{measure: consumeStreams},
+		paused:  &gauge{measure: pausedSubscribers},
+	}
 	contracts.RegisterEndpointsServer(gsrv, srv)
 
 	reflection.Register(gsrv)
@@ -72,61 +133,385 @@ func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, err
 }
 
 func (g *grpcServer) Produce(ctx context.Context, req *contracts.ProduceRequest) (*contracts.ProduceResponse, error) {
-	index, err := g.Config.CommitLog.Append(req.Record)
+	err := g.Config.validateProduce(req)
 	if err != nil {
 		return nil, err
 	}
-	return &contracts.ProduceResponse{Index: index}, nil
-}
 
-func (g *grpcServer) Consume(ctx context.Context, req *contracts.ConsumeRequest) (*contracts.ConsumeResponse, error) {
-	record, err := g.Config.CommitLog.Read(req.Index)
+	err = g.compress([]*contracts.Record{req.Record}, req.Compression)
 	if err != nil {
 		return nil, err
 	}
-	return &contracts.ConsumeResponse{Record: record}, nil
+
+	if req.TxnId != 0 {
+		indexes, err := g.txns.add(req.TxnId, []*contracts.Record{req.Record})
+		if err != nil {
+			return nil, err
+		}
+		return &contracts.ProduceResponse{Index: indexes[0]}, nil
+	}
+
+	if req.DeliverAt != nil {
+		id, err := g.Config.Scheduler.Schedule(req.Record, req.DeliverAt.AsTime())
+		if err != nil {
+			return nil, err
+		}
+		return &contracts.ProduceResponse{ScheduleId: id}, nil
+	}
+
+	indexes, duplicate, err := g.appendRecords([]*contracts.Record{req.Record}, req.ProducerId, req.ProducerEpoch, req.Sequence, req.ExpectedIndex)
+	if err != nil {
+		return nil, err
+	}
+	return &contracts.ProduceResponse{Index: indexes[0], Duplicate: duplicate}, nil
 }
 
-func (g *grpcServer) ProduceStream(stream contracts.Endpoints_ProduceStreamServer) error {
-	for {
-		req, err := stream.Recv()
+func (g *grpcServer) ProduceBatch(ctx context.Context, req *contracts.ProduceBatchRequest) (*contracts.ProduceBatchResponse, error) {
+	err := g.Config.validateProduceBatch(req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = g.compress(req.Records, req.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.TxnId != 0 {
+		indexes, err := g.txns.add(req.TxnId, req.Records)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		return &contracts.ProduceBatchResponse{Indexes: indexes}, nil
+	}
+
+	indexes, duplicate, err := g.appendRecords(req.Records, req.ProducerId, req.ProducerEpoch, req.Sequence, req.ExpectedIndex)
+	if err != nil {
+		return nil, err
+	}
+	return &contracts.ProduceBatchResponse{Indexes: indexes, Duplicate: duplicate}, nil
+}
+
+// appendRecords appends for Produce and ProduceBatch. Records from an
+// idempotent producer go through its sequence check first, and records with
+// an expected index are only appended at that index.
+func (g *grpcServer) appendRecords(records []*contracts.Record, producerID uint64, epoch uint32, sequence uint64, expectedIndex *uint64) ([]uint64, bool, error) {
+	appendRecords := func() ([]uint64, error) {
+		if expectedIndex != nil {
+			return g.Config.CommitLog.CompareAndAppend(*expectedIndex, records)
+		}
+		return g.Config.CommitLog.AppendBatch(records)
+	}
+
+	if producerID == 0 {
+		indexes, err := appendRecords()
+		return indexes, false, err
+	}
+
+	return g.Config.Producers.Append(producerID, epoch, sequence, len(records), appendRecords)
+}
+
+func (g *grpcServer) CancelSchedule(ctx context.Context, req *contracts.CancelScheduleRequest) (*contracts.CancelScheduleResponse, error) {
+	err := g.Config.Scheduler.Cancel(req.ScheduleId)
+	if err != nil {
+		return nil, err
+	}
+	return &contracts.CancelScheduleResponse{}, nil
+}
+
+func (g *grpcServer) InitProducer(ctx context.Context, req *contracts.InitProducerRequest) (*contracts.InitProducerResponse, error) {
+	id, epoch, err := g.Config.Producers.Init(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &contracts.InitProducerResponse{ProducerId: id, ProducerEpoch: epoch}, nil
+}
+
+func (g *grpcServer) BeginTxn(ctx context.Context, req *contracts.BeginTxnRequest) (*contracts.BeginTxnResponse, error) {
+	return &contracts.BeginTxnResponse{TxnId: g.txns.begin()}, nil
+}
+
+func (g *grpcServer) AddRecords(ctx context.Context, req *contracts.AddRecordsRequest) (*contracts.AddRecordsResponse, error) {
+	err := g.Config.validateProduceBatch(&contracts.ProduceBatchRequest{Records: req.Records, Compression: req.Compression})
+	if err != nil {
+		return nil, err
+	}
+
+	err = g.compress(req.Records, req.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, err := g.txns.add(req.TxnId, req.Records)
+	if err != nil {
+		return nil, err
+	}
+	return &contracts.AddRecordsResponse{Indexes: indexes}, nil
+}
+
+func (g *grpcServer) CommitTxn(ctx context.Context, req *contracts.CommitTxnRequest) (*contracts.CommitTxnResponse, error) {
+	index, err := g.txns.end(req.TxnId, contracts.Control_CONTROL_COMMIT)
+	if err != nil {
+		return nil, err
+	}
+	return &contracts.CommitTxnResponse{Index: index}, nil
+}
+
+func (g *grpcServer) AbortTxn(ctx context.Context, req *contracts.AbortTxnRequest) (*contracts.AbortTxnResponse, error) {
+	index, err := g.txns.end(req.TxnId, contracts.Control_CONTROL_ABORT)
+	if err != nil {
+		return nil, err
+	}
+	return &contracts.AbortTxnResponse{Index: index}, nil
+}
+
+func (g *grpcServer) RegisterSchema(ctx context.Context, req *contracts.RegisterSchemaRequest) (*contracts.RegisterSchemaResponse, error) {
+	schema, err := g.Config.Schemas.Register(req.Subject, req.Type, req.Definition, req.Compatibility)
+	if err != nil {
+		return nil, err
+	}
+	return &contracts.RegisterSchemaResponse{Schema: schema}, nil
+}
+
+func (g *grpcServer) GetSchema(ctx context.Context, req *contracts.GetSchemaRequest) (*contracts.GetSchemaResponse, error) {
+	var schema *contracts.Schema
+	var err error
+
+	if req.Id != 0 {
+		schema, err = g.Config.Schemas.Get(req.Id)
+	} else {
+		schema, err = g.Config.Schemas.GetVersion(req.Subject, req.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &contracts.GetSchemaResponse{Schema: schema}, nil
+}
+
+func (g *grpcServer) ListSchemaVersions(ctx context.Context, req *contracts.ListSchemaVersionsRequest) (*contracts.ListSchemaVersionsResponse, error) {
+	schemas, compatibility, err := g.Config.Schemas.List(req.Subject)
+	if err != nil {
+		return nil, err
+	}
+	return &contracts.ListSchemaVersionsResponse{Schemas: schemas, Compatibility: compatibility}, nil
+}
+
+func (g *grpcServer) ReportFailure(ctx context.Context, req *contracts.ReportFailureRequest) (*contracts.ReportFailureResponse, error) {
+	if len(req.Consumer) == 0 {
+		return nil, contracts.ErrInvalidReport{Field: "consumer", Description: "consumer is required"}
+	}
 
-		res, err := g.Produce(stream.Context(), req)
+	// only committed records can be dead-lettered
+	res, err := g.Consume(ctx, &contracts.ConsumeRequest{Index: req.Index, ReadCommitted: true})
+	if err != nil {
+		return nil, err
+	}
+
+	failures, deadLetter, err := g.Config.DeadLetters.Report(req.Consumer, res.Record, req.Reason)
+	if err != nil {
+		return nil, err
+	}
+	return &contracts.ReportFailureResponse{Failures: failures, DeadLetter: deadLetter}, nil
+}
+
+func (g *grpcServer) ListDeadLetters(ctx context.Context, req *contracts.ListDeadLettersRequest) (*contracts.ListDeadLettersResponse, error) {
+	deadLetters, err := g.Config.DeadLetters.List(req.Consumer, req.AfterId, int(req.Limit))
+	if err != nil {
+		return nil, err
+	}
+	return &contracts.ListDeadLettersResponse{DeadLetters: deadLetters}, nil
+}
+
+func (g *grpcServer) GetDeadLetter(ctx context.Context, req *contracts.GetDeadLetterRequest) (*contracts.GetDeadLetterResponse, error) {
+	deadLetter, err := g.Config.DeadLetters.Get(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &contracts.GetDeadLetterResponse{DeadLetter: deadLetter}, nil
+}
+
+// RedriveDeadLetter appends a dead letter's record again, stored with the
+// server's default codec.
+func (g *grpcServer) RedriveDeadLetter(ctx context.Context, req *contracts.RedriveDeadLetterRequest) (*contracts.RedriveDeadLetterResponse, error) {
+	deadLetter, err := g.Config.DeadLetters.Redrive(req.Id, func(record *contracts.Record) (uint64, error) {
+		records := []*contracts.Record{record}
+
+		err := g.compress(records, contracts.Compression_COMPRESSION_UNSPECIFIED)
 		if err != nil {
-			return err
+			return 0, err
 		}
 
-		err = stream.Send(res)
+		indexes, err := g.Config.CommitLog.AppendBatch(records)
 		if err != nil {
-			return err
+			return 0, err
 		}
+		return indexes[0], nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &contracts.RedriveDeadLetterResponse{DeadLetter: deadLetter}, nil
+}
+
+// Consume reads the record at req.Index. With read_committed it sees what
+// streams do: records from an open transaction, or after one's first
+// record, aren't committed yet, and transaction markers and aborted records
+// are hidden.
+func (g *grpcServer) Consume(ctx context.Context, req *contracts.ConsumeRequest) (*contracts.ConsumeResponse, error) {
+	res, err := g.read(req)
+	if err != nil {
+		return nil, err
 	}
+
+	if !req.ReadCommitted {
+		return res, nil
+	}
+
+	if stable, _ := g.txns.stable(req.Index); !stable {
+		return nil, contracts.ErrNotCommitted{Index: req.Index}
+	}
+
+	if g.txns.hidden(res.Record, true) {
+		return nil, contracts.ErrHiddenRecord{Index: req.Index}
+	}
+
+	return res, nil
+}
+
+// read reads the record at req.Index, decompressed unless the caller
+// accepts its codec.
+func (g *grpcServer) read(req *contracts.ConsumeRequest) (*contracts.ConsumeResponse, error) {
+	record, err := g.Config.CommitLog.Read(req.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	err = decompress(record, req.AcceptCompression)
+	if err != nil {
+		return nil, err
+	}
+	return &contracts.ConsumeResponse{Record: record}, nil
+}
+
+func (g *grpcServer) Offsets(ctx context.Context, req *contracts.OffsetsRequest) (*contracts.OffsetsResponse, error) {
+	return &contracts.OffsetsResponse{
+		FirstIndex: g.Config.CommitLog.FirstIndex(),
+		NextIndex:  g.Config.CommitLog.NextIndex(),
+	}, nil
 }
 
 func (g *grpcServer) ConsumeStream(req *contracts.ConsumeRequest, stream contracts.Endpoints_ConsumeStreamServer) error {
+	release, err := g.openStream()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return g.consume(req, stream, nil)
+}
+
+// responseStream is what ConsumeStream and Subscribe send responses over.
+type responseStream interface {
+	Context() context.Context
+	Send(*contracts.ConsumeResponse) error
+}
+
+// consume sends records from req.Index on until the stream ends. When
+// acquire is set, it's called before each record is sent and blocks until
+// the record may be.
+func (g *grpcServer) consume(req *contracts.ConsumeRequest, stream responseStream, acquire func(*contracts.Record) error) error {
+	match, err := g.filters.compile(req.Filter)
+	if err != nil {
+		return err
+	}
+
+	progress := &progress{enabled: match != nil}
+	progress.sent(req.Index)
+
 	for {
 		select {
 		case <-stream.Context().Done():
 			return nil
+		case <-g.Config.Health.Draining():
+			return contracts.ErrShuttingDown{NextIndex: req.Index}
 		default:
-			res, err := g.Consume(stream.Context(), req)
+			res, err := g.read(req)
 			switch err.(type) {
 			case nil:
 			case contracts.ErrIndexOutOfRange:
+				err = progress.report(stream, req.Index, true)
+				if err != nil {
+					return err
+				}
+
+				select {
+				case <-g.Config.CommitLog.Appended(req.Index):
+				case <-stream.Context().Done():
+				case <-g.Config.Health.Draining():
+				}
 				continue
 			default:
 				return err
 			}
 
+			if req.ReadCommitted {
+				if stable, changed := g.txns.stable(req.Index); !stable {
+					err = progress.report(stream, req.Index, true)
+					if err != nil {
+						return err
+					}
+
+					select {
+					case <-changed:
+					case <-stream.Context().Done():
+					case <-g.Config.Health.Draining():
+					}
+					continue
+				}
+			}
+
+			skip := g.txns.hidden(res.Record, req.ReadCommitted) || g.Config.DeadLetters.Skipped(req.Consumer, res.Record.Index)
+
+			if !skip && match != nil {
+				record, err := decompressed(res.Record)
+				if err != nil {
+					return err
+				}
+
+				matched, err := match(record)
+				if err != nil {
+					return err
+				}
+				skip = !matched
+			}
+
+			if skip {
+				req.Index++
+
+				err = progress.report(stream, req.Index, false)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			if acquire != nil {
+				err = acquire(res.Record)
+				if stream.Context().Err() != nil {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+			}
+
 			err = stream.Send(res)
 			if err != nil {
 				return err
 			}
 
 			req.Index++
+			progress.sent(req.Index)
 		}
 	}
 }
@@ -1,21 +1,38 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"math"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"github.com/w-h-a/grpc-server/pkg/compression"
 	"github.com/w-h-a/grpc-server/pkg/log"
 	"go.opencensus.io/examples/exporter"
 	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	healthsrv "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var debug = flag.Bool("debug", false, "Enable observability for debugging.")
@@ -40,10 +57,15 @@ func TestServer(t *testing.T) {
 	tests["consume beyond range"] = testConsumeBeyondRange
 	tests["produce and consume requests"] = testProduceConsume
 	tests["produce and consume stream"] = testProduceConsumeStream
+	tests["consume stream waits for appends"] = testConsumeStreamWaits
+	tests["produce batch"] = testProduceBatch
+	tests["offsets"] = testOffsets
+	tests["produce at expected index"] = testProduceExpectedIndex
+	tests["pipelined produce stream"] = testProduceStreamPipelined
 
 	for situation, fn := range tests {
 		t.Run(situation, func(t *testing.T) {
-			client, teardown := setupTest(t)
+			client, teardown := setupTest(t, nil)
 
 			defer teardown()
 
@@ -52,7 +74,7 @@ func TestServer(t *testing.T) {
 	}
 }
 
-func setupTest(t *testing.T) (client contracts.EndpointsClient, teardown func()) {
+func setupTest(t *testing.T, fn func(*Config)) (client contracts.EndpointsClient, teardown func()) {
 	// setup log
 	log, err := log.NewLog()
 	require.NoError(t, err)
@@ -83,6 +105,9 @@ func setupTest(t *testing.T) (client contracts.EndpointsClient, teardown func())
 
 	// setup server
 	cfg := &Config{CommitLog: log}
+	if fn != nil {
+		fn(cfg)
+	}
 
 	server, err := NewGRPCServer(cfg)
 	require.NoError(t, err)
@@ -130,6 +155,9 @@ func testConsumeBeyondRange(t *testing.T, client contracts.EndpointsClient) {
 	got := status.Code(err)
 	want := status.Code(contracts.ErrIndexOutOfRange{}.GRPCStatus().Err())
 	require.Equal(t, want, got)
+	require.Equal(t, codes.OutOfRange, got)
+
+	require.Equal(t, contracts.ErrIndexOutOfRange{Index: produceResponse.Index + 1}, contracts.FromError(err))
 }
 
 func testProduceConsume(t *testing.T, client contracts.EndpointsClient) {
@@ -185,3 +213,1537 @@ func testProduceConsumeStream(t *testing.T, client contracts.EndpointsClient) {
 		}
 	}
 }
+
+func testConsumeStreamWaits(t *testing.T, client contracts.EndpointsClient) {
+	ctx := context.Background()
+
+	consumeStream, err := client.ConsumeStream(ctx, &contracts.ConsumeRequest{Index: 0})
+	require.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+
+	_, err = client.Produce(ctx, &contracts.ProduceRequest{Record: &contracts.Record{Value: "late"}})
+	require.NoError(t, err)
+
+	response, err := consumeStream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, "late", response.Record.Value)
+}
+
+func testProduceStreamPipelined(t *testing.T, client contracts.EndpointsClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := client.ProduceStream(ctx)
+	require.NoError(t, err)
+
+	// everything is sent before any acknowledgement is read, with an
+	// expected index in the middle that breaks the group up
+	for i := 0; i < 200; i++ {
+		req := &contracts.ProduceRequest{Record: &contracts.Record{Value: fmt.Sprintf("record %d", i)}, RequestId: uint64(1000 + i)}
+		if i == 100 {
+			expected := uint64(100)
+			req.ExpectedIndex = &expected
+		}
+		require.NoError(t, stream.Send(req))
+	}
+	require.NoError(t, stream.Send(&contracts.ProduceRequest{Record: &contracts.Record{}, RequestId: 2000}))
+
+	for i := 0; i < 200; i++ {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), res.Index)
+		require.Equal(t, uint64(1000+i), res.RequestId)
+	}
+
+	// the invalid request is answered with its error, and the stream carries on
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2000), res.RequestId)
+	require.Equal(t, codes.InvalidArgument, status.Code(res.Error.Err()))
+
+	var invalid contracts.ErrInvalidRecord
+	require.ErrorAs(t, res.Error.Err(), &invalid)
+
+	require.NoError(t, stream.Send(&contracts.ProduceRequest{Record: &contracts.Record{Value: "after"}, RequestId: 2001}))
+
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	require.Nil(t, res.Error)
+	require.Equal(t, uint64(200), res.Index)
+	require.Equal(t, uint64(2001), res.RequestId)
+
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+
+	offsets, err := client.Offsets(ctx, &contracts.OffsetsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(201), offsets.NextIndex)
+}
+
+func testProduceBatch(t *testing.T, client contracts.EndpointsClient) {
+	ctx := context.Background()
+	produceResponse, err := client.ProduceBatch(ctx, &contracts.ProduceBatchRequest{
+		Records: []*contracts.Record{{Value: "first message"}, {Value: "second message"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 1}, produceResponse.Indexes)
+
+	consumeResponse, err := client.Consume(ctx, &contracts.ConsumeRequest{Index: 1})
+	require.NoError(t, err)
+	require.Equal(t, "second message", consumeResponse.Record.Value)
+}
+
+func testOffsets(t *testing.T, client contracts.EndpointsClient) {
+	ctx := context.Background()
+
+	offsets, err := client.Offsets(ctx, &contracts.OffsetsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offsets.NextIndex)
+
+	_, err = client.Produce(ctx, &contracts.ProduceRequest{Record: &contracts.Record{Value: "hello world"}})
+	require.NoError(t, err)
+
+	offsets, err = client.Offsets(ctx, &contracts.OffsetsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offsets.FirstIndex)
+	require.Equal(t, uint64(1), offsets.NextIndex)
+}
+
+func testProduceExpectedIndex(t *testing.T, client contracts.EndpointsClient) {
+	ctx := context.Background()
+
+	expected := uint64(0)
+
+	res, err := client.Produce(ctx, &contracts.ProduceRequest{Record: &contracts.Record{Value: "created"}, ExpectedIndex: &expected})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), res.Index)
+
+	_, err = client.ProduceBatch(ctx, &contracts.ProduceBatchRequest{
+		Records:       []*contracts.Record{{Value: "renamed"}, {Value: "deleted"}},
+		ExpectedIndex: &expected,
+	})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	require.Equal(t, contracts.ErrUnexpectedIndex{ExpectedIndex: 0, NextIndex: 1}, contracts.FromError(err))
+
+	expected = 1
+
+	batch, err := client.ProduceBatch(ctx, &contracts.ProduceBatchRequest{
+		Records:       []*contracts.Record{{Value: "renamed"}, {Value: "deleted"}},
+		ExpectedIndex: &expected,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 2}, batch.Indexes)
+}
+
+func TestAuthentication(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	err := os.WriteFile(path, []byte("# token principal\nsecret producer\n"), 0600)
+	require.NoError(t, err)
+
+	tokens, err := LoadTokens(path)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"secret": "producer"}, tokens)
+
+	client, teardown := setupTest(t, func(cfg *Config) {
+		cfg.Tokens = tokens
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	req := &contracts.ProduceRequest{Record: &contracts.Record{Value: "hello world"}}
+
+	_, err = client.Produce(ctx, req)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.Produce(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer wrong"), req)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.Produce(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret"), req)
+	require.NoError(t, err)
+}
+
+func TestValidation(t *testing.T) {
+	client, teardown := setupTest(t, func(cfg *Config) {
+		cfg.MaxRecordSize = 8
+		cfg.MaxBatchSize = 12
+	})
+	defer teardown()
+
+	ctx := context.Background()
+
+	requireViolations := func(err error, fields ...string) {
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		var got []string
+		for _, detail := range status.Convert(err).Details() {
+			if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+				for _, violation := range badRequest.FieldViolations {
+					got = append(got, violation.Field)
+				}
+			}
+		}
+		require.Equal(t, fields, got)
+	}
+
+	_, err := client.Produce(ctx, &contracts.ProduceRequest{})
+	requireViolations(err, "record")
+
+	invalid, ok := contracts.FromError(err).(contracts.ErrInvalidRecord)
+	require.True(t, ok)
+	require.Len(t, invalid.Violations, 1)
+
+	_, err = client.Produce(ctx, &contracts.ProduceRequest{Record: &contracts.Record{}})
+	requireViolations(err, "record.value")
+
+	_, err = client.Produce(ctx, &contracts.ProduceRequest{Record: &contracts.Record{Value: "too long by far"}})
+	requireViolations(err, "record.value")
+
+	_, err = client.ProduceBatch(ctx, &contracts.ProduceBatchRequest{
+		Records: []*contracts.Record{{Value: "eight ch"}, {Value: "eight ch"}},
+	})
+	requireViolations(err, "records")
+
+	_, err = client.ProduceBatch(ctx, &contracts.ProduceBatchRequest{
+		Records: []*contracts.Record{{Value: "ok"}, {}},
+	})
+	requireViolations(err, "records[1].value")
+
+	produceResponse, err := client.Produce(ctx, &contracts.ProduceRequest{Record: &contracts.Record{Value: "fits"}})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), produceResponse.Index)
+
+	_, err = client.Produce(ctx, &contracts.ProduceRequest{Record: &contracts.Record{Value: strings.Repeat("x", messageOverhead*2)}})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestHealth(t *testing.T) {
+	var failing atomic.Bool
+	health := NewHealth(10*time.Millisecond, HealthCheckFunc("toggle", func(ctx context.Context) error {
+		if failing.Load() {
+			return errors.New("failing")
+		}
+		return nil
+	}))
+	defer health.Close()
+
+	requireStatus := func(want healthsrv.HealthCheckResponse_ServingStatus) {
+		require.Eventually(t, func() bool {
+			for _, service := range []string{"", "record.v1.Endpoints"} {
+				res, err := health.server.Check(context.Background(), &healthsrv.HealthCheckRequest{Service: service})
+				if err != nil || res.Status != want {
+					return false
+				}
+			}
+			return true
+		}, time.Second, 5*time.Millisecond)
+	}
+
+	requireStatus(healthsrv.HealthCheckResponse_NOT_SERVING)
+
+	health.SetReady(true)
+	requireStatus(healthsrv.HealthCheckResponse_SERVING)
+
+	failing.Store(true)
+	requireStatus(healthsrv.HealthCheckResponse_NOT_SERVING)
+
+	failing.Store(false)
+	requireStatus(healthsrv.HealthCheckResponse_SERVING)
+
+	health.Drain()
+	requireStatus(healthsrv.HealthCheckResponse_NOT_SERVING)
+
+	// checks have run once by the time it can be marked ready
+	failed := NewHealth(time.Hour, HealthCheckFunc("failing", func(ctx context.Context) error {
+		return errors.New("failing")
+	}))
+	defer failed.Close()
+
+	failed.SetReady(true)
+	res, err := failed.server.Check(context.Background(), &healthsrv.HealthCheckRequest{Service: "record.v1.Endpoints"})
+	require.NoError(t, err)
+	require.Equal(t, healthsrv.HealthCheckResponse_NOT_SERVING, res.Status)
+
+	dir := t.TempDir()
+	require.NoError(t, (&WritableDirCheck{Dir: dir}).Check(context.Background()))
+	require.NoError(t, (&DiskSpaceCheck{Dir: dir, MinFreeBytes: 1}).Check(context.Background()))
+	require.Error(t, (&DiskSpaceCheck{Dir: dir, MinFreeBytes: math.MaxUint64}).Check(context.Background()))
+	require.Error(t, (&LagCheck{Lag: func() time.Duration { return time.Minute }, MaxLag: time.Second}).Check(context.Background()))
+}
+
+func TestRateLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.yaml")
+	err := os.WriteFile(path, []byte(`
+default:
+  produce:
+    records_per_second: 2
+  consume:
+    records_per_second: 1
+identities:
+  trusted:
+    produce:
+      records_per_second: 1000
+  10.0.0.12:
+    produce:
+      records_per_second: 10
+`), 0600)
+	require.NoError(t, err)
+
+	limits, err := LoadRateLimits(path)
+	require.NoError(t, err)
+	require.Equal(t, float64(1000), limits.policyFor("trusted").Produce.RecordsPerSecond)
+	require.Equal(t, float64(10), limits.policyFor("10.0.0.12").Produce.RecordsPerSecond)
+	require.Equal(t, float64(2), limits.policyFor("127.0.0.1").Produce.RecordsPerSecond)
+
+	client, teardown := setupTest(t, func(cfg *Config) {
+		cfg.RateLimits = limits
+	})
+	defer teardown()
+
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		_, err := client.Produce(ctx, &contracts.ProduceRequest{Record: &contracts.Record{Value: "hello world"}})
+		require.NoError(t, err)
+	}
+
+	_, err = client.Produce(ctx, &contracts.ProduceRequest{Record: &contracts.Record{Value: "hello world"}})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	overloaded, ok := contracts.FromError(err).(contracts.ErrOverloaded)
+	require.True(t, ok)
+	require.True(t, overloaded.RetryAfter > 0)
+
+	// produce requests over the limit are answered, and the stream carries on
+	produceStream, err := client.ProduceStream(ctx)
+	require.NoError(t, err)
+
+	for id := uint64(1); id <= 3; id++ {
+		require.NoError(t, produceStream.Send(&contracts.ProduceRequest{Record: &contracts.Record{Value: "hello world"}, RequestId: id}))
+	}
+
+	rejected := 0
+	for i := 0; i < 3; i++ {
+		res, err := produceStream.Recv()
+		require.NoError(t, err)
+		if res.Error != nil {
+			_, ok := contracts.FromError(res.Error.Err()).(contracts.ErrOverloaded)
+			require.True(t, ok)
+			rejected++
+		}
+	}
+	require.Greater(t, rejected, 0)
+
+	time.Sleep(600 * time.Millisecond)
+
+	require.NoError(t, produceStream.Send(&contracts.ProduceRequest{Record: &contracts.Record{Value: "hello world"}, RequestId: 4}))
+	res, err := produceStream.Recv()
+	require.NoError(t, err)
+	require.Nil(t, res.Error)
+	require.Equal(t, uint64(4), res.RequestId)
+	require.NoError(t, produceStream.CloseSend())
+
+	consumeStream, err := client.ConsumeStream(ctx, &contracts.ConsumeRequest{Index: 0})
+	require.NoError(t, err)
+
+	_, err = consumeStream.Recv()
+	require.NoError(t, err)
+
+	// streams are throttled rather than ended
+	start := time.Now()
+	_, err = consumeStream.Recv()
+	require.NoError(t, err)
+	require.Greater(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestRateLimiterEviction(t *testing.T) {
+	limiter := newRateLimiter(&RateLimits{Default: RateLimitPolicy{Consume: RateLimit{BytesPerSecond: 1}}})
+	limiter.idleTimeout = 10 * time.Millisecond
+
+	for i := 0; i < 100; i++ {
+		limiter.buckets(fmt.Sprint("10.0.0.", i))
+	}
+
+	// an identity in debt is kept until it's paid off
+	_, consume := limiter.buckets("in debt")
+	consume.charge(time.Now(), 1000)
+
+	time.Sleep(20 * time.Millisecond)
+	limiter.buckets("new")
+
+	require.Len(t, limiter.identities, 2)
+	require.Contains(t, limiter.identities, "in debt")
+}
+
+func TestIdempotentProducers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "producers.json")
+
+	producers, err := LoadProducers(path, 0)
+	require.NoError(t, err)
+
+	client, teardown := setupTest(t, func(cfg *Config) {
+		cfg.Producers = producers
+	})
+	defer teardown()
+
+	ctx := context.Background()
+
+	producer, err := client.InitProducer(ctx, &contracts.InitProducerRequest{Name: "orders"})
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), producer.ProducerEpoch)
+
+	batch := &contracts.ProduceBatchRequest{
+		Records:       []*contracts.Record{{Value: "first"}, {Value: "second"}},
+		ProducerId:    producer.ProducerId,
+		ProducerEpoch: producer.ProducerEpoch,
+	}
+
+	res, err := client.ProduceBatch(ctx, batch)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 1}, res.Indexes)
+	require.False(t, res.Duplicate)
+
+	// a retry of the same batch is acknowledged without appending again
+	res, err = client.ProduceBatch(ctx, batch)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 1}, res.Indexes)
+	require.True(t, res.Duplicate)
+
+	single := &contracts.ProduceRequest{
+		Record:        &contracts.Record{Value: "third"},
+		ProducerId:    producer.ProducerId,
+		ProducerEpoch: producer.ProducerEpoch,
+		Sequence:      2,
+	}
+
+	for i := 0; i < 2; i++ {
+		res, err := client.Produce(ctx, single)
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), res.Index)
+		require.Equal(t, i > 0, res.Duplicate)
+	}
+
+	offsets, err := client.Offsets(ctx, &contracts.OffsetsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), offsets.NextIndex)
+
+	single.Sequence = 5
+	_, err = client.Produce(ctx, single)
+	require.Equal(t, contracts.ErrOutOfOrderSequence{ProducerID: producer.ProducerId, Sequence: 5, ExpectedSequence: 3}, contracts.FromError(err))
+
+	single.ProducerId = 42
+	_, err = client.Produce(ctx, single)
+	require.Equal(t, contracts.ErrUnknownProducer{ProducerID: 42}, contracts.FromError(err))
+
+	// a new instance with the same name fences off the old one
+	newer, err := client.InitProducer(ctx, &contracts.InitProducerRequest{Name: "orders"})
+	require.NoError(t, err)
+	require.Equal(t, producer.ProducerId, newer.ProducerId)
+	require.Equal(t, uint32(1), newer.ProducerEpoch)
+
+	single.ProducerId = producer.ProducerId
+	single.Sequence = 3
+	_, err = client.Produce(ctx, single)
+	require.Equal(t, contracts.ErrProducerFenced{ProducerID: producer.ProducerId, Epoch: 0}, contracts.FromError(err))
+
+	// anonymous producers get ids of their own
+	anonymous, err := client.InitProducer(ctx, &contracts.InitProducerRequest{})
+	require.NoError(t, err)
+	require.NotEqual(t, producer.ProducerId, anonymous.ProducerId)
+
+	lost, err := client.Produce(ctx, &contracts.ProduceRequest{Record: &contracts.Record{Value: "lost"}, ProducerId: anonymous.ProducerId})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), lost.Index)
+
+	// ids, epochs and sequences survive a restart, but not batches past the
+	// end of the log
+	require.NoError(t, producers.Close())
+
+	restored, err := LoadProducers(path, 3)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	_, _, err = restored.Append(anonymous.ProducerId, 0, 0, 1, nil)
+	require.Equal(t, contracts.ErrOutOfOrderSequence{ProducerID: anonymous.ProducerId, Sequence: 0, ExpectedSequence: 1}, err)
+
+	_, _, err = restored.Append(producer.ProducerId, 0, 3, 1, nil)
+	require.Equal(t, contracts.ErrProducerFenced{ProducerID: producer.ProducerId, Epoch: 0}, err)
+
+	indexes, duplicate, err := restored.Append(producer.ProducerId, 1, 0, 1, func() ([]uint64, error) {
+		return []uint64{3}, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{3}, indexes)
+	require.False(t, duplicate)
+
+	id, epoch, err := restored.Init("orders")
+	require.NoError(t, err)
+	require.Equal(t, producer.ProducerId, id)
+	require.Equal(t, uint32(2), epoch)
+
+	id, _, err = restored.Init("")
+	require.NoError(t, err)
+	require.Greater(t, id, anonymous.ProducerId)
+
+	// the journal is compacted as it grows, not only on load
+	for sequence := uint64(0); sequence < 2*journalCompactEvery; sequence++ {
+		_, _, err = restored.Append(producer.ProducerId, 2, sequence, 1, func() ([]uint64, error) {
+			return []uint64{4 + sequence}, nil
+		})
+		require.NoError(t, err)
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Less(t, bytes.Count(data, []byte("\n")), 2*journalCompactEvery)
+}
+
+func TestTransactions(t *testing.T) {
+	client, teardown := setupTest(t, func(cfg *Config) {
+		cfg.TransactionTimeout = 200 * time.Millisecond
+	})
+	defer teardown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	subscribe := func(readCommitted bool) <-chan string {
+		stream, err := client.ConsumeStream(ctx, &contracts.ConsumeRequest{ReadCommitted: readCommitted})
+		require.NoError(t, err)
+
+		values := make(chan string, 16)
+		go func() {
+			for {
+				res, err := stream.Recv()
+				if err != nil {
+					return
+				}
+				values <- res.Record.Value
+			}
+		}()
+		return values
+	}
+
+	expect := func(values <-chan string, want ...string) {
+		for _, value := range want {
+			select {
+			case got := <-values:
+				require.Equal(t, value, got)
+			case <-time.After(2 * time.Second):
+				t.Fatalf("timed out waiting for %q", value)
+			}
+		}
+
+		select {
+		case got := <-values:
+			t.Fatalf("unexpected record %q", got)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	produce := func(value string) {
+		_, err := client.Produce(ctx, &contracts.ProduceRequest{Record: &contracts.Record{Value: value}})
+		require.NoError(t, err)
+	}
+
+	uncommitted := subscribe(false)
+	committed := subscribe(true)
+
+	produce("before")
+
+	txn, err := client.BeginTxn(ctx, &contracts.BeginTxnRequest{})
+	require.NoError(t, err)
+
+	added, err := client.AddRecords(ctx, &contracts.AddRecordsRequest{
+		TxnId:   txn.TxnId,
+		Records: []*contracts.Record{{Value: "first"}, {Value: "second"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 2}, added.Indexes)
+
+	_, err = client.Produce(ctx, &contracts.ProduceRequest{Record: &contracts.Record{Value: "third"}, TxnId: txn.TxnId})
+	require.NoError(t, err)
+
+	produce("outside")
+
+	// read_committed consumers wait at the first record of the open transaction
+	expect(uncommitted, "before", "first", "second", "third", "outside")
+	expect(committed, "before")
+
+	// and so do read_committed reads of single records
+	_, err = client.Consume(ctx, &contracts.ConsumeRequest{Index: 0, ReadCommitted: true})
+	require.NoError(t, err)
+	_, err = client.Consume(ctx, &contracts.ConsumeRequest{Index: 4, ReadCommitted: true})
+	require.Equal(t, contracts.ErrNotCommitted{Index: 4}, contracts.FromError(err))
+	_, err = client.Consume(ctx, &contracts.ConsumeRequest{Index: 1})
+	require.NoError(t, err)
+
+	// only committed records can be dead-lettered
+	_, err = client.ReportFailure(ctx, &contracts.ReportFailureRequest{Consumer: "billing", Index: 1})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	commit, err := client.CommitTxn(ctx, &contracts.CommitTxnRequest{TxnId: txn.TxnId})
+	require.NoError(t, err)
+
+	marker, err := client.Consume(ctx, &contracts.ConsumeRequest{Index: commit.Index})
+	require.NoError(t, err)
+	require.Equal(t, contracts.Control_CONTROL_COMMIT, marker.Record.GetControl())
+	require.Equal(t, txn.TxnId, marker.Record.GetTxnId())
+
+	_, err = client.Consume(ctx, &contracts.ConsumeRequest{Index: commit.Index, ReadCommitted: true})
+	require.Equal(t, contracts.ErrHiddenRecord{Index: commit.Index}, contracts.FromError(err))
+
+	expect(committed, "first", "second", "third", "outside")
+
+	// aborted transactions are skipped by read_committed consumers
+	aborted, err := client.BeginTxn(ctx, &contracts.BeginTxnRequest{})
+	require.NoError(t, err)
+
+	_, err = client.ProduceBatch(ctx, &contracts.ProduceBatchRequest{TxnId: aborted.TxnId, ExpectedIndex: proto.Uint64(0), Records: []*contracts.Record{{Value: "aborted"}}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	abortedBatch, err := client.ProduceBatch(ctx, &contracts.ProduceBatchRequest{TxnId: aborted.TxnId, Records: []*contracts.Record{{Value: "aborted"}}})
+	require.NoError(t, err)
+
+	_, err = client.AbortTxn(ctx, &contracts.AbortTxnRequest{TxnId: aborted.TxnId})
+	require.NoError(t, err)
+
+	abortedIndex := abortedBatch.Indexes[0]
+
+	_, err = client.Consume(ctx, &contracts.ConsumeRequest{Index: abortedIndex, ReadCommitted: true})
+	require.Equal(t, contracts.ErrHiddenRecord{Index: abortedIndex}, contracts.FromError(err))
+
+	_, err = client.ReportFailure(ctx, &contracts.ReportFailureRequest{Consumer: "billing", Index: abortedIndex})
+	require.Equal(t, contracts.ErrHiddenRecord{Index: abortedIndex}, contracts.FromError(err))
+
+	_, err = client.CommitTxn(ctx, &contracts.CommitTxnRequest{TxnId: aborted.TxnId})
+	require.Equal(t, contracts.ErrTxnNotOpen{TxnID: aborted.TxnId}, contracts.FromError(err))
+
+	// transactions that go quiet are aborted
+	abandoned, err := client.BeginTxn(ctx, &contracts.BeginTxnRequest{})
+	require.NoError(t, err)
+
+	_, err = client.AddRecords(ctx, &contracts.AddRecordsRequest{TxnId: abandoned.TxnId, Records: []*contracts.Record{{Value: "abandoned"}}})
+	require.NoError(t, err)
+
+	time.Sleep(400 * time.Millisecond)
+
+	_, err = client.AddRecords(ctx, &contracts.AddRecordsRequest{TxnId: abandoned.TxnId, Records: []*contracts.Record{{Value: "late"}}})
+	require.Equal(t, contracts.ErrTxnNotOpen{TxnID: abandoned.TxnId}, contracts.FromError(err))
+
+	produce("after")
+
+	expect(uncommitted, "aborted", "abandoned", "after")
+	expect(committed, "after")
+
+	// clients can't forge markers
+	_, err = client.Produce(ctx, &contracts.ProduceRequest{Record: &contracts.Record{
+		Value:   "forged",
+		TxnId:   &txn.TxnId,
+		Control: contracts.Control_CONTROL_ABORT.Enum(),
+	}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestAbortedTransactionsPruned(t *testing.T) {
+	l, err := log.OpenLog(log.Config{MaxRecords: 1})
+	require.NoError(t, err)
+
+	txns := newTransactions(l, time.Minute)
+
+	abort := func() uint64 {
+		id := txns.begin()
+		_, err := txns.add(id, []*contracts.Record{{Value: "aborted"}})
+		require.NoError(t, err)
+		_, err = txns.end(id, contracts.Control_CONTROL_ABORT)
+		require.NoError(t, err)
+		return id
+	}
+
+	first := abort()
+	second := abort()
+
+	// fill the first chunk, so the next append drops it and both markers
+	for l.NextIndex() <= 4096 {
+		_, err := l.Append(&contracts.Record{Value: "committed"})
+		require.NoError(t, err)
+	}
+
+	third := abort()
+
+	require.Equal(t, uint64(4096), l.FirstIndex())
+	require.False(t, txns.isAborted(first))
+	require.False(t, txns.isAborted(second))
+	require.True(t, txns.isAborted(third))
+}
+
+func TestFilters(t *testing.T) {
+	client, teardown := setupTest(t, nil)
+	defer teardown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := client.ProduceBatch(ctx, &contracts.ProduceBatchRequest{Records: []*contracts.Record{
+		{Value: "order:1 created"},
+		{Value: "user:7 signed up"},
+		{Value: "order:2 created"},
+		{Value: "order:1 shipped"},
+		{Value: "user:8 signed up"},
+		{Value: "audit"},
+	}})
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		filter *contracts.Filter
+		want   []uint64
+	}{
+		"prefix": {
+			filter: &contracts.Filter{Expression: &contracts.Filter_ValuePrefix{ValuePrefix: "user:"}},
+			want:   []uint64{1, 4},
+		},
+		"regex": {
+			filter: &contracts.Filter{Expression: &contracts.Filter_ValueRegex{ValueRegex: `^order:\d+ created$`}},
+			want:   []uint64{0, 2},
+		},
+		"cel": {
+			filter: &contracts.Filter{Expression: &contracts.Filter_Cel{Cel: `value.endsWith("shipped") || index == 2u`}},
+			want:   []uint64{2, 3},
+		},
+	}
+
+	for situation, test := range tests {
+		t.Run(situation, func(t *testing.T) {
+			stream, err := client.ConsumeStream(ctx, &contracts.ConsumeRequest{Filter: test.filter})
+			require.NoError(t, err)
+
+			for _, index := range test.want {
+				res, err := stream.Recv()
+				require.NoError(t, err)
+				require.Equal(t, index, res.Record.Index)
+			}
+
+			// caught up, the stream reports it has looked at every record
+			res, err := stream.Recv()
+			require.NoError(t, err)
+			require.Nil(t, res.Record)
+			require.Equal(t, uint64(6), res.GetProgressIndex())
+		})
+	}
+
+	invalid := map[string]*contracts.Filter{
+		"filter":             {},
+		"filter.value_regex": {Expression: &contracts.Filter_ValueRegex{ValueRegex: "("}},
+		"filter.cel":         {Expression: &contracts.Filter_Cel{Cel: `value + "x"`}},
+	}
+
+	for field, filter := range invalid {
+		stream, err := client.ConsumeStream(ctx, &contracts.ConsumeRequest{Filter: filter})
+		require.NoError(t, err)
+
+		_, err = stream.Recv()
+		filterErr, ok := contracts.FromError(err).(contracts.ErrInvalidFilter)
+		require.True(t, ok, field)
+		require.Equal(t, field, filterErr.Field)
+	}
+
+	// expensive expressions are stopped by the cost limit
+	stream, err := client.ConsumeStream(ctx, &contracts.ConsumeRequest{Filter: &contracts.Filter{
+		Expression: &contracts.Filter_Cel{Cel: `[1,2,3,4,5,6,7,8,9,10].all(a, [1,2,3,4,5,6,7,8,9,10].all(b, [1,2,3,4,5,6,7,8,9,10].all(c, [1,2,3,4,5,6,7,8,9,10].all(d, a + b + c + d > 0))))`},
+	}})
+	require.NoError(t, err)
+
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Contains(t, status.Convert(err).Message(), "cost")
+}
+
+func TestSubscribe(t *testing.T) {
+	client, teardown := setupTest(t, func(cfg *Config) {
+		cfg.MaxConsumeStreams = 2
+	})
+	defer teardown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := client.ProduceBatch(ctx, &contracts.ProduceBatchRequest{Records: []*contracts.Record{
+		{Value: "a"},
+		{Value: "bb"},
+		{Value: "ccc"},
+		{Value: "dddd"},
+	}})
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		first *contracts.SubscribeRequest
+		grant *contracts.SubscribeRequest
+		// how many records arrive before and after the grant
+		before, after int
+	}{
+		"records": {
+			first:  &contracts.SubscribeRequest{CreditRecords: 2},
+			grant:  &contracts.SubscribeRequest{CreditRecords: 2},
+			before: 2,
+			after:  2,
+		},
+		"bytes": {
+			// a record is sent while any byte credit remains
+			first:  &contracts.SubscribeRequest{CreditRecords: 10, CreditBytes: 2},
+			grant:  &contracts.SubscribeRequest{CreditBytes: 2},
+			before: 2,
+			after:  1,
+		},
+	}
+
+	for situation, test := range tests {
+		t.Run(situation, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			stream, err := client.Subscribe(ctx)
+			require.NoError(t, err)
+
+			test.first.Consume = &contracts.ConsumeRequest{}
+			require.NoError(t, stream.Send(test.first))
+
+			received := make(chan *contracts.ConsumeResponse)
+			go func() {
+				for {
+					res, err := stream.Recv()
+					if err != nil {
+						return
+					}
+					received <- res
+				}
+			}()
+
+			next := uint64(0)
+			expect := func(n int) {
+				for i := 0; i < n; i++ {
+					select {
+					case res := <-received:
+						require.Equal(t, next, res.Record.Index)
+						next++
+					case <-time.After(time.Second):
+						t.Fatalf("didn't receive record %d", next)
+					}
+				}
+			}
+
+			expect(test.before)
+
+			// out of credits, the server waits
+			select {
+			case res := <-received:
+				t.Fatalf("received record %d without credits", res.Record.Index)
+			case <-time.After(200 * time.Millisecond):
+			}
+
+			require.NoError(t, stream.Send(test.grant))
+
+			expect(test.after)
+
+			select {
+			case res := <-received:
+				t.Fatalf("received record %d without credits", res.Record.Index)
+			case <-time.After(200 * time.Millisecond):
+			}
+		})
+	}
+
+	stream, err := client.Subscribe(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&contracts.SubscribeRequest{CreditRecords: 1}))
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// two streams are open, and the third is refused
+	for i := 0; i < 2; i++ {
+		stream, err := client.ConsumeStream(ctx, &contracts.ConsumeRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.NoError(t, err)
+	}
+
+	stream2, err := client.ConsumeStream(ctx, &contracts.ConsumeRequest{})
+	require.NoError(t, err)
+	_, err = stream2.Recv()
+	_, ok := contracts.FromError(err).(contracts.ErrOverloaded)
+	require.True(t, ok)
+}
+
+func TestSubscribeCompressed(t *testing.T) {
+	client, teardown := setupTest(t, func(cfg *Config) {
+		cfg.Compression = contracts.Compression_COMPRESSION_ZSTD
+	})
+	defer teardown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	value := strings.Repeat(`{"event":"order created","customer":"c-1"}`, 20)
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &contracts.ProduceRequest{Record: &contracts.Record{Value: value}})
+		require.NoError(t, err)
+	}
+
+	stream, err := client.Subscribe(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, stream.Send(&contracts.SubscribeRequest{
+		Consume:       &contracts.ConsumeRequest{AcceptCompression: []contracts.Compression{contracts.Compression_COMPRESSION_ZSTD}},
+		CreditRecords: 10,
+		CreditBytes:   1,
+	}))
+
+	received := make(chan *contracts.ConsumeResponse)
+	go func() {
+		for {
+			res, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			received <- res
+		}
+	}()
+
+	held := func() {
+		select {
+		case res := <-received:
+			t.Fatalf("received record %d without credits", res.Record.Index)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+
+	// byte credit is charged what the record takes compressed, so giving
+	// back that much lets exactly one more record through
+	for index := uint64(0); index < 2; index++ {
+		var res *contracts.ConsumeResponse
+		select {
+		case res = <-received:
+		case <-time.After(time.Second):
+			t.Fatalf("didn't receive record %d", index)
+		}
+
+		require.Equal(t, index, res.Record.Index)
+		require.Empty(t, res.Record.Value)
+		require.NotEmpty(t, res.Record.CompressedValue)
+
+		held()
+
+		require.NoError(t, stream.Send(&contracts.SubscribeRequest{CreditBytes: uint64(len(res.Record.CompressedValue))}))
+	}
+}
+
+func TestCompression(t *testing.T) {
+	client, teardown := setupTest(t, func(cfg *Config) {
+		cfg.Compression = contracts.Compression_COMPRESSION_ZSTD
+	})
+	defer teardown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	value := strings.Repeat(`{"event":"order created","customer":"c-1"}`, 20)
+
+	// the server's default, then the producer's choice, then none at all;
+	// messages themselves are gzipped
+	for _, codec := range []contracts.Compression{
+		contracts.Compression_COMPRESSION_UNSPECIFIED,
+		contracts.Compression_COMPRESSION_LZ4,
+		contracts.Compression_COMPRESSION_NONE,
+	} {
+		_, err := client.Produce(ctx, &contracts.ProduceRequest{Record: &contracts.Record{Value: value}, Compression: codec}, grpc.UseCompressor("gzip"))
+		require.NoError(t, err)
+	}
+
+	tests := map[string]struct {
+		accept []contracts.Compression
+		want   []contracts.Compression
+	}{
+		"decompressed by the server": {
+			want: []contracts.Compression{0, 0, 0},
+		},
+		"passed through": {
+			accept: []contracts.Compression{contracts.Compression_COMPRESSION_ZSTD},
+			want:   []contracts.Compression{contracts.Compression_COMPRESSION_ZSTD, 0, 0},
+		},
+	}
+
+	for situation, test := range tests {
+		t.Run(situation, func(t *testing.T) {
+			for index, want := range test.want {
+				res, err := client.Consume(ctx, &contracts.ConsumeRequest{Index: uint64(index), AcceptCompression: test.accept})
+				require.NoError(t, err)
+				require.Equal(t, want, res.Record.GetCompression())
+
+				if want == 0 {
+					require.Equal(t, value, res.Record.Value)
+					require.Nil(t, res.Record.CompressedValue)
+				} else {
+					require.Empty(t, res.Record.Value)
+					require.Less(t, len(res.Record.CompressedValue), len(value)/5)
+				}
+			}
+		})
+	}
+
+	// filters look at the value, even when records are sent compressed
+	stream, err := client.ConsumeStream(ctx, &contracts.ConsumeRequest{
+		Filter:            &contracts.Filter{Expression: &contracts.Filter_ValuePrefix{ValuePrefix: `{"event":"order created"`}},
+		AcceptCompression: []contracts.Compression{contracts.Compression_COMPRESSION_ZSTD},
+	})
+	require.NoError(t, err)
+
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), res.Record.Index)
+	require.Equal(t, contracts.Compression_COMPRESSION_ZSTD, res.Record.GetCompression())
+
+	_, err = client.Produce(ctx, &contracts.ProduceRequest{Record: &contracts.Record{Value: value}, Compression: 42})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Produce(ctx, &contracts.ProduceRequest{Record: &contracts.Record{Compression: contracts.Compression_COMPRESSION_GZIP.Enum(), CompressedValue: []byte("x")}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestCompressedBatch(t *testing.T) {
+	client, teardown := setupTest(t, func(cfg *Config) {
+		cfg.MaxBatchSize = 1024
+		cfg.RateLimits = &RateLimits{Default: RateLimitPolicy{Produce: RateLimit{RecordsPerSecond: 2}}}
+	})
+	defer teardown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pack := func(codec contracts.Compression, values ...string) []byte {
+		batch := &contracts.RecordBatch{}
+		for _, value := range values {
+			batch.Records = append(batch.Records, &contracts.Record{Value: value})
+		}
+
+		data, err := proto.Marshal(batch)
+		require.NoError(t, err)
+
+		compressed, err := compression.Compress(codec, data)
+		require.NoError(t, err)
+
+		return compressed
+	}
+
+	zstd := contracts.Compression_COMPRESSION_ZSTD
+	value := strings.Repeat(`{"event":"order created"}`, 10)
+
+	res, err := client.ProduceBatch(ctx, &contracts.ProduceBatchRequest{CompressedRecords: pack(zstd, value, value), Compression: zstd})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 1}, res.Indexes)
+
+	// records are stored one at a time, with the batch's codec
+	for _, index := range res.Indexes {
+		consumed, err := client.Consume(ctx, &contracts.ConsumeRequest{Index: index, AcceptCompression: []contracts.Compression{zstd}})
+		require.NoError(t, err)
+		require.Equal(t, zstd, consumed.Record.GetCompression())
+
+		consumed, err = client.Consume(ctx, &contracts.ConsumeRequest{Index: index})
+		require.NoError(t, err)
+		require.Equal(t, value, consumed.Record.Value)
+	}
+
+	// rate limits count the records the batch unpacks to
+	_, err = client.ProduceBatch(ctx, &contracts.ProduceBatchRequest{CompressedRecords: pack(zstd, value), Compression: zstd})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	tests := map[string]*contracts.ProduceBatchRequest{
+		"with records": {
+			Records:           []*contracts.Record{{Value: value}},
+			CompressedRecords: pack(zstd, value),
+			Compression:       zstd,
+		},
+		"corrupt": {
+			CompressedRecords: []byte("not zstd"),
+			Compression:       zstd,
+		},
+		"unpacks too large": {
+			CompressedRecords: pack(zstd, strings.Repeat("x", 1024*1024)),
+			Compression:       zstd,
+		},
+	}
+
+	for situation, req := range tests {
+		t.Run(situation, func(t *testing.T) {
+			_, err := client.ProduceBatch(ctx, req)
+			require.Equal(t, codes.InvalidArgument, status.Code(err))
+		})
+	}
+}
+
+func TestSchemas(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schemas.json")
+
+	schemas, err := LoadSchemas(path, contracts.Compatibility_COMPATIBILITY_BACKWARD)
+	require.NoError(t, err)
+
+	client, teardown := setupTest(t, func(cfg *Config) {
+		cfg.Schemas = schemas
+		cfg.RequireSchemas = true
+		cfg.MaxRecordSize = 64
+	})
+	defer teardown()
+
+	ctx := context.Background()
+
+	v1 := `{"type":"object","properties":{"id":{"type":"string"}},"required":["id"]}`
+	v2 := `{"type":"object","properties":{"id":{"type":"string"},"note":{"type":"string"}},"required":["id"]}`
+
+	registered, err := client.RegisterSchema(ctx, &contracts.RegisterSchemaRequest{Subject: "orders", Type: contracts.SchemaType_SCHEMA_TYPE_JSON, Definition: v1})
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), registered.Schema.Version)
+	first := registered.Schema.Id
+
+	// registering the latest definition again is a no-op
+	registered, err = client.RegisterSchema(ctx, &contracts.RegisterSchemaRequest{Subject: "orders", Type: contracts.SchemaType_SCHEMA_TYPE_JSON, Definition: v1})
+	require.NoError(t, err)
+	require.Equal(t, first, registered.Schema.Id)
+
+	registered, err = client.RegisterSchema(ctx, &contracts.RegisterSchemaRequest{Subject: "orders", Type: contracts.SchemaType_SCHEMA_TYPE_JSON, Definition: v2})
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), registered.Schema.Version)
+	second := registered.Schema.Id
+
+	// a new required property can't read what was written before
+	_, err = client.RegisterSchema(ctx, &contracts.RegisterSchemaRequest{
+		Subject:    "orders",
+		Type:       contracts.SchemaType_SCHEMA_TYPE_JSON,
+		Definition: `{"type":"object","properties":{"id":{"type":"string"}},"required":["id","note"]}`,
+	})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	var incompatible contracts.ErrIncompatibleSchema
+	require.ErrorAs(t, contracts.FromError(err), &incompatible)
+	require.Equal(t, uint32(2), incompatible.Version)
+	require.Equal(t, contracts.Compatibility_COMPATIBILITY_BACKWARD, incompatible.Compatibility)
+
+	_, err = client.RegisterSchema(ctx, &contracts.RegisterSchemaRequest{Subject: "orders", Type: contracts.SchemaType_SCHEMA_TYPE_AVRO, Definition: `{"type":`})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	got, err := client.GetSchema(ctx, &contracts.GetSchemaRequest{Subject: "orders"})
+	require.NoError(t, err)
+	require.Equal(t, second, got.Schema.Id)
+
+	got, err = client.GetSchema(ctx, &contracts.GetSchemaRequest{Id: first})
+	require.NoError(t, err)
+	require.Equal(t, v1, got.Schema.Definition)
+
+	_, err = client.GetSchema(ctx, &contracts.GetSchemaRequest{Subject: "orders", Version: 3})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	tests := map[string]struct {
+		record *contracts.Record
+		field  string
+	}{
+		"conforming":     {record: &contracts.Record{Value: `{"id":"o-1"}`, SchemaId: &first}},
+		"not conforming": {record: &contracts.Record{Value: `{"note":"gift"}`, SchemaId: &second}, field: "record.value"},
+		"unknown schema": {record: &contracts.Record{Value: `{"id":"o-1"}`, SchemaId: proto.Uint32(99)}, field: "record.schema_id"},
+		"without schema": {record: &contracts.Record{Value: `{"id":"o-1"}`}, field: "record.schema_id"},
+		// too large to store, so it isn't parsed against its schema
+		"too large": {record: &contracts.Record{Value: `{"note":"` + strings.Repeat("x", 64) + `"}`, SchemaId: &second}, field: "record.value"},
+	}
+
+	for situation, test := range tests {
+		t.Run(situation, func(t *testing.T) {
+			_, err := client.Produce(ctx, &contracts.ProduceRequest{Record: test.record})
+			if len(test.field) == 0 {
+				require.NoError(t, err)
+				return
+			}
+
+			require.Equal(t, codes.InvalidArgument, status.Code(err))
+			var invalid contracts.ErrInvalidRecord
+			require.ErrorAs(t, contracts.FromError(err), &invalid)
+			require.Len(t, invalid.Violations, 1)
+			require.Equal(t, test.field, invalid.Violations[0].Field)
+		})
+	}
+
+	// batches are checked as well
+	_, err = client.ProduceBatch(ctx, &contracts.ProduceBatchRequest{Records: []*contracts.Record{{Value: `{}`, SchemaId: &first}}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// versions and subject levels survive a restart
+	_, err = client.RegisterSchema(ctx, &contracts.RegisterSchemaRequest{Subject: "orders", Type: contracts.SchemaType_SCHEMA_TYPE_JSON, Definition: v2, Compatibility: contracts.Compatibility_COMPATIBILITY_FULL})
+	require.NoError(t, err)
+	require.NoError(t, schemas.Close())
+
+	restored, err := LoadSchemas(path, contracts.Compatibility_COMPATIBILITY_NONE)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	versions, compatibility, err := restored.List("orders")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, contracts.Compatibility_COMPATIBILITY_FULL, compatibility)
+	require.NoError(t, restored.Validate(first, `{"id":"o-1"}`))
+
+	payments, err := restored.Register("payments", contracts.SchemaType_SCHEMA_TYPE_JSON, v1, 0)
+	require.NoError(t, err)
+	require.Equal(t, second+1, payments.Id)
+}
+
+func TestDeadLetters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead_letters.json")
+
+	deadLetters, err := LoadDeadLetters(path, 2, 0, nil)
+	require.NoError(t, err)
+
+	client, teardown := setupTest(t, func(cfg *Config) {
+		cfg.DeadLetters = deadLetters
+		cfg.Compression = contracts.Compression_COMPRESSION_GZIP
+	})
+	defer teardown()
+
+	ctx := context.Background()
+
+	_, err = client.ProduceBatch(ctx, &contracts.ProduceBatchRequest{Records: []*contracts.Record{{Value: "first"}, {Value: "poison"}, {Value: "third"}}})
+	require.NoError(t, err)
+
+	_, err = client.ReportFailure(ctx, &contracts.ReportFailureRequest{Index: 1, Reason: "boom"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.ReportFailure(ctx, &contracts.ReportFailureRequest{Consumer: "billing", Index: 3, Reason: "boom"})
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+
+	reported, err := client.ReportFailure(ctx, &contracts.ReportFailureRequest{Consumer: "billing", Index: 1, Reason: "boom"})
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), reported.Failures)
+	require.Nil(t, reported.DeadLetter)
+
+	reported, err = client.ReportFailure(ctx, &contracts.ReportFailureRequest{Consumer: "billing", Index: 1, Reason: "unexpected token"})
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), reported.Failures)
+	require.NotNil(t, reported.DeadLetter)
+
+	deadLetter := reported.DeadLetter
+	require.Equal(t, "poison", deadLetter.Record.Value)
+	require.Equal(t, uint64(1), deadLetter.Index)
+	require.Equal(t, "unexpected token", deadLetter.Reason)
+
+	// reporting a dead letter again leaves it as it is
+	reported, err = client.ReportFailure(ctx, &contracts.ReportFailureRequest{Consumer: "billing", Index: 1, Reason: "again"})
+	require.NoError(t, err)
+	require.Equal(t, deadLetter.Id, reported.DeadLetter.Id)
+	require.Equal(t, "unexpected token", reported.DeadLetter.Reason)
+
+	// the consumer skips the record, and other consumers still get it
+	consumeValues := func(consumer string) []string {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		stream, err := client.ConsumeStream(ctx, &contracts.ConsumeRequest{Consumer: consumer})
+		require.NoError(t, err)
+
+		var values []string
+		for len(values) < 2 {
+			res, err := stream.Recv()
+			require.NoError(t, err)
+			if res.Record != nil {
+				values = append(values, res.Record.Value)
+			}
+		}
+		return values
+	}
+
+	require.Equal(t, []string{"first", "third"}, consumeValues("billing"))
+	require.Equal(t, []string{"first", "poison"}, consumeValues("shipping"))
+
+	_, err = client.ReportFailure(ctx, &contracts.ReportFailureRequest{Consumer: "shipping", Index: 2})
+	require.NoError(t, err)
+	reported, err = client.ReportFailure(ctx, &contracts.ReportFailureRequest{Consumer: "shipping", Index: 2})
+	require.NoError(t, err)
+
+	listed, err := client.ListDeadLetters(ctx, &contracts.ListDeadLettersRequest{})
+	require.NoError(t, err)
+	require.Len(t, listed.DeadLetters, 2)
+
+	listed, err = client.ListDeadLetters(ctx, &contracts.ListDeadLettersRequest{Consumer: "shipping"})
+	require.NoError(t, err)
+	require.Len(t, listed.DeadLetters, 1)
+	require.Equal(t, reported.DeadLetter.Id, listed.DeadLetters[0].Id)
+
+	listed, err = client.ListDeadLetters(ctx, &contracts.ListDeadLettersRequest{AfterId: deadLetter.Id, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, listed.DeadLetters, 1)
+	require.Equal(t, "third", listed.DeadLetters[0].Record.Value)
+
+	// a dead letter is re-driven to the end of the log, once
+	redriven, err := client.RedriveDeadLetter(ctx, &contracts.RedriveDeadLetterRequest{Id: deadLetter.Id})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), redriven.DeadLetter.GetRedrivenIndex())
+
+	redriven, err = client.RedriveDeadLetter(ctx, &contracts.RedriveDeadLetterRequest{Id: deadLetter.Id})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), redriven.DeadLetter.GetRedrivenIndex())
+
+	consumed, err := client.Consume(ctx, &contracts.ConsumeRequest{Index: 3})
+	require.NoError(t, err)
+	require.Equal(t, "poison", consumed.Record.Value)
+
+	offsets, err := client.Offsets(ctx, &contracts.OffsetsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), offsets.NextIndex)
+
+	_, err = client.GetDeadLetter(ctx, &contracts.GetDeadLetterRequest{Id: 99})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	require.Equal(t, contracts.ErrUnknownDeadLetter{ID: 99}, contracts.FromError(err))
+
+	// dead letters, and what was re-driven, survive a restart
+	require.NoError(t, deadLetters.Close())
+
+	restored, err := LoadDeadLetters(path, 2, 4, nil)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	require.True(t, restored.Skipped("billing", 1))
+	require.False(t, restored.Skipped("shipping", 1))
+
+	got, err := restored.Get(deadLetter.Id)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), got.GetRedrivenIndex())
+	require.Equal(t, deadLetter.DeadLetteredAt.AsTime(), got.DeadLetteredAt.AsTime())
+
+	all, err := restored.List("", 0, 0)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+}
+
+func TestDeadLettersAtRest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead_letters.json")
+
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyFile, []byte("k1 "+base64.StdEncoding.EncodeToString(key)+"\n"), 0o600))
+
+	keys, err := log.LoadKeyFile(keyFile)
+	require.NoError(t, err)
+
+	deadLetters, err := LoadDeadLetters(path, 1, 0, keys)
+	require.NoError(t, err)
+
+	_, deadLetter, err := deadLetters.Report("billing", &contracts.Record{Value: "ssn=078-05-1120", Index: 1}, "boom")
+	require.NoError(t, err)
+	require.Equal(t, "ssn=078-05-1120", deadLetter.Record.Value)
+
+	_, err = deadLetters.Redrive(deadLetter.Id, func(*contracts.Record) (uint64, error) { return 2, nil })
+	require.NoError(t, err)
+	require.NoError(t, deadLetters.Close())
+
+	// values are sealed with the log's keys in the journal
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "ssn")
+
+	// the log started over, so its index 1 is another record now, and the
+	// re-drive is gone with it
+	restored, err := LoadDeadLetters(path, 1, 0, keys)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	require.False(t, restored.Skipped("billing", 1))
+
+	got, err := restored.Get(deadLetter.Id)
+	require.NoError(t, err)
+	require.Equal(t, "ssn=078-05-1120", got.Record.Value)
+	require.Nil(t, got.RedrivenIndex)
+
+	// failures that aren't reported again are forgotten
+	forgetful := NewDeadLetters(3)
+	forgetful.failureTimeout = 10 * time.Millisecond
+
+	for i := uint64(0); i < 100; i++ {
+		_, _, err := forgetful.Report("billing", &contracts.Record{Index: i}, "")
+		require.NoError(t, err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	failures, _, err := forgetful.Report("billing", &contracts.Record{Index: 0}, "")
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), failures)
+	require.Len(t, forgetful.failures, 1)
+}
+
+func TestScheduler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedules.json")
+
+	scheduler, err := LoadScheduler(path, nil)
+	require.NoError(t, err)
+
+	client, teardown := setupTest(t, func(cfg *Config) {
+		cfg.Scheduler = scheduler
+		scheduler.Start(cfg.CommitLog.Append)
+	})
+	defer teardown()
+
+	ctx := context.Background()
+
+	soon, err := client.Produce(ctx, &contracts.ProduceRequest{
+		Record:    &contracts.Record{Value: "soon"},
+		DeliverAt: timestamppb.New(time.Now().Add(100 * time.Millisecond)),
+	})
+	require.NoError(t, err)
+	require.NotZero(t, soon.ScheduleId)
+
+	later, err := client.Produce(ctx, &contracts.ProduceRequest{
+		Record:    &contracts.Record{Value: "later"},
+		DeliverAt: timestamppb.New(time.Now().Add(time.Hour)),
+	})
+	require.NoError(t, err)
+
+	cancelled, err := client.Produce(ctx, &contracts.ProduceRequest{
+		Record:    &contracts.Record{Value: "cancelled"},
+		DeliverAt: timestamppb.New(time.Now().Add(time.Hour)),
+	})
+	require.NoError(t, err)
+
+	_, err = client.Produce(ctx, &contracts.ProduceRequest{
+		Record:        &contracts.Record{Value: "at"},
+		DeliverAt:     timestamppb.New(time.Now()),
+		ExpectedIndex: proto.Uint64(0),
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// nothing is appended before it's due
+	offsets, err := client.Offsets(ctx, &contracts.OffsetsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offsets.NextIndex)
+
+	require.Eventually(t, func() bool {
+		offsets, err := client.Offsets(ctx, &contracts.OffsetsRequest{})
+		return err == nil && offsets.NextIndex == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	consumed, err := client.Consume(ctx, &contracts.ConsumeRequest{Index: 0})
+	require.NoError(t, err)
+	require.Equal(t, "soon", consumed.Record.Value)
+
+	_, err = client.CancelSchedule(ctx, &contracts.CancelScheduleRequest{ScheduleId: soon.ScheduleId})
+	require.Equal(t, contracts.ErrAlreadyDelivered{ScheduleID: soon.ScheduleId, Index: 0}, contracts.FromError(err))
+
+	_, err = client.CancelSchedule(ctx, &contracts.CancelScheduleRequest{ScheduleId: cancelled.ScheduleId})
+	require.NoError(t, err)
+	_, err = client.CancelSchedule(ctx, &contracts.CancelScheduleRequest{ScheduleId: cancelled.ScheduleId})
+	require.NoError(t, err)
+
+	_, err = client.CancelSchedule(ctx, &contracts.CancelScheduleRequest{ScheduleId: 99})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// pending records survive a restart, and are delivered once due
+	require.NoError(t, scheduler.Close())
+
+	restored, err := LoadScheduler(path, nil)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	require.Len(t, restored.schedules, 1)
+	restored.schedules[later.ScheduleId].DeliverAt = time.Now()
+
+	delivered := make(chan *contracts.Record, 1)
+	restored.Start(func(record *contracts.Record) (uint64, error) {
+		delivered <- record
+		return 7, nil
+	})
+
+	require.Equal(t, "later", (<-delivered).Value)
+
+	id, err := restored.Schedule(&contracts.Record{Value: "next"}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, cancelled.ScheduleId+1, id)
+}
+
+func TestSchedulerAtRest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedules.json")
+
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyFile, []byte("k1 "+base64.StdEncoding.EncodeToString(key)+"\n"), 0o600))
+
+	keys, err := log.LoadKeyFile(keyFile)
+	require.NoError(t, err)
+
+	scheduler, err := LoadScheduler(path, keys)
+	require.NoError(t, err)
+
+	due, err := scheduler.Schedule(&contracts.Record{Value: "ssn=078-05-1120"}, time.Now())
+	require.NoError(t, err)
+
+	// records are sealed with the log's keys in the journal
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "078-05-1120")
+
+	appending := make(chan *contracts.Record)
+	appended := make(chan struct{})
+	scheduler.Start(func(record *contracts.Record) (uint64, error) {
+		appending <- record
+		<-appended
+		return 4, nil
+	})
+
+	require.Equal(t, "ssn=078-05-1120", (<-appending).Value)
+
+	// the append doesn't hold up other schedules
+	_, err = scheduler.Schedule(&contracts.Record{Value: "later"}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	// cancelling a record being appended waits to report where it went
+	cancelled := make(chan error, 1)
+	go func() { cancelled <- scheduler.Cancel(due) }()
+	time.Sleep(20 * time.Millisecond)
+	close(appended)
+	require.Equal(t, contracts.ErrAlreadyDelivered{ScheduleID: due, Index: 4}, <-cancelled)
+
+	// delivered records are forgotten once the retention is over
+	scheduler.mu.Lock()
+	require.Nil(t, scheduler.schedules[due].Sealed)
+	scheduler.retention = 0
+	scheduler.mu.Unlock()
+
+	_, err = scheduler.Schedule(&contracts.Record{Value: "sweep"}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, contracts.ErrUnknownSchedule{ScheduleID: due}, scheduler.Cancel(due))
+	require.NoError(t, scheduler.Close())
+
+	restored, err := LoadScheduler(path, keys)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	require.Len(t, restored.schedules, 2)
+	require.NoError(t, restored.Rewrap())
+}
+
+func TestConfigDefaults(t *testing.T) {
+	var cfg *Config
+
+	client, teardown := setupTest(t, func(c *Config) {
+		cfg = c
+	})
+	defer teardown()
+
+	// defaults are the server's own, not written back to the config
+	require.Nil(t, cfg.Health)
+	require.Nil(t, cfg.Producers)
+	require.Nil(t, cfg.Schemas)
+	require.Nil(t, cfg.DeadLetters)
+
+	_, err := client.Produce(context.Background(), &contracts.ProduceRequest{
+		Record:    &contracts.Record{Value: "later"},
+		DeliverAt: timestamppb.New(time.Now().Add(time.Hour)),
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
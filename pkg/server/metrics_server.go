@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+)
+
+var (
+	consumeStreams    = stats.Int64("record.v1/consume_streams", "Number of open ConsumeStream and Subscribe streams", stats.UnitDimensionless)
+	pausedSubscribers = stats.Int64("record.v1/paused_subscribers", "Number of Subscribe streams waiting for credits", stats.UnitDimensionless)
+	subscriberPauses  = stats.Int64("record.v1/subscriber_pauses", "Number of times a Subscribe stream ran out of credits", stats.UnitDimensionless)
+)
+
+var serverViews = []*view.View{
+	{
+		Name:        consumeStreams.Name(),
+		Description: consumeStreams.Description(),
+		Measure:     consumeStreams,
+		Aggregation: view.LastValue(),
+	},
+	{
+		Name:        pausedSubscribers.Name(),
+		Description: pausedSubscribers.Description(),
+		Measure:     pausedSubscribers,
+		Aggregation: view.LastValue(),
+	},
+	{
+		Name:        subscriberPauses.Name(),
+		Description: subscriberPauses.Description(),
+		Measure:     subscriberPauses,
+		Aggregation: view.Count(),
+	},
+}
+
+// gauge keeps a count and records it to a measure whenever it changes.
+type gauge struct {
+	n       int64
+	measure *stats.Int64Measure
+}
+
+func (g *gauge) add(delta int64) int64 {
+	n := atomic.AddInt64(&g.n, delta)
+	stats.Record(context.Background(), g.measure.M(n))
+	return n
+}
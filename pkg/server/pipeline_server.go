@@ -0,0 +1,166 @@
+package server
+
+import (
+	"io"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+)
+
+const (
+	// produceStreamWindow is how many requests ProduceStream reads ahead of
+	// the ones it has acknowledged.
+	produceStreamWindow = 1024
+
+	// maxGroupRecords caps how many requests ProduceStream appends at once.
+	maxGroupRecords = 1000
+)
+
+// ProduceStream reads requests ahead of appending them, and appends the
+// plain ones that have arrived in one batch, so a stream's throughput is
+// bound by batch size rather than round trips. Responses are sent in the
+// order of requests, each with the request's request_id, and a request that
+// fails is answered with its error rather than ending the stream.
+func (g *grpcServer) ProduceStream(stream contracts.Endpoints_ProduceStreamServer) error {
+	requests := make(chan *contracts.ProduceRequest, produceStreamWindow)
+	recvErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(requests)
+
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+
+			select {
+			case requests <- req:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		req, ok := <-requests
+		if !ok {
+			err := <-recvErr
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		group, next := g.group(req, requests)
+
+		err := g.produceGroup(stream, group)
+		if err != nil {
+			return err
+		}
+
+		if next == nil {
+			continue
+		}
+
+		res, err := g.Produce(stream.Context(), next)
+		if err != nil {
+			res = &contracts.ProduceResponse{Error: contracts.NewStatus(err)}
+		}
+
+		res.RequestId = next.RequestId
+
+		err = stream.Send(res)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// group collects req and the requests already read after it that can be
+// appended together. It stops at the first one that can't, and returns it
+// as next.
+func (g *grpcServer) group(req *contracts.ProduceRequest, requests <-chan *contracts.ProduceRequest) (group []*contracts.ProduceRequest, next *contracts.ProduceRequest) {
+	size := 0
+
+	for {
+		if !g.groupable(req) {
+			return group, req
+		}
+
+		group = append(group, req)
+		size += len(req.Record.Value)
+
+		if len(group) == maxGroupRecords || (g.Config.MaxBatchSize > 0 && size >= g.Config.MaxBatchSize) {
+			return group, nil
+		}
+
+		select {
+		case req = <-requests:
+			if req == nil {
+				return group, nil
+			}
+		default:
+			return group, nil
+		}
+	}
+}
+
+// groupable reports whether req is a valid request with nothing but a
+// record, which is all a group's batched append can honour.
+func (g *grpcServer) groupable(req *contracts.ProduceRequest) bool {
+	if req.ProducerId != 0 || req.ExpectedIndex != nil || req.TxnId != 0 || req.DeliverAt != nil {
+		return false
+	}
+
+	return g.Config.validateProduce(req) == nil
+}
+
+// produceGroup appends a group and acknowledges each of its requests. The
+// only error it returns is a failure to send; a failed append is reported
+// to every request of the group.
+func (g *grpcServer) produceGroup(stream contracts.Endpoints_ProduceStreamServer, group []*contracts.ProduceRequest) error {
+	if len(group) == 0 {
+		return nil
+	}
+
+	responses := make([]*contracts.ProduceResponse, len(group))
+	records := make([]*contracts.Record, 0, len(group))
+	appended := make([]int, 0, len(group))
+
+	for i, req := range group {
+		responses[i] = &contracts.ProduceResponse{RequestId: req.RequestId}
+
+		err := g.compress([]*contracts.Record{req.Record}, req.Compression)
+		if err != nil {
+			responses[i].Error = contracts.NewStatus(err)
+			continue
+		}
+
+		records = append(records, req.Record)
+		appended = append(appended, i)
+	}
+
+	if len(records) > 0 {
+		indexes, err := g.Config.CommitLog.AppendBatch(records)
+
+		for j, i := range appended {
+			if err != nil {
+				responses[i].Error = contracts.NewStatus(err)
+				continue
+			}
+			responses[i].Index = indexes[j]
+		}
+	}
+
+	for _, res := range responses {
+		err := stream.Send(res)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,274 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+)
+
+// dedupWindow is how many of a producer's most recent batches are
+// remembered, and so how many unacknowledged batches it can safely retry.
+const dedupWindow = 5
+
+type producedBatch struct {
+	Sequence uint64 `json:"sequence"`
+	Count    uint64 `json:"count"`
+	Index    uint64 `json:"index"`
+}
+
+type producerState struct {
+	ID           uint64          `json:"id"`
+	Name         string          `json:"name,omitempty"`
+	Epoch        uint32          `json:"epoch"`
+	NextSequence uint64          `json:"next_sequence"`
+	Batches      []producedBatch `json:"batches"`
+}
+
+// Producers tracks idempotent producers: their epochs, and the sequence
+// numbers they have appended so retried batches aren't appended twice.
+type Producers struct {
+	mu      sync.Mutex
+	nextID  uint64
+	byID    map[uint64]*producerState
+	byName  map[string]*producerState
+	path    string
+	journal *os.File
+	writes  int
+}
+
+func NewProducers() *Producers {
+	return &Producers{
+		nextID: 1,
+		byID:   map[uint64]*producerState{},
+		byName: map[string]*producerState{},
+	}
+}
+
+// LoadProducers restores producers from a journal file, where every change
+// to a producer is appended as a line of JSON and the last line for a
+// producer wins. The journal is compacted on load, and again as it grows.
+//
+// Batches at or past nextIndex, the log's next index, aren't in the log any
+// more, so they're forgotten: a retry of one is rejected as out of order
+// rather than acknowledged with indexes of records the log doesn't have.
+func LoadProducers(path string, nextIndex uint64) (*Producers, error) {
+	p := NewProducers()
+
+	f, err := os.Open(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+		for scanner.Scan() {
+			state := &producerState{}
+
+			err = json.Unmarshal(scanner.Bytes(), state)
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+
+			p.restore(state)
+		}
+
+		f.Close()
+
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, state := range p.byID {
+		state.Batches = batchesBefore(state.Batches, nextIndex)
+	}
+
+	p.path = path
+
+	err = p.compact()
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *Producers) restore(state *producerState) {
+	p.byID[state.ID] = state
+
+	if len(state.Name) > 0 {
+		p.byName[state.Name] = state
+	}
+
+	if state.ID >= p.nextID {
+		p.nextID = state.ID + 1
+	}
+}
+
+func batchesBefore(batches []producedBatch, nextIndex uint64) []producedBatch {
+	var kept []producedBatch
+
+	for _, batch := range batches {
+		if batch.Index+batch.Count <= nextIndex {
+			kept = append(kept, batch)
+		}
+	}
+
+	return kept
+}
+
+func (p *Producers) compact() error {
+	journal, err := rewriteJournal(p.path, func(encoder *json.Encoder) error {
+		for _, state := range p.byID {
+			err := encoder.Encode(state)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if p.journal != nil {
+		p.journal.Close()
+	}
+
+	p.journal = journal
+	p.writes = 0
+
+	return nil
+}
+
+func (p *Producers) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.journal == nil {
+		return nil
+	}
+
+	return p.journal.Close()
+}
+
+// Init allocates a producer id. A named producer keeps its id and moves to
+// a new epoch, which fences off requests from its previous epochs.
+func (p *Producers) Init(name string) (uint64, uint32, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state, ok := p.byName[name]
+
+	if ok {
+		state.Epoch++
+		state.NextSequence = 0
+		state.Batches = nil
+	} else {
+		state = &producerState{ID: p.nextID, Name: name}
+		p.restore(state)
+	}
+
+	err := p.persist(state)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return state.ID, state.Epoch, nil
+}
+
+// Append calls appendRecords for the next count records of a producer,
+// unless they were appended before, in which case it returns their original
+// indexes and reports a duplicate. Idempotent appends are serialized with
+// each other so the sequence check and the append are atomic.
+func (p *Producers) Append(id uint64, epoch uint32, sequence uint64, count int, appendRecords func() ([]uint64, error)) ([]uint64, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state, ok := p.byID[id]
+	if !ok {
+		return nil, false, contracts.ErrUnknownProducer{ProducerID: id}
+	}
+
+	if epoch != state.Epoch {
+		return nil, false, contracts.ErrProducerFenced{ProducerID: id, Epoch: epoch}
+	}
+
+	if sequence < state.NextSequence {
+		for _, batch := range state.Batches {
+			if batch.Sequence == sequence && batch.Count == uint64(count) {
+				indexes := make([]uint64, count)
+				for i := range indexes {
+					indexes[i] = batch.Index + uint64(i)
+				}
+				return indexes, true, nil
+			}
+		}
+	}
+
+	if sequence != state.NextSequence {
+		return nil, false, contracts.ErrOutOfOrderSequence{
+			ProducerID:       id,
+			Sequence:         sequence,
+			ExpectedSequence: state.NextSequence,
+		}
+	}
+
+	indexes, err := appendRecords()
+	if err != nil {
+		return nil, false, err
+	}
+
+	state.NextSequence += uint64(count)
+	state.Batches = appendBatch(state.Batches, producedBatch{
+		Sequence: sequence,
+		Count:    uint64(count),
+		Index:    indexes[0],
+	})
+
+	// the records are in the log either way; a producer retrying after this
+	// fails is still deduplicated by the state in memory
+	err = p.persist(state)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return indexes, false, nil
+}
+
+func appendBatch(batches []producedBatch, batch producedBatch) []producedBatch {
+	batches = append(batches, batch)
+
+	if len(batches) > dedupWindow {
+		batches = batches[len(batches)-dedupWindow:]
+	}
+
+	return batches
+}
+
+func (p *Producers) persist(state *producerState) error {
+	if p.journal == nil {
+		return nil
+	}
+
+	err := appendJournal(p.journal, state)
+	if err != nil {
+		return err
+	}
+
+	p.writes++
+
+	if p.writes < journalCompactEvery+len(p.byID) {
+		return nil
+	}
+
+	return p.compact()
+}
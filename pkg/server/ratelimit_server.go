@@ -0,0 +1,391 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type RateLimit struct {
+	RecordsPerSecond float64 `mapstructure:"records_per_second"`
+	BytesPerSecond   float64 `mapstructure:"bytes_per_second"`
+}
+
+type RateLimitPolicy struct {
+	Produce RateLimit `mapstructure:"produce"`
+	Consume RateLimit `mapstructure:"consume"`
+}
+
+type RateLimits struct {
+	Default    RateLimitPolicy            `mapstructure:"default"`
+	Identities map[string]RateLimitPolicy `mapstructure:"identities"`
+}
+
+func LoadRateLimits(path string) (*RateLimits, error) {
+	// identities are often IP addresses, so dots can't nest keys
+	v := viper.NewWithOptions(viper.KeyDelimiter("::"))
+	v.SetConfigFile(path)
+
+	err := v.ReadInConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	limits := &RateLimits{}
+
+	err = v.Unmarshal(limits)
+	if err != nil {
+		return nil, err
+	}
+
+	return limits, nil
+}
+
+func (r *RateLimits) policyFor(identity string) RateLimitPolicy {
+	if policy, ok := r.Identities[identity]; ok {
+		return policy
+	}
+
+	return r.Default
+}
+
+type bucket struct {
+	records *rate.Limiter
+	bytes   *rate.Limiter
+}
+
+func newBucket(limit RateLimit) *bucket {
+	return &bucket{
+		records: newLimiter(limit.RecordsPerSecond),
+		bytes:   newLimiter(limit.BytesPerSecond),
+	}
+}
+
+func newLimiter(perSecond float64) *rate.Limiter {
+	if perSecond <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+
+	return rate.NewLimiter(rate.Limit(perSecond), int(math.Max(1, perSecond)))
+}
+
+// take removes records and bytes from the bucket, or returns how long the
+// caller should wait before trying again. Nothing is taken on rejection.
+func (b *bucket) take(now time.Time, records, bytes int) time.Duration {
+	recordsReservation := reserve(b.records, now, records)
+	if delay := recordsReservation.DelayFrom(now); delay > 0 {
+		recordsReservation.CancelAt(now)
+		return delay
+	}
+
+	bytesReservation := reserve(b.bytes, now, bytes)
+	if delay := bytesReservation.DelayFrom(now); delay > 0 {
+		bytesReservation.CancelAt(now)
+		recordsReservation.CancelAt(now)
+		return delay
+	}
+
+	return 0
+}
+
+// wait takes records from the bucket, waiting until it has them, and until
+// bytes charged before are paid off, rather than turning the caller away.
+func (b *bucket) wait(ctx context.Context, records int) error {
+	now := time.Now()
+
+	reservation := reserve(b.records, now, records)
+	delay := reservation.DelayFrom(now)
+
+	if debt := reserve(b.bytes, now, 0).DelayFrom(now); debt > delay {
+		delay = debt
+	}
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		reservation.CancelAt(time.Now())
+		return ctx.Err()
+	}
+}
+
+// full reports whether the bucket has refilled, so dropping it and starting
+// again with a new one changes nothing.
+func (b *bucket) full(now time.Time) bool {
+	return filled(b.records, now) && filled(b.bytes, now)
+}
+
+func filled(limiter *rate.Limiter, now time.Time) bool {
+	return limiter.Limit() == rate.Inf || limiter.TokensAt(now) >= float64(limiter.Burst())
+}
+
+// charge removes bytes from the bucket even if that puts it in debt, which
+// is how reads are accounted for once their size is known.
+func (b *bucket) charge(now time.Time, bytes int) {
+	reserve(b.bytes, now, bytes)
+}
+
+func reserve(limiter *rate.Limiter, now time.Time, n int) *rate.Reservation {
+	if burst := limiter.Burst(); limiter.Limit() != rate.Inf && n > burst {
+		n = burst
+	}
+
+	return limiter.ReserveN(now, n)
+}
+
+// bucketIdleTimeout is how long an identity's buckets are kept once it
+// stops making requests.
+const bucketIdleTimeout = 10 * time.Minute
+
+type identityBuckets struct {
+	produce  *bucket
+	consume  *bucket
+	lastUsed time.Time
+}
+
+type rateLimiter struct {
+	limits      *RateLimits
+	idleTimeout time.Duration
+	gatewayKey  string
+
+	mu         sync.Mutex
+	identities map[string]*identityBuckets
+	lastSweep  time.Time
+}
+
+func newRateLimiter(limits *RateLimits) *rateLimiter {
+	return &rateLimiter{
+		limits:      limits,
+		idleTimeout: bucketIdleTimeout,
+		identities:  map[string]*identityBuckets{},
+		lastSweep:   time.Now(),
+	}
+}
+
+func (r *rateLimiter) buckets(identity string) (*bucket, *bucket) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(r.lastSweep) >= r.idleTimeout {
+		r.sweep(now)
+	}
+
+	buckets, ok := r.identities[identity]
+	if !ok {
+		policy := r.limits.policyFor(identity)
+		buckets = &identityBuckets{
+			produce: newBucket(policy.Produce),
+			consume: newBucket(policy.Consume),
+		}
+		r.identities[identity] = buckets
+	}
+
+	buckets.lastUsed = now
+
+	return buckets.produce, buckets.consume
+}
+
+// sweep drops the buckets of identities that have been idle for the idle
+// timeout, once they've refilled.
+func (r *rateLimiter) sweep(now time.Time) {
+	for identity, buckets := range r.identities {
+		if now.Sub(buckets.lastUsed) >= r.idleTimeout && buckets.produce.full(now) && buckets.consume.full(now) {
+			delete(r.identities, identity)
+		}
+	}
+
+	r.lastSweep = now
+}
+
+func (r *rateLimiter) beforeProduce(ctx context.Context, records ...*contracts.Record) error {
+	produce, _ := r.buckets(r.identity(ctx))
+
+	bytes := 0
+	for _, record := range records {
+		bytes += len(record.GetValue())
+	}
+
+	return rateLimited(produce.take(time.Now(), len(records), bytes))
+}
+
+func (r *rateLimiter) beforeConsume(ctx context.Context) error {
+	_, consume := r.buckets(r.identity(ctx))
+
+	return rateLimited(consume.take(time.Now(), 1, 0))
+}
+
+func (r *rateLimiter) afterConsume(ctx context.Context, res *contracts.ConsumeResponse) {
+	_, consume := r.buckets(r.identity(ctx))
+
+	consume.charge(time.Now(), valueSize(res.GetRecord()))
+}
+
+func (r *rateLimiter) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	switch req := req.(type) {
+	case *contracts.ProduceRequest:
+		err := r.beforeProduce(ctx, req.Record)
+		if err != nil {
+			return nil, err
+		}
+	case *contracts.ProduceBatchRequest:
+		err := r.beforeProduce(ctx, req.Records...)
+		if err != nil {
+			return nil, err
+		}
+	case *contracts.AddRecordsRequest:
+		err := r.beforeProduce(ctx, req.Records...)
+		if err != nil {
+			return nil, err
+		}
+	case *contracts.ConsumeRequest:
+		err := r.beforeConsume(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := handler(ctx, req)
+
+	if res, ok := res.(*contracts.ConsumeResponse); ok {
+		r.afterConsume(ctx, res)
+	}
+
+	return res, err
+}
+
+func (r *rateLimiter) streamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &rateLimitedStream{ServerStream: stream, limiter: r})
+}
+
+type rateLimitedStream struct {
+	grpc.ServerStream
+	limiter *rateLimiter
+
+	// sendMu serializes the handler's sends with the answers RecvMsg sends
+	// to requests it turns away
+	sendMu sync.Mutex
+}
+
+// RecvMsg answers produce requests over the limit with ErrOverloaded itself
+// and receives the next one, so the stream carries on as it does for other
+// failed requests. The answer may come ahead of those to earlier requests.
+func (s *rateLimitedStream) RecvMsg(m interface{}) error {
+	for {
+		err := s.ServerStream.RecvMsg(m)
+		if err != nil {
+			return err
+		}
+
+		req, ok := m.(*contracts.ProduceRequest)
+		if !ok {
+			return nil
+		}
+
+		err = s.limiter.beforeProduce(s.Context(), req.Record)
+		if err == nil {
+			return nil
+		}
+
+		err = s.send(&contracts.ProduceResponse{RequestId: req.RequestId, Error: contracts.NewStatus(err)})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *rateLimitedStream) SendMsg(m interface{}) error {
+	// progress markers of filtered streams carry no record and are free
+	res, ok := m.(*contracts.ConsumeResponse)
+	if !ok || res.Record == nil {
+		return s.send(m)
+	}
+
+	// streams are held back until the consumer is within its limits
+	_, consume := s.limiter.buckets(s.limiter.identity(s.Context()))
+
+	err := consume.wait(s.Context(), 1)
+	if err != nil {
+		return err
+	}
+
+	s.limiter.afterConsume(s.Context(), res)
+
+	return s.send(m)
+}
+
+func (s *rateLimitedStream) send(m interface{}) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	return s.ServerStream.SendMsg(m)
+}
+
+// identity is the caller's principal or, for anonymous callers, their host.
+// That's the client's host the gateway forwards for calls that carry the
+// gateway key, and the peer's host for any other.
+func (r *rateLimiter) identity(ctx context.Context) string {
+	if p := principal(ctx); p != anonymous {
+		return p
+	}
+
+	if host, ok := r.forwardedFor(ctx); ok {
+		return host
+	}
+
+	addr := peerAddress(ctx)
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
+
+func (r *rateLimiter) forwardedFor(ctx context.Context) (string, bool) {
+	if len(r.gatewayKey) == 0 {
+		return "", false
+	}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	keys, hosts := md.Get("x-gateway-key"), md.Get("x-forwarded-for")
+	if len(keys) != 1 || len(hosts) != 1 {
+		return "", false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(keys[0]), []byte(r.gatewayKey)) != 1 {
+		return "", false
+	}
+
+	return hosts[0], true
+}
+
+func rateLimited(retryAfter time.Duration) error {
+	if retryAfter <= 0 {
+		return nil
+	}
+
+	return contracts.ErrOverloaded{RetryAfter: retryAfter}
+}
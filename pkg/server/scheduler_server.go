@@ -0,0 +1,458 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"github.com/w-h-a/grpc-server/pkg/log"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// redeliverDelay is how long a scheduled record waits before another
+	// try when appending it fails.
+	redeliverDelay = time.Second
+
+	// finishedRetention is how long delivered and cancelled records are
+	// remembered, so cancelling them says what became of them.
+	finishedRetention = time.Hour
+)
+
+type scheduleState struct {
+	ID        uint64    `json:"id"`
+	DeliverAt time.Time `json:"deliver_at"`
+	// Record is the record as protobuf, or Sealed is, encrypted, when the
+	// log's records are.
+	Record    []byte      `json:"record,omitempty"`
+	Sealed    *log.Sealed `json:"sealed_record,omitempty"`
+	Index     *uint64     `json:"index,omitempty"`
+	Cancelled bool        `json:"cancelled,omitempty"`
+
+	delivering chan struct{}
+	finishedAt time.Time
+}
+
+func (s *scheduleState) pending() bool {
+	return s.Index == nil && !s.Cancelled
+}
+
+func (s *scheduleState) finish() {
+	s.Record = nil
+	s.Sealed = nil
+	s.finishedAt = time.Now()
+}
+
+// Scheduler holds records produced with deliver_at, and appends each one
+// once its time comes.
+type Scheduler struct {
+	logger    *zap.Logger
+	keys      log.KeyWrapper
+	retention time.Duration
+
+	mu           sync.Mutex
+	nextID       uint64
+	schedules    map[uint64]*scheduleState
+	timers       map[uint64]*time.Timer
+	appendRecord func(*contracts.Record) (uint64, error)
+	delivering   sync.WaitGroup
+	lastSweep    time.Time
+	closed       bool
+	path         string
+	journal      *os.File
+	writes       int
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		logger:    zap.L().Named("server"),
+		retention: finishedRetention,
+		nextID:    1,
+		schedules: map[uint64]*scheduleState{},
+		timers:    map[uint64]*time.Timer{},
+		lastSweep: time.Now(),
+	}
+}
+
+// LoadScheduler restores scheduled records from a journal file, where every
+// change to a schedule is appended as a line of JSON and the last line for
+// a schedule wins. The journal is compacted on load to the records still
+// pending, and again as it grows. With keys, records are kept sealed with
+// them, in memory and in the journal, and records journaled in plaintext
+// before are sealed on load.
+func LoadScheduler(path string, keys log.KeyWrapper) (*Scheduler, error) {
+	s := NewScheduler()
+	s.keys = keys
+
+	f, err := os.Open(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+		for scanner.Scan() {
+			state := &scheduleState{}
+
+			err = json.Unmarshal(scanner.Bytes(), state)
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+
+			s.restore(state)
+		}
+
+		f.Close()
+
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for id, state := range s.schedules {
+		if !state.pending() {
+			delete(s.schedules, id)
+			continue
+		}
+
+		if keys != nil && state.Sealed == nil {
+			state.Sealed, err = log.Seal(keys, state.Record)
+			if err != nil {
+				return nil, err
+			}
+			state.Record = nil
+		}
+	}
+
+	s.path = path
+
+	err = s.compact()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Scheduler) restore(state *scheduleState) {
+	s.schedules[state.ID] = state
+
+	if state.ID >= s.nextID {
+		s.nextID = state.ID + 1
+	}
+}
+
+func (s *Scheduler) compact() error {
+	journal, err := rewriteJournal(s.path, func(encoder *json.Encoder) error {
+		for _, state := range s.schedules {
+			if !state.pending() {
+				continue
+			}
+
+			err := encoder.Encode(state)
+			if err != nil {
+				return err
+			}
+		}
+
+		// ids of delivered and cancelled records aren't handed out again
+		if last, ok := s.schedules[s.nextID-1]; (!ok || !last.pending()) && s.nextID > 1 {
+			return encoder.Encode(&scheduleState{ID: s.nextID - 1, Cancelled: true})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.journal != nil {
+		s.journal.Close()
+	}
+
+	s.journal = journal
+	s.writes = 0
+
+	return nil
+}
+
+// Start arms the timers of pending records, which are appended with
+// appendRecord when due. Records already due are appended right away, and
+// records scheduled before Start wait for it.
+func (s *Scheduler) Start(appendRecord func(*contracts.Record) (uint64, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.appendRecord = appendRecord
+
+	for id, state := range s.schedules {
+		if _, armed := s.timers[id]; state.pending() && !armed {
+			s.arm(id, time.Until(state.DeliverAt))
+		}
+	}
+}
+
+func (s *Scheduler) arm(id uint64, delay time.Duration) {
+	s.timers[id] = time.AfterFunc(delay, func() { s.deliver(id) })
+}
+
+// Close stops delivering records, once those being appended are; the ones
+// still pending are delivered once the scheduler is loaded again.
+func (s *Scheduler) Close() error {
+	s.mu.Lock()
+
+	s.closed = true
+
+	for id, timer := range s.timers {
+		timer.Stop()
+		delete(s.timers, id)
+	}
+
+	s.mu.Unlock()
+
+	s.delivering.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.journal == nil {
+		return nil
+	}
+
+	return s.journal.Close()
+}
+
+// Rewrap wraps the data keys of sealed records with the current
+// key-encryption key, and rewrites the journal with them, so older keys can
+// be dropped.
+func (s *Scheduler) Rewrap() error {
+	if s.keys == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, state := range s.schedules {
+		if state.Sealed == nil {
+			continue
+		}
+
+		err := state.Sealed.Rewrap(s.keys)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.journal == nil {
+		return nil
+	}
+
+	return s.compact()
+}
+
+// Schedule holds record until deliverAt, and returns the id to cancel it
+// with.
+func (s *Scheduler) Schedule(record *contracts.Record, deliverAt time.Time) (uint64, error) {
+	data, err := proto.Marshal(record)
+	if err != nil {
+		return 0, err
+	}
+
+	state := &scheduleState{DeliverAt: deliverAt.UTC(), Record: data}
+
+	if s.keys != nil {
+		state.Sealed, err = log.Seal(s.keys, data)
+		if err != nil {
+			return 0, err
+		}
+		state.Record = nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, contracts.ErrShuttingDown{}
+	}
+
+	s.sweep(time.Now())
+
+	state.ID = s.nextID
+
+	err = s.persist(state)
+	if err != nil {
+		return 0, err
+	}
+
+	s.restore(state)
+
+	if s.appendRecord != nil {
+		s.arm(state.ID, time.Until(deliverAt))
+	}
+
+	return state.ID, nil
+}
+
+// Cancel drops a pending record. A record being appended can't be dropped
+// any more, so Cancel waits to report where it was appended.
+func (s *Scheduler) Cancel(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		state, ok := s.schedules[id]
+		switch {
+		case !ok:
+			return contracts.ErrUnknownSchedule{ScheduleID: id}
+		case state.delivering != nil:
+			delivering := state.delivering
+			s.mu.Unlock()
+			<-delivering
+			s.mu.Lock()
+			continue
+		case state.Index != nil:
+			return contracts.ErrAlreadyDelivered{ScheduleID: id, Index: *state.Index}
+		case state.Cancelled:
+			return nil
+		}
+
+		err := s.persist(&scheduleState{ID: id, Cancelled: true})
+		if err != nil {
+			return err
+		}
+
+		if timer, ok := s.timers[id]; ok {
+			timer.Stop()
+			delete(s.timers, id)
+		}
+
+		state.Cancelled = true
+		state.finish()
+
+		return nil
+	}
+}
+
+// deliver appends a due record, and tries again later if that fails. The
+// append happens outside the lock, so a slow log doesn't hold up other
+// schedules. A record appended just before a crash, but not yet journaled
+// as delivered, is appended again after the restart.
+func (s *Scheduler) deliver(id uint64) {
+	s.mu.Lock()
+
+	state, ok := s.schedules[id]
+	if s.closed || !ok || !state.pending() || state.delivering != nil {
+		s.mu.Unlock()
+		return
+	}
+
+	delete(s.timers, id)
+
+	record, err := s.record(state)
+	if err != nil {
+		s.logger.Error("dropped unreadable scheduled record", zap.Uint64("schedule_id", id), zap.Error(err))
+		state.Cancelled = true
+		state.finish()
+		s.mu.Unlock()
+		return
+	}
+
+	delivering := make(chan struct{})
+	state.delivering = delivering
+	appendRecord := s.appendRecord
+	s.delivering.Add(1)
+
+	s.mu.Unlock()
+
+	index, err := appendRecord(record)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.delivering.Done()
+
+	state.delivering = nil
+	close(delivering)
+
+	if err != nil {
+		s.logger.Warn("failed to deliver scheduled record", zap.Uint64("schedule_id", id), zap.Error(err))
+		if !s.closed {
+			s.arm(id, redeliverDelay)
+		}
+		return
+	}
+
+	state.Index = &index
+	state.finish()
+
+	// the record is in the log either way; after a restart it's appended again
+	err = s.persist(&scheduleState{ID: id, Index: &index})
+	if err != nil {
+		s.logger.Error("failed to journal delivered record", zap.Uint64("schedule_id", id), zap.Error(err))
+	}
+}
+
+func (s *Scheduler) record(state *scheduleState) (*contracts.Record, error) {
+	data := state.Record
+
+	if state.Sealed != nil {
+		if s.keys == nil {
+			return nil, errors.New("record is sealed, and there are no keys to open it")
+		}
+
+		var err error
+
+		data, err = state.Sealed.Open(s.keys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	record := &contracts.Record{}
+
+	return record, proto.Unmarshal(data, record)
+}
+
+// sweep forgets records delivered or cancelled more than the retention ago,
+// at most once per retention.
+func (s *Scheduler) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.retention {
+		return
+	}
+
+	s.lastSweep = now
+
+	for id, state := range s.schedules {
+		if !state.pending() && now.Sub(state.finishedAt) >= s.retention {
+			delete(s.schedules, id)
+		}
+	}
+}
+
+func (s *Scheduler) persist(state *scheduleState) error {
+	if s.journal == nil {
+		return nil
+	}
+
+	err := appendJournal(s.journal, state)
+	if err != nil {
+		return err
+	}
+
+	s.writes++
+
+	if s.writes < journalCompactEvery+len(s.schedules) {
+		return nil
+	}
+
+	return s.compact()
+}
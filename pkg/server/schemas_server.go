@@ -0,0 +1,323 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"github.com/w-h-a/grpc-server/pkg/schema"
+)
+
+type schemaState struct {
+	ID            uint32                  `json:"id,omitempty"`
+	Subject       string                  `json:"subject"`
+	Version       uint32                  `json:"version,omitempty"`
+	Type          contracts.SchemaType    `json:"type,omitempty"`
+	Definition    string                  `json:"definition,omitempty"`
+	Compatibility contracts.Compatibility `json:"compatibility,omitempty"`
+}
+
+type registeredSchema struct {
+	info   *contracts.Schema
+	parsed schema.Schema
+}
+
+type subject struct {
+	compatibility contracts.Compatibility
+	versions      []*registeredSchema
+}
+
+// Schemas is the schema registry: the versions of each subject, and the ids
+// records refer to them by.
+type Schemas struct {
+	compatibility contracts.Compatibility
+
+	mu       sync.RWMutex
+	nextID   uint32
+	byID     map[uint32]*registeredSchema
+	subjects map[string]*subject
+	journal  *os.File
+}
+
+// NewSchemas returns a registry that checks new versions at compatibility,
+// unless their subject has its own level.
+func NewSchemas(compatibility contracts.Compatibility) *Schemas {
+	return &Schemas{
+		compatibility: compatibility,
+		nextID:        1,
+		byID:          map[uint32]*registeredSchema{},
+		subjects:      map[string]*subject{},
+	}
+}
+
+// LoadSchemas restores a registry from a journal file, where every version
+// and every change to a subject's level is appended as a line of JSON.
+func LoadSchemas(path string, compatibility contracts.Compatibility) (*Schemas, error) {
+	s := NewSchemas(compatibility)
+
+	f, err := os.Open(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+		for scanner.Scan() {
+			state := &schemaState{}
+
+			err = json.Unmarshal(scanner.Bytes(), state)
+			if err == nil {
+				err = s.restore(state)
+			}
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+
+		f.Close()
+
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	s.journal, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Schemas) restore(state *schemaState) error {
+	var parsed schema.Schema
+
+	if state.ID != 0 {
+		var err error
+
+		parsed, err = schema.Parse(state.Type, state.Definition)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.add(state, parsed)
+
+	return nil
+}
+
+func (s *Schemas) add(state *schemaState, parsed schema.Schema) {
+	sub := s.subject(state.Subject)
+
+	if state.Compatibility != contracts.Compatibility_COMPATIBILITY_UNSPECIFIED {
+		sub.compatibility = state.Compatibility
+	}
+
+	if state.ID == 0 {
+		return
+	}
+
+	registered := &registeredSchema{
+		info: &contracts.Schema{
+			Id:         state.ID,
+			Subject:    state.Subject,
+			Version:    state.Version,
+			Type:       state.Type,
+			Definition: state.Definition,
+		},
+		parsed: parsed,
+	}
+
+	s.byID[state.ID] = registered
+	sub.versions = append(sub.versions, registered)
+
+	if state.ID >= s.nextID {
+		s.nextID = state.ID + 1
+	}
+}
+
+func (s *Schemas) subject(name string) *subject {
+	sub, ok := s.subjects[name]
+	if !ok {
+		sub = &subject{}
+		s.subjects[name] = sub
+	}
+
+	return sub
+}
+
+func (s *Schemas) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.journal == nil {
+		return nil
+	}
+
+	return s.journal.Close()
+}
+
+// Register adds definition as the next version of subjectName, once it
+// passes the compatibility check against the latest version. The latest
+// version's definition is returned as it is instead.
+func (s *Schemas) Register(subjectName string, typ contracts.SchemaType, definition string, compatibility contracts.Compatibility) (*contracts.Schema, error) {
+	if len(subjectName) == 0 {
+		return nil, contracts.ErrInvalidSchema{Field: "subject", Description: "subject is required"}
+	}
+
+	if _, ok := contracts.Compatibility_name[int32(compatibility)]; !ok {
+		return nil, contracts.ErrInvalidSchema{Field: "compatibility", Description: "unknown compatibility"}
+	}
+
+	parsed, err := schema.Parse(typ, definition)
+	if err != nil {
+		return nil, contracts.ErrInvalidSchema{Field: "definition", Description: err.Error()}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub := s.subject(subjectName)
+
+	level := compatibility
+	if level == contracts.Compatibility_COMPATIBILITY_UNSPECIFIED {
+		level = sub.compatibility
+	}
+	if level == contracts.Compatibility_COMPATIBILITY_UNSPECIFIED {
+		level = s.compatibility
+	}
+
+	var latest *registeredSchema
+	if len(sub.versions) > 0 {
+		latest = sub.versions[len(sub.versions)-1]
+	}
+
+	state := &schemaState{Subject: subjectName}
+	if compatibility != sub.compatibility {
+		state.Compatibility = compatibility
+	}
+
+	if latest != nil && latest.info.Type == typ && latest.info.Definition == definition {
+		if state.Compatibility != contracts.Compatibility_COMPATIBILITY_UNSPECIFIED {
+			err = s.persist(state)
+			if err != nil {
+				return nil, err
+			}
+			sub.compatibility = compatibility
+		}
+		return latest.info, nil
+	}
+
+	if latest != nil {
+		err = schema.Check(level, latest.parsed, parsed)
+		if err != nil {
+			return nil, contracts.ErrIncompatibleSchema{
+				Subject:       subjectName,
+				Version:       latest.info.Version,
+				Compatibility: level,
+				Description:   err.Error(),
+			}
+		}
+	}
+
+	state.ID = s.nextID
+	state.Version = uint32(len(sub.versions)) + 1
+	state.Type = typ
+	state.Definition = definition
+
+	err = s.persist(state)
+	if err != nil {
+		return nil, err
+	}
+
+	s.add(state, parsed)
+
+	return s.byID[state.ID].info, nil
+}
+
+func (s *Schemas) persist(state *schemaState) error {
+	if s.journal == nil {
+		return nil
+	}
+
+	return appendJournal(s.journal, state)
+}
+
+func (s *Schemas) Get(id uint32) (*contracts.Schema, error) {
+	registered, err := s.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return registered.info, nil
+}
+
+// Validate returns why value doesn't conform to schema id.
+func (s *Schemas) Validate(id uint32, value string) error {
+	registered, err := s.lookup(id)
+	if err != nil {
+		return err
+	}
+
+	return registered.parsed.Validate(value)
+}
+
+// GetVersion returns version of subjectName, or its latest version for 0.
+func (s *Schemas) GetVersion(subjectName string, version uint32) (*contracts.Schema, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sub, ok := s.subjects[subjectName]
+	if !ok || len(sub.versions) == 0 || version > uint32(len(sub.versions)) {
+		return nil, contracts.ErrUnknownSchema{Subject: subjectName, Version: version}
+	}
+
+	if version == 0 {
+		return sub.versions[len(sub.versions)-1].info, nil
+	}
+
+	return sub.versions[version-1].info, nil
+}
+
+// List returns the versions of subjectName, and the level new ones are
+// checked at.
+func (s *Schemas) List(subjectName string) ([]*contracts.Schema, contracts.Compatibility, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sub, ok := s.subjects[subjectName]
+	if !ok || len(sub.versions) == 0 {
+		return nil, 0, contracts.ErrUnknownSchema{Subject: subjectName}
+	}
+
+	versions := make([]*contracts.Schema, len(sub.versions))
+	for i, registered := range sub.versions {
+		versions[i] = registered.info
+	}
+
+	compatibility := sub.compatibility
+	if compatibility == contracts.Compatibility_COMPATIBILITY_UNSPECIFIED {
+		compatibility = s.compatibility
+	}
+
+	return versions, compatibility, nil
+}
+
+func (s *Schemas) lookup(id uint32) (*registeredSchema, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	registered, ok := s.byID[id]
+	if !ok {
+		return nil, contracts.ErrUnknownSchema{ID: id}
+	}
+
+	return registered, nil
+}
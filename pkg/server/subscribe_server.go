@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"go.opencensus.io/stats"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// credits are what a subscriber has granted the server: how many more
+// records, and optionally bytes, it may send before waiting for more.
+type credits struct {
+	limitBytes bool
+
+	mu      sync.Mutex
+	records uint64
+	bytes   int64
+	granted chan struct{}
+}
+
+func newCredits(limitBytes bool) *credits {
+	return &credits{limitBytes: limitBytes, granted: make(chan struct{})}
+}
+
+func (c *credits) grant(req *contracts.SubscribeRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.records += req.CreditRecords
+	c.bytes += int64(req.CreditBytes)
+
+	close(c.granted)
+	c.granted = make(chan struct{})
+}
+
+// take uses up the credit for a record of size bytes. When there isn't
+// enough, it returns a channel that's closed on the next grant.
+func (c *credits) take(size int) (bool, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.records == 0 || (c.limitBytes && c.bytes <= 0) {
+		return false, c.granted
+	}
+
+	c.records--
+	c.bytes -= int64(size)
+
+	return true, nil
+}
+
+// openStream counts a consume stream against Config.MaxConsumeStreams, and
+// returns a func to call once it's done.
+func (g *grpcServer) openStream() (func(), error) {
+	n := g.streams.add(1)
+
+	if max := g.Config.MaxConsumeStreams; max > 0 && n > int64(max) {
+		g.streams.add(-1)
+		return nil, contracts.ErrOverloaded{RetryAfter: time.Second}
+	}
+
+	return func() { g.streams.add(-1) }, nil
+}
+
+func (g *grpcServer) Subscribe(stream contracts.Endpoints_SubscribeServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	if first.Consume == nil {
+		return status.Error(codes.InvalidArgument, "the first subscribe request must set consume")
+	}
+
+	release, err := g.openStream()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	credits := newCredits(first.CreditBytes > 0)
+	credits.grant(first)
+
+	// a half-closed subscriber keeps the credits it has already granted
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			credits.grant(req)
+		}
+	}()
+
+	return g.consume(first.Consume, stream, func(record *contracts.Record) error {
+		return g.acquire(stream.Context(), credits, record)
+	})
+}
+
+// acquire waits until the subscriber has credit for record.
+func (g *grpcServer) acquire(ctx context.Context, credits *credits, record *contracts.Record) error {
+	ok, granted := credits.take(valueSize(record))
+	if ok {
+		return nil
+	}
+
+	stats.Record(ctx, subscriberPauses.M(1))
+
+	g.paused.add(1)
+	defer g.paused.add(-1)
+
+	for !ok {
+		select {
+		case <-granted:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-g.Config.Health.Draining():
+			return contracts.ErrShuttingDown{NextIndex: record.Index}
+		}
+
+		ok, granted = credits.take(valueSize(record))
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+const defaultTransactionTimeout = time.Minute
+
+type transaction struct {
+	firstIndex uint64
+	hasRecords bool
+	deadline   time.Time
+	timer      *time.Timer
+}
+
+// transactions tracks open transactions. Their records are appended to the
+// log right away; read_committed consumers may read up to the first record
+// of the oldest open transaction, and skip records of aborted ones. Aborted
+// transactions are kept by the index of their marker, and forgotten once
+// the log is truncated past it.
+type transactions struct {
+	log     CommitLog
+	timeout time.Duration
+	logger  *zap.Logger
+
+	mu      sync.Mutex
+	nextID  uint64
+	open    map[uint64]*transaction
+	aborted map[uint64]uint64
+	pruned  uint64
+	changed chan struct{}
+}
+
+func newTransactions(log CommitLog, timeout time.Duration) *transactions {
+	if timeout <= 0 {
+		timeout = defaultTransactionTimeout
+	}
+
+	return &transactions{
+		log:     log,
+		timeout: timeout,
+		logger:  zap.L().Named("server"),
+		nextID:  1,
+		open:    map[uint64]*transaction{},
+		aborted: map[uint64]uint64{},
+		changed: make(chan struct{}),
+	}
+}
+
+func (t *transactions) begin() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := t.nextID
+	t.nextID++
+
+	t.open[id] = &transaction{
+		deadline: time.Now().Add(t.timeout),
+		timer:    time.AfterFunc(t.timeout, func() { t.expire(id) }),
+	}
+
+	return id
+}
+
+// add appends records to an open transaction. The append and the update of
+// the transaction's first index happen under one lock, so consumers never
+// see a transaction's record as stable.
+func (t *transactions) add(id uint64, records []*contracts.Record) ([]uint64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	txn, ok := t.open[id]
+	if !ok {
+		return nil, contracts.ErrTxnNotOpen{TxnID: id}
+	}
+
+	for _, record := range records {
+		record.TxnId = proto.Uint64(id)
+	}
+
+	indexes, err := t.log.AppendBatch(records)
+	if err != nil {
+		return nil, err
+	}
+
+	if !txn.hasRecords {
+		txn.firstIndex = indexes[0]
+		txn.hasRecords = true
+	}
+
+	txn.deadline = time.Now().Add(t.timeout)
+	txn.timer.Reset(t.timeout)
+
+	return indexes, nil
+}
+
+// end closes a transaction by appending a commit or abort marker, and
+// returns the marker's index.
+func (t *transactions) end(id uint64, control contracts.Control) (uint64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.endLocked(id, control)
+}
+
+func (t *transactions) endLocked(id uint64, control contracts.Control) (uint64, error) {
+	txn, ok := t.open[id]
+	if !ok {
+		return 0, contracts.ErrTxnNotOpen{TxnID: id}
+	}
+
+	index, err := t.log.Append(&contracts.Record{TxnId: proto.Uint64(id), Control: control.Enum()})
+	if err != nil {
+		return 0, err
+	}
+
+	txn.timer.Stop()
+	delete(t.open, id)
+
+	if control == contracts.Control_CONTROL_ABORT {
+		t.aborted[id] = index
+	}
+
+	t.pruneAborted()
+
+	close(t.changed)
+	t.changed = make(chan struct{})
+
+	return index, nil
+}
+
+// expire aborts a transaction nothing was added to for the timeout, which
+// is how transactions of producers that went away are cleaned up.
+func (t *transactions) expire(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// the transaction was added to while this timer fired, so it's been reset
+	txn, ok := t.open[id]
+	if !ok || time.Now().Before(txn.deadline) {
+		return
+	}
+
+	_, err := t.endLocked(id, contracts.Control_CONTROL_ABORT)
+	if err != nil {
+		return
+	}
+
+	t.logger.Info("aborted transaction after timeout", zap.Uint64("txn_id", id), zap.Duration("timeout", t.timeout))
+}
+
+// stable reports whether the record at index is before every open
+// transaction. If it isn't, the returned channel is closed once a
+// transaction ends.
+func (t *transactions) stable(index uint64) (bool, <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, txn := range t.open {
+		if txn.hasRecords && txn.firstIndex <= index {
+			return false, t.changed
+		}
+	}
+
+	return true, nil
+}
+
+// pruneAborted forgets aborted transactions whose records, which all come
+// before their marker, have been truncated from the log, so no reader can
+// come across them.
+func (t *transactions) pruneAborted() {
+	first := t.log.FirstIndex()
+	if first <= t.pruned {
+		return
+	}
+
+	t.pruned = first
+
+	for id, marker := range t.aborted {
+		if marker < first {
+			delete(t.aborted, id)
+		}
+	}
+}
+
+func (t *transactions) isAborted(id uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.aborted[id]
+	return ok
+}
+
+// hidden reports whether a stream should skip a record: markers are never
+// delivered, and read_committed streams skip aborted records.
+func (t *transactions) hidden(record *contracts.Record, readCommitted bool) bool {
+	if record.Control != nil {
+		return true
+	}
+
+	return readCommitted && record.TxnId != nil && t.isAborted(*record.TxnId)
+}
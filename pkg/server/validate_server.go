@@ -0,0 +1,193 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	contracts "github.com/w-h-a/grpc-server/contracts/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+// messageOverhead leaves room for protobuf framing on top of record values
+// when sizing grpc.MaxRecvMsgSize.
+const messageOverhead = 64 * 1024
+
+// defaultMaxRecvMsgSize is gRPC's own limit on received messages, which
+// applies when no record or batch size is configured.
+const defaultMaxRecvMsgSize = 4 * 1024 * 1024
+
+func (c *Config) maxRecvMsgSize() int {
+	size := c.MaxRecordSize
+	if c.MaxBatchSize > size {
+		size = c.MaxBatchSize
+	}
+
+	if size == 0 {
+		return 0
+	}
+
+	return size + messageOverhead
+}
+
+// validateRecord checks record, and its value against its schema when
+// checkSchema is set and the value isn't too large to be stored anyway.
+func (c *Config) validateRecord(field string, record *contracts.Record, checkSchema bool) []*errdetails.BadRequest_FieldViolation {
+	if record == nil {
+		return []*errdetails.BadRequest_FieldViolation{
+			{Field: field, Description: "record is required"},
+		}
+	}
+
+	var violations []*errdetails.BadRequest_FieldViolation
+
+	if record.TxnId != nil || record.Control != nil || record.Compression != nil || record.CompressedValue != nil {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: "txn_id, control, compression and compressed_value are set by the server",
+		})
+	}
+
+	if len(record.Value) == 0 && !c.AllowEmptyValues {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       field + ".value",
+			Description: "value must not be empty",
+		})
+	}
+
+	if c.MaxRecordSize > 0 && len(record.Value) > c.MaxRecordSize {
+		return append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       field + ".value",
+			Description: fmt.Sprintf("value is %d bytes, which exceeds the maximum of %d", len(record.Value), c.MaxRecordSize),
+		})
+	}
+
+	if !checkSchema {
+		return violations
+	}
+
+	return append(violations, c.validateSchema(field, record)...)
+}
+
+// validateSchema checks record's value against its schema, if it has one.
+func (c *Config) validateSchema(field string, record *contracts.Record) []*errdetails.BadRequest_FieldViolation {
+	if record.SchemaId == nil {
+		if c.RequireSchemas {
+			return []*errdetails.BadRequest_FieldViolation{
+				{Field: field + ".schema_id", Description: "schema_id is required"},
+			}
+		}
+		return nil
+	}
+
+	err := c.Schemas.Validate(*record.SchemaId, record.Value)
+
+	var unknown contracts.ErrUnknownSchema
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &unknown):
+		return []*errdetails.BadRequest_FieldViolation{
+			{Field: field + ".schema_id", Description: fmt.Sprintf("schema %d is not registered", *record.SchemaId)},
+		}
+	default:
+		return []*errdetails.BadRequest_FieldViolation{
+			{Field: field + ".value", Description: fmt.Sprintf("value doesn't conform to schema %d: %v", *record.SchemaId, err)},
+		}
+	}
+}
+
+func (c *Config) validateProduce(req *contracts.ProduceRequest) error {
+	violations := c.validateRecord("record", req.Record, true)
+
+	if req.TxnId != 0 && (req.ProducerId != 0 || req.ExpectedIndex != nil) {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       "txn_id",
+			Description: "transactions can't be combined with producer_id or expected_index",
+		})
+	}
+
+	if req.DeliverAt != nil {
+		switch {
+		case c.Scheduler == nil:
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{
+				Field:       "deliver_at",
+				Description: "this server doesn't schedule records",
+			})
+		case req.TxnId != 0 || req.ProducerId != 0 || req.ExpectedIndex != nil:
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{
+				Field:       "deliver_at",
+				Description: "scheduled records can't be combined with txn_id, producer_id or expected_index",
+			})
+		case req.DeliverAt.CheckValid() != nil:
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{
+				Field:       "deliver_at",
+				Description: req.DeliverAt.CheckValid().Error(),
+			})
+		}
+	}
+
+	violations = append(violations, validateCompression(req.Compression)...)
+
+	return invalidArgument(violations)
+}
+
+func (c *Config) validateProduceBatch(req *contracts.ProduceBatchRequest) error {
+	var violations []*errdetails.BadRequest_FieldViolation
+
+	if len(req.Records) == 0 {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       "records",
+			Description: "at least one record is required",
+		})
+	}
+
+	size := 0
+
+	for _, record := range req.Records {
+		size += len(record.GetValue())
+	}
+
+	// a batch too large to store isn't worth parsing against schemas
+	oversized := c.MaxBatchSize > 0 && size > c.MaxBatchSize
+
+	for i, record := range req.Records {
+		violations = append(violations, c.validateRecord(fmt.Sprintf("records[%d]", i), record, !oversized)...)
+	}
+
+	if oversized {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       "records",
+			Description: fmt.Sprintf("batch is %d bytes, which exceeds the maximum of %d", size, c.MaxBatchSize),
+		})
+	}
+
+	if req.TxnId != 0 && (req.ProducerId != 0 || req.ExpectedIndex != nil) {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       "txn_id",
+			Description: "transactions can't be combined with producer_id or expected_index",
+		})
+	}
+
+	violations = append(violations, validateCompression(req.Compression)...)
+
+	return invalidArgument(violations)
+}
+
+func validateCompression(codec contracts.Compression) []*errdetails.BadRequest_FieldViolation {
+	if _, ok := contracts.Compression_name[int32(codec)]; ok {
+		return nil
+	}
+
+	return []*errdetails.BadRequest_FieldViolation{
+		{Field: "compression", Description: fmt.Sprintf("unknown compression %d", codec)},
+	}
+}
+
+func invalidArgument(violations []*errdetails.BadRequest_FieldViolation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+
+	return contracts.ErrInvalidRecord{Violations: violations}
+}